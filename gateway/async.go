@@ -0,0 +1,305 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAsyncRetryIn  = 60 // seconds
+	defaultAsyncStateTTL = 7 * 24 * time.Hour
+	// asyncStoreKeyPrefix is used for the keys of states kept in the
+	// gateway's store.
+	asyncStoreKeyPrefix = "gw-async:"
+	// asyncBackendTimeout is how long to wait for the backend when saving
+	// or deleting states, as the task's own context may already be done.
+	asyncBackendTimeout = 5 * time.Second
+)
+
+// ErrAsyncTimeout is used for the KO result of async tasks that have not
+// completed within the timeout.
+var ErrAsyncTimeout = errors.New("async task timed out")
+
+// AsyncState contains the progress of an async task between invocations.
+// Handlers may update any of the fields, which will be kept for as long as
+// the task is queued.
+type AsyncState struct {
+	// Ref identifies the operation in the external system, and will be
+	// used as the result's Ref so that pokes can find the task.
+	Ref string `json:"ref,omitempty"`
+	// Phase may be used by handlers to keep track of multi-step operations.
+	Phase string `json:"phase,omitempty"`
+	// Data contains any JSON the handler needs to continue.
+	Data json.RawMessage `json:"data,omitempty"`
+	// Checks is the number of times the task has been resumed.
+	Checks int `json:"checks"`
+	// StartedTs and UpdatedTs are the unix times when the operation was
+	// started and when the state was last saved.
+	StartedTs int64 `json:"started_ts"`
+	UpdatedTs int64 `json:"updated_ts,omitempty"`
+}
+
+// SetData marshals the value as JSON into the state's data.
+func (st *AsyncState) SetData(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshalling async data: %w", err)
+	}
+	st.Data = data
+	return nil
+}
+
+// ReadData unmarshals the state's data into the value.
+func (st *AsyncState) ReadData(v any) error {
+	if len(st.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(st.Data, v); err != nil {
+		return fmt.Errorf("parsing async data: %w", err)
+	}
+	return nil
+}
+
+// AsyncTask models a task that depends on a long-running operation in an
+// external system as a resumable state machine. The first time the task is
+// received, Start is called to begin the operation. If Start responds with
+// a queued result, the state is saved and the gateway will send the task
+// again after the RetryIn period, or sooner if poked using the state's Ref,
+// at which point Check is called to determine if the operation has
+// completed. The state is removed once a final result is provided.
+//
+// An error result from Start implies that the operation was not started, so
+// Start will be called again on the next attempt. Error results from Check
+// leave the state untouched.
+type AsyncTask struct {
+	// Start begins the external operation, updating the state with the
+	// details needed to continue.
+	Start func(ctx context.Context, t *Task, st *AsyncState) *TaskResult
+
+	// Check determines the status of the operation, providing a queued
+	// result if it is still in progress.
+	Check func(ctx context.Context, t *Task, st *AsyncState) *TaskResult
+
+	// RetryIn is the default number of seconds to wait before checking again
+	// when a queued result is provided without a RetryIn. Defaults to 60.
+	RetryIn int32
+
+	// Timeout is how long after starting the operation to give up with
+	// a KO result if still queued. Zero implies no timeout.
+	Timeout time.Duration
+
+	// Backend overrides where the state is kept, which otherwise is the
+	// gateway's key-value store scoped to the task's owner.
+	Backend AsyncBackend
+}
+
+// AsyncBackend is used to keep the state of async tasks.
+type AsyncBackend interface {
+	// Load provides the task's state, or nil if there is none.
+	Load(ctx context.Context, t *Task) (*AsyncState, error)
+	// Save stores the task's state until the ttl expires.
+	Save(ctx context.Context, t *Task, st *AsyncState, ttl time.Duration) error
+	// Delete removes the task's state, if present.
+	Delete(ctx context.Context, t *Task) error
+}
+
+// WithAsyncTask configures the async task as the client's task handler.
+// Problems with the async task will be returned by NewWithError, Connect,
+// or Start.
+func WithAsyncTask(at *AsyncTask) Option {
+	return func(gw *Client) {
+		th, err := gw.AsyncTaskHandler(at)
+		if err != nil {
+			gw.err = err
+			return
+		}
+		gw.th = th
+	}
+}
+
+// AsyncTaskHandler provides a task handler that runs the async task, loading
+// and saving its state between invocations, for use when the task is one of
+// several routes in a Mux. An error is returned if the async task is
+// missing its Start or Check functions.
+func (gw *Client) AsyncTaskHandler(at *AsyncTask) (TaskHandler, error) {
+	switch {
+	case at == nil:
+		return nil, errors.New("async task required")
+	case at.Start == nil:
+		return nil, errors.New("async task start function required")
+	case at.Check == nil:
+		return nil, errors.New("async task check function required")
+	}
+	a := *at
+	if a.RetryIn <= 0 {
+		a.RetryIn = defaultAsyncRetryIn
+	}
+	if a.Backend == nil {
+		a.Backend = &storeAsyncBackend{gw: gw}
+	}
+	return a.handle, nil
+}
+
+func (at *AsyncTask) handle(ctx context.Context, t *Task) *TaskResult {
+	st, err := at.Backend.Load(ctx, t)
+	if err != nil {
+		return TaskError(fmt.Errorf("loading async state: %w", err))
+	}
+	started := st != nil
+	var res *TaskResult
+	if started {
+		st.Checks++
+		res = at.Check(ctx, t, st)
+	} else {
+		st = &AsyncState{StartedTs: time.Now().Unix()}
+		res = at.Start(ctx, t, st)
+	}
+	if res == nil {
+		res = TaskOK()
+	}
+	if res.Status == TaskStatus_QUEUED && at.timedOut(st) {
+		res = TaskKO(ErrAsyncTimeout)
+	}
+
+	switch {
+	case res.Status == TaskStatus_QUEUED:
+		if res.RetryIn <= 0 {
+			res.RetryIn = at.RetryIn
+		}
+		if err := at.save(ctx, t, st); err != nil {
+			return TaskError(fmt.Errorf("saving async state: %w", err))
+		}
+	case res.Status == TaskStatus_ERR:
+		// try again later with the same state
+		return res
+	case started:
+		if err := at.delete(ctx, t); err != nil {
+			log.Warn().Str("task_id", t.Id).Err(err).Msg("gateway: deleting async state")
+		}
+	}
+	if res.Ref == "" {
+		res.Ref = st.Ref
+	}
+	return res
+}
+
+func (at *AsyncTask) save(ctx context.Context, t *Task, st *AsyncState) error {
+	st.UpdatedTs = time.Now().Unix()
+	ttl := defaultAsyncStateTTL
+	if at.Timeout > 0 {
+		// keep the state long enough for the timeout to be detected
+		ttl = at.Timeout + time.Duration(at.RetryIn)*time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), asyncBackendTimeout)
+	defer cancel()
+	return at.Backend.Save(ctx, t, st, ttl)
+}
+
+func (at *AsyncTask) delete(ctx context.Context, t *Task) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), asyncBackendTimeout)
+	defer cancel()
+	return at.Backend.Delete(ctx, t)
+}
+
+func (at *AsyncTask) timedOut(st *AsyncState) bool {
+	if at.Timeout <= 0 {
+		return false
+	}
+	return time.Since(time.Unix(st.StartedTs, 0)) > at.Timeout
+}
+
+// MemoryAsyncBackend keeps async task states in memory, which is only
+// suitable when a single instance of the service is running.
+type MemoryAsyncBackend struct {
+	mu     sync.Mutex
+	states map[string]*memoryAsyncEntry
+	now    func() time.Time
+}
+
+type memoryAsyncEntry struct {
+	st      AsyncState
+	expires time.Time
+}
+
+// NewMemoryAsyncBackend instantiates a new in-memory async backend.
+func NewMemoryAsyncBackend() *MemoryAsyncBackend {
+	return &MemoryAsyncBackend{
+		states: make(map[string]*memoryAsyncEntry),
+		now:    time.Now,
+	}
+}
+
+// Load provides a copy of the task's state, if not expired.
+func (b *MemoryAsyncBackend) Load(_ context.Context, t *Task) (*AsyncState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.states[t.Id]
+	if !ok {
+		return nil, nil
+	}
+	if b.now().After(e.expires) {
+		delete(b.states, t.Id)
+		return nil, nil
+	}
+	st := e.st
+	return &st, nil
+}
+
+// Save stores a copy of the task's state.
+func (b *MemoryAsyncBackend) Save(_ context.Context, t *Task, st *AsyncState, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.states[t.Id] = &memoryAsyncEntry{st: *st, expires: b.now().Add(ttl)}
+	return nil
+}
+
+// Delete removes the task's state.
+func (b *MemoryAsyncBackend) Delete(_ context.Context, t *Task) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.states, t.Id)
+	return nil
+}
+
+// storeAsyncBackend keeps async task states in the gateway's store.
+type storeAsyncBackend struct {
+	gw *Client
+}
+
+func (b *storeAsyncBackend) Load(ctx context.Context, t *Task) (*AsyncState, error) {
+	st, _, err := StoreGetJSON[AsyncState](ctx, b.gw, &StoreGet{
+		OwnerId: t.OwnerId,
+		Key:     asyncStoreKeyPrefix + t.Id,
+	})
+	if IsNotFoundError(err) {
+		return nil, nil
+	}
+	return st, err
+}
+
+func (b *storeAsyncBackend) Save(ctx context.Context, t *Task, st *AsyncState, ttl time.Duration) error {
+	_, err := StoreSetJSON(ctx, b.gw, &StoreSet{
+		OwnerId: t.OwnerId,
+		Key:     asyncStoreKeyPrefix + t.Id,
+		Ttl:     int32(min(math.Ceil(ttl.Seconds()), math.MaxInt32)),
+	}, st)
+	return err
+}
+
+func (b *storeAsyncBackend) Delete(ctx context.Context, t *Task) error {
+	err := b.gw.StoreDelete(ctx, &StoreDelete{
+		OwnerId: t.OwnerId,
+		Key:     asyncStoreKeyPrefix + t.Id,
+	})
+	if IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type submission struct {
+	URL string `json:"url"`
+}
+
+func newTestAsyncTask(b AsyncBackend) *AsyncTask {
+	return &AsyncTask{
+		Start: func(_ context.Context, t *Task, st *AsyncState) *TaskResult {
+			if t.Action == "fail" {
+				return TaskError(errors.New("unavailable"))
+			}
+			st.Ref = "ext-" + t.Id
+			st.Phase = "submitted"
+			if err := st.SetData(&submission{URL: "https://example.com/" + t.Id}); err != nil {
+				return TaskError(err)
+			}
+			return TaskQueued("submitted", 0)
+		},
+		Check: func(_ context.Context, t *Task, st *AsyncState) *TaskResult {
+			sub := new(submission)
+			if err := st.ReadData(sub); err != nil {
+				return TaskError(err)
+			}
+			if st.Checks < 2 {
+				return TaskQueued("pending", 10)
+			}
+			return &TaskResult{Status: TaskStatus_OK, Message: sub.URL}
+		},
+		Backend: b,
+	}
+}
+
+func TestAsyncTask(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("complete", func(t *testing.T) {
+		b := NewMemoryAsyncBackend()
+		h, err := New().AsyncTaskHandler(newTestAsyncTask(b))
+		require.NoError(t, err)
+		task := &Task{Id: "task-1"}
+
+		res := h(ctx, task)
+		assert.Equal(t, TaskStatus_QUEUED, res.Status)
+		assert.EqualValues(t, defaultAsyncRetryIn, res.RetryIn)
+		assert.Equal(t, "ext-task-1", res.Ref)
+		st, err := b.Load(ctx, task)
+		require.NoError(t, err)
+		require.NotNil(t, st)
+		assert.Equal(t, "submitted", st.Phase)
+		assert.NotZero(t, st.StartedTs)
+
+		res = h(ctx, task)
+		assert.Equal(t, TaskStatus_QUEUED, res.Status)
+		assert.EqualValues(t, 10, res.RetryIn)
+		st, err = b.Load(ctx, task)
+		require.NoError(t, err)
+		assert.Equal(t, 1, st.Checks)
+
+		res = h(ctx, task)
+		assert.Equal(t, TaskStatus_OK, res.Status)
+		assert.Equal(t, "https://example.com/task-1", res.Message)
+		assert.Equal(t, "ext-task-1", res.Ref)
+		st, err = b.Load(ctx, task)
+		require.NoError(t, err)
+		assert.Nil(t, st, "state removed")
+	})
+
+	t.Run("start error", func(t *testing.T) {
+		b := NewMemoryAsyncBackend()
+		h, err := New().AsyncTaskHandler(newTestAsyncTask(b))
+		require.NoError(t, err)
+		task := &Task{Id: "task-1", Action: "fail"}
+		res := h(ctx, task)
+		assert.Equal(t, TaskStatus_ERR, res.Status)
+		st, err := b.Load(ctx, task)
+		require.NoError(t, err)
+		assert.Nil(t, st, "not started")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		b := NewMemoryAsyncBackend()
+		at := newTestAsyncTask(b)
+		at.Timeout = time.Minute
+		h, err := New().AsyncTaskHandler(at)
+		require.NoError(t, err)
+		task := &Task{Id: "task-1"}
+		old := &AsyncState{Ref: "ext-1", StartedTs: time.Now().Add(-time.Hour).Unix()}
+		require.NoError(t, b.Save(ctx, task, old, time.Hour))
+
+		res := h(ctx, task)
+		assert.Equal(t, TaskStatus_KO, res.Status)
+		assert.Equal(t, ErrAsyncTimeout.Error(), res.Message)
+		assert.Equal(t, "ext-1", res.Ref)
+		st, err := b.Load(ctx, task)
+		require.NoError(t, err)
+		assert.Nil(t, st)
+	})
+
+	t.Run("backend deadline", func(t *testing.T) {
+		b := &deadlineAsyncBackend{MemoryAsyncBackend: NewMemoryAsyncBackend()}
+		h, err := New().AsyncTaskHandler(newTestAsyncTask(b))
+		require.NoError(t, err)
+		task := &Task{Id: "task-1"}
+		for range 3 {
+			h(ctx, task)
+		}
+		assert.True(t, b.saved)
+		assert.True(t, b.deleted)
+	})
+}
+
+// deadlineAsyncBackend records whether states are saved and deleted with a
+// deadline.
+type deadlineAsyncBackend struct {
+	*MemoryAsyncBackend
+	saved, deleted bool
+}
+
+func (b *deadlineAsyncBackend) Save(ctx context.Context, t *Task, st *AsyncState, ttl time.Duration) error {
+	_, b.saved = ctx.Deadline()
+	return b.MemoryAsyncBackend.Save(ctx, t, st, ttl)
+}
+
+func (b *deadlineAsyncBackend) Delete(ctx context.Context, t *Task) error {
+	_, b.deleted = ctx.Deadline()
+	return b.MemoryAsyncBackend.Delete(ctx, t)
+}
+
+func TestAsyncTaskWithStore(t *testing.T) {
+	var done atomic.Bool
+	_, nc := startGateway(t, nil, WithAsyncTask(&AsyncTask{
+		Start: func(_ context.Context, task *Task, st *AsyncState) *TaskResult {
+			st.Ref = "ext-" + task.Id
+			return TaskQueued("submitted", 0)
+		},
+		Check: func(_ context.Context, _ *Task, _ *AsyncState) *TaskResult {
+			if !done.Load() {
+				return TaskQueued("pending", 0)
+			}
+			return TaskOK()
+		},
+	}))
+	s := runTestStore(t, nc)
+
+	task := &Task{Id: "task-1", OwnerId: "owner"}
+	res := sendTask(t, nc, task)
+	assert.Equal(t, TaskStatus_QUEUED, res.Status)
+	assert.Equal(t, "ext-task-1", res.Ref)
+	assert.EqualValues(t, defaultAsyncRetryIn, res.RetryIn)
+	require.NotNil(t, s.entry("owner", "test", "gw-async:task-1"))
+
+	task.Ref = res.Ref
+	res = sendTask(t, nc, task)
+	assert.Equal(t, TaskStatus_QUEUED, res.Status)
+
+	done.Store(true)
+	res = sendTask(t, nc, task)
+	assert.Equal(t, TaskStatus_OK, res.Status)
+	assert.Nil(t, s.entry("owner", "test", "gw-async:task-1"))
+}
+
+func TestAsyncTaskValidation(t *testing.T) {
+	check := func(_ context.Context, _ *Task, _ *AsyncState) *TaskResult {
+		return TaskOK()
+	}
+	_, err := New().AsyncTaskHandler(nil)
+	assert.EqualError(t, err, "async task required")
+	_, err = New().AsyncTaskHandler(&AsyncTask{Check: check})
+	assert.EqualError(t, err, "async task start function required")
+	_, err = New().AsyncTaskHandler(&AsyncTask{Start: check})
+	assert.EqualError(t, err, "async task check function required")
+
+	_, err = NewWithError(WithName("test"), WithAsyncTask(&AsyncTask{Start: check}))
+	assert.EqualError(t, err, "async task check function required")
+	gw := New(WithName("test"), WithAsyncTask(&AsyncTask{Start: check}))
+	assert.EqualError(t, gw.Start(), "async task check function required")
+}
+
+func TestMemoryAsyncBackend(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryAsyncBackend()
+	tn := time.Now()
+	b.now = func() time.Time { return tn }
+	task := &Task{Id: "a"}
+	st := &AsyncState{Ref: "ref-1"}
+	require.NoError(t, b.Save(ctx, task, st, time.Minute))
+	st.Ref = "changed"
+
+	out, err := b.Load(ctx, task)
+	require.NoError(t, err)
+	assert.Equal(t, "ref-1", out.Ref, "states are copied")
+
+	tn = tn.Add(2 * time.Minute)
+	out, err = b.Load(ctx, task)
+	require.NoError(t, err)
+	assert.Nil(t, out, "expired")
+	assert.Empty(t, b.states)
+}
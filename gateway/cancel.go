@@ -0,0 +1,139 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrTaskCancelled is the cause provided by task handler contexts when they
+// are cancelled following a TaskCancel request.
+var ErrTaskCancelled = errors.New("task cancelled")
+
+// taskRegistry keeps track of the tasks currently being processed so that
+// they can be cancelled.
+type taskRegistry struct {
+	mu    sync.Mutex
+	tasks map[*Task]context.CancelCauseFunc
+}
+
+func (r *taskRegistry) add(t *Task, cancel context.CancelCauseFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tasks == nil {
+		r.tasks = make(map[*Task]context.CancelCauseFunc)
+	}
+	r.tasks[t] = cancel
+}
+
+func (r *taskRegistry) remove(t *Task) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tasks, t)
+}
+
+// ids provides the IDs of the tasks in the registry.
+func (r *taskRegistry) ids() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0, len(r.tasks))
+	for t := range r.tasks {
+		ids = append(ids, t.Id)
+	}
+	return ids
+}
+
+// cancel stops all the tasks matching the request and provides their IDs.
+func (r *taskRegistry) cancel(req *TaskCancel) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cause := ErrTaskCancelled
+	if req.Reason != "" {
+		cause = fmt.Errorf("%w: %s", ErrTaskCancelled, req.Reason)
+	}
+	var ids []string
+	for t, cancel := range r.tasks {
+		if (req.Id != "" && t.Id == req.Id) || (req.JobId != "" && t.JobId == req.JobId) {
+			cancel(cause)
+			ids = append(ids, t.Id)
+		}
+	}
+	return ids
+}
+
+// WithTaskCancellation subscribes each instance of the service to the
+// "gw.<name>.cancel" subject so that in-flight tasks can be cancelled with
+// TaskCancel requests. Every instance receives the requests, rather than a
+// single member of a queue group, as any of them could be processing the
+// task. Requests with a reply subject will receive a TaskCancelResponse from
+// each instance, with a not found error if none of its tasks matched.
+func WithTaskCancellation() Option {
+	return func(gw *Client) {
+		gw.cancellation = true
+	}
+}
+
+// subscribeCancel listens for requests to cancel tasks.
+func (gw *Client) subscribeCancel() error {
+	subj := fmt.Sprintf(SubjectTaskCancelFmt, gw.name)
+	var err error
+	gw.cancelSub, err = gw.nc.Subscribe(subj, gw.processCancel)
+	return err
+}
+
+func (gw *Client) processCancel(m *nats.Msg) {
+	req := new(TaskCancel)
+	if err := proto.Unmarshal(m.Data, req); err != nil {
+		log.Error().Err(err).Msg("gateway: parsing task cancel request")
+		gw.respondCancel(m, &TaskCancelResponse{
+			Err: &Error{Code: ErrorCode_INVALID, Message: "invalid cancel request"},
+		})
+		return
+	}
+	if req.Id == "" && req.JobId == "" {
+		gw.respondCancel(m, &TaskCancelResponse{
+			Err: &Error{Code: ErrorCode_INVALID, Message: "task or job id required"},
+		})
+		return
+	}
+	ids := gw.running.cancel(req)
+	if len(ids) == 0 {
+		gw.respondCancel(m, &TaskCancelResponse{
+			Err: &Error{Code: ErrorCode_NOT_FOUND, Message: "task not found"},
+		})
+		return
+	}
+	log.Info().Strs("task_ids", ids).Str("reason", req.Reason).Msg("gateway: tasks cancelled")
+	gw.respondCancel(m, &TaskCancelResponse{TaskIds: ids})
+}
+
+// respondCancel sends the response if the request expects one.
+func (gw *Client) respondCancel(m *nats.Msg, res *TaskCancelResponse) {
+	if m.Reply == "" {
+		return
+	}
+	data, err := proto.Marshal(res)
+	if err != nil {
+		log.Error().Err(err).Msg("gateway: marshalling task cancel response")
+		return
+	}
+	if err := m.Respond(data); err != nil {
+		log.Error().Err(err).Msg("gateway: responding to task cancel request")
+	}
+}
+
+// cancelledResult checks if the task was cancelled while the handler was
+// running, and if so provides the result to send instead, unless the
+// handler managed to complete successfully.
+func cancelledResult(ctx context.Context, res *TaskResult) *TaskResult {
+	cause := context.Cause(ctx)
+	if res.Status == TaskStatus_OK || !errors.Is(cause, ErrTaskCancelled) {
+		return res
+	}
+	return TaskCancelled(cause.Error())
+}
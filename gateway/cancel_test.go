@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTaskRegistryCancel(t *testing.T) {
+	r := new(taskRegistry)
+	tasks := []*Task{
+		{Id: "t1", JobId: "j1"},
+		{Id: "t2", JobId: "j1"},
+		{Id: "t3", JobId: "j2"},
+	}
+	ctxs := make(map[string]context.Context)
+	for _, task := range tasks {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		ctxs[task.Id] = ctx
+		r.add(task, cancel)
+	}
+
+	assert.Equal(t, []string{"t3"}, r.cancel(&TaskCancel{Id: "t3"}))
+	assert.ErrorIs(t, context.Cause(ctxs["t3"]), ErrTaskCancelled)
+	assert.NoError(t, ctxs["t1"].Err())
+
+	r.remove(tasks[1])
+	assert.Equal(t, []string{"t1"}, r.cancel(&TaskCancel{JobId: "j1", Reason: "stop"}))
+	assert.EqualError(t, context.Cause(ctxs["t1"]), "task cancelled: stop")
+	assert.NoError(t, ctxs["t2"].Err())
+
+	assert.Empty(t, r.cancel(&TaskCancel{Id: "missing"}))
+}
+
+func TestCancelledResult(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(ErrTaskCancelled)
+	assert.Equal(t, TaskStatus_OK, cancelledResult(ctx, TaskOK()).Status)
+	res := cancelledResult(ctx, TaskError(ctx.Err()))
+	assert.Equal(t, TaskStatus_CANCEL, res.Status)
+	assert.Equal(t, "task cancelled", res.Message)
+
+	res = cancelledResult(context.Background(), TaskSkip("skip"))
+	assert.Equal(t, TaskStatus_SKIP, res.Status)
+}
+
+// cancelTask sends the request to the service's instances, providing the
+// first response.
+func cancelTask(t *testing.T, nc *nats.Conn, req *TaskCancel) *TaskCancelResponse {
+	t.Helper()
+	data, err := proto.Marshal(req)
+	require.NoError(t, err)
+	m, err := nc.Request("gw.test.cancel", data, 5*time.Second)
+	require.NoError(t, err)
+	res := new(TaskCancelResponse)
+	require.NoError(t, proto.Unmarshal(m.Data, res))
+	return res
+}
+
+func TestCancelTask(t *testing.T) {
+	t.Run("cancel", func(t *testing.T) {
+		started := make(chan struct{}, 1)
+		var cause atomic.Value
+		_, nc := startGateway(t, func(ctx context.Context, task *Task) *TaskResult {
+			if task.Action == "quick" {
+				return TaskOK()
+			}
+			started <- struct{}{}
+			<-ctx.Done()
+			cause.Store(context.Cause(ctx))
+			return TaskError(ctx.Err())
+		}, WithTaskCancellation())
+
+		res := sendTask(t, nc, &Task{Id: "task-0", JobId: "job-1", Action: "quick"})
+		assert.Equal(t, TaskStatus_OK, res.Status)
+
+		results := make(chan *TaskResult, 1)
+		go func() {
+			res, err := requestTask(nc, &Task{Id: "task-1", JobId: "job-1"})
+			assert.NoError(t, err)
+			results <- res
+		}()
+		<-started
+
+		cr := cancelTask(t, nc, &TaskCancel{JobId: "other"})
+		assert.True(t, IsNotFoundError(cr.Err))
+		assert.Empty(t, cr.TaskIds)
+
+		cr = cancelTask(t, nc, &TaskCancel{})
+		assert.True(t, IsValidationError(cr.Err))
+
+		cr = cancelTask(t, nc, &TaskCancel{JobId: "job-1", Reason: "user request"})
+		assert.Nil(t, cr.Err)
+		assert.Equal(t, []string{"task-1"}, cr.TaskIds)
+
+		res = waitForResult(t, results)
+		require.NotNil(t, res)
+		assert.Equal(t, TaskStatus_CANCEL, res.Status)
+		assert.Equal(t, "task cancelled: user request", res.Message)
+		assert.ErrorIs(t, cause.Load().(error), ErrTaskCancelled)
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		_, nc := startGateway(t, func(_ context.Context, _ *Task) *TaskResult {
+			return TaskOK()
+		})
+		data, err := proto.Marshal(&TaskCancel{Id: "task-1"})
+		require.NoError(t, err)
+		_, err = nc.Request("gw.test.cancel", data, time.Second)
+		assert.ErrorIs(t, err, nats.ErrNoResponders)
+	})
+}
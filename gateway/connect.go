@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/invopop/client.go/internal/retry"
+	nats "github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// ConnectionHooks are called when the state of the NATS connection
+// established from the configuration changes. When not provided, each event
+// will be logged.
+type ConnectionHooks struct {
+	// Connected is called once the initial connection has been established.
+	Connected func(nc *nats.Conn)
+	// Disconnected is called when the connection is lost.
+	Disconnected func(nc *nats.Conn, err error)
+	// Reconnected is called after a successful reconnection.
+	Reconnected func(nc *nats.Conn)
+	// ReconnectFailed is called after each failed reconnection attempt.
+	ReconnectFailed func(nc *nats.Conn, err error)
+	// Closed is called once the connection has been closed and no more
+	// reconnections will be attempted.
+	Closed func(nc *nats.Conn)
+}
+
+// WithConnectionHooks sets the functions to call as the NATS connection
+// established using WithConfig changes state, replacing the default log
+// messages.
+func WithConnectionHooks(h *ConnectionHooks) Option {
+	return func(gw *Client) {
+		gw.hooks = h
+	}
+}
+
+// WithConnectRetryPolicy sets the policy used to retry the initial NATS
+// connection established using WithConfig, for example to wait for the
+// servers to become available when booting. By default only a single
+// attempt is made.
+func WithConnectRetryPolicy(rp *RetryPolicy) Option {
+	return func(gw *Client) {
+		gw.connRetry = rp.withDefaults()
+	}
+}
+
+// NewWithError instantiates a new gateway client in the same way as New, but
+// will return any problems found while preparing the options. The NATS
+// connection can then be established with Connect before calling Start:
+//
+//	gw, err := gateway.NewWithError(
+//		gateway.WithConfig(conf),
+//		gateway.WithConnectRetryPolicy(&gateway.RetryPolicy{MaxAttempts: 10}),
+//		gateway.WithTaskHandler(handler),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	if err := gw.Connect(ctx); err != nil {
+//		return err
+//	}
+func NewWithError(opts ...Option) (*Client, error) {
+	gw := newClient(opts)
+	if gw.err != nil {
+		return nil, gw.err
+	}
+	return gw, nil
+}
+
+// Connect establishes the NATS connection using the configuration provided
+// with WithConfig, retrying according to the connect retry policy until the
+// context is done. Nothing will be done if a connection is already available.
+// Connections established by the client will be drained and closed by
+// Shutdown. Connect is not safe to call concurrently.
+func (gw *Client) Connect(ctx context.Context) error {
+	if gw.err != nil {
+		return gw.err
+	}
+	if gw.nc != nil {
+		return nil
+	}
+	if gw.natsConf == nil {
+		return errors.New("nats connection required")
+	}
+	opts, err := gw.natsConf.Options()
+	if err != nil {
+		return fmt.Errorf("preparing nats options: %w", err)
+	}
+	opts = append(opts, nats.Name(gw.name))
+	opts = append(opts, gw.connectionHooks().options()...)
+
+	rp := gw.connRetry
+	if rp == nil {
+		rp = &RetryPolicy{MaxAttempts: 1}
+	}
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("connecting to nats: %w", err)
+		}
+		nc, err := nats.Connect(gw.natsConf.URL, opts...)
+		if err == nil {
+			gw.nc = nc
+			gw.ownConn = true
+			return nil
+		}
+		if attempt >= rp.MaxAttempts {
+			return fmt.Errorf("connecting to nats: %w", err)
+		}
+		wait := rp.backoff(attempt)
+		log.Warn().Err(err).Int("attempt", attempt).Dur("wait", wait).Msg("gateway: nats connection failed, retrying")
+		if !retry.Sleep(ctx, wait) {
+			return fmt.Errorf("connecting to nats: %w", err)
+		}
+	}
+}
+
+func (gw *Client) connectionHooks() *ConnectionHooks {
+	if gw.hooks != nil {
+		return gw.hooks
+	}
+	url := gw.natsConf.URL
+	return &ConnectionHooks{
+		Connected: func(_ *nats.Conn) {
+			log.Info().Str("url", url).Msg("nats connected")
+		},
+		Disconnected: func(_ *nats.Conn, err error) {
+			log.Warn().Str("url", url).Err(err).Msg("nats disconnected")
+		},
+		Reconnected: func(_ *nats.Conn) {
+			log.Info().Str("url", url).Msg("nats reconnected")
+		},
+		ReconnectFailed: func(_ *nats.Conn, err error) {
+			log.Warn().Str("url", url).Err(err).Msg("nats reconnect error")
+		},
+		Closed: func(_ *nats.Conn) {
+			log.Warn().Str("url", url).Msg("nats closed")
+		},
+	}
+}
+
+func (h *ConnectionHooks) options() []nats.Option {
+	var opts []nats.Option
+	if h.Connected != nil {
+		opts = append(opts, nats.ConnectHandler(h.Connected))
+	}
+	if h.Disconnected != nil {
+		opts = append(opts, nats.DisconnectErrHandler(h.Disconnected))
+	}
+	if h.Reconnected != nil {
+		opts = append(opts, nats.ReconnectHandler(h.Reconnected))
+	}
+	if h.ReconnectFailed != nil {
+		opts = append(opts, nats.ReconnectErrHandler(h.ReconnectFailed))
+	}
+	if h.Closed != nil {
+		opts = append(opts, nats.ClosedHandler(h.Closed))
+	}
+	return opts
+}
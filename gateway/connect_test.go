@@ -0,0 +1,214 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/invopop/configure/pkg/natsconf"
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close() // nolint:errcheck
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func newNATSServer(t *testing.T, port int) *server.Server {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   port,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(ns.Shutdown)
+	return ns
+}
+
+func startNATSServer(t *testing.T, port int) {
+	t.Helper()
+	ns := newNATSServer(t, port)
+	ns.Start()
+	require.True(t, ns.ReadyForConnections(5*time.Second), "nats server not ready")
+}
+
+func testNATSConfig(port int) *Config {
+	return &Config{
+		Name: "test",
+		NATS: &natsconf.Config{URL: fmt.Sprintf("nats://127.0.0.1:%d", port)},
+	}
+}
+
+func TestNewWithError(t *testing.T) {
+	t.Run("invalid options", func(t *testing.T) {
+		conf := testNATSConfig(4222)
+		conf.NATS.TLS.Cert = "missing.crt"
+		conf.NATS.TLS.Key = "missing.key"
+		conf.NATS.TLS.CA = "missing.ca"
+		_, err := NewWithError(WithConfig(conf))
+		assert.ErrorContains(t, err, "preparing nats options")
+
+		gw := New(WithConfig(conf), WithTaskHandler(func(_ context.Context, _ *Task) *TaskResult {
+			return nil
+		}))
+		assert.ErrorContains(t, gw.Start(), "preparing nats options")
+	})
+
+	t.Run("missing config", func(t *testing.T) {
+		_, err := NewWithError(WithConfig(&Config{Name: "test"}))
+		assert.ErrorContains(t, err, "missing nats config")
+	})
+
+	t.Run("does not connect", func(t *testing.T) {
+		gw, err := NewWithError(WithConfig(testNATSConfig(freePort(t))))
+		require.NoError(t, err)
+		assert.Nil(t, gw.NATS())
+	})
+
+	t.Run("new connects", func(t *testing.T) {
+		port := freePort(t)
+		startNATSServer(t, port)
+		gw := New(WithConfig(testNATSConfig(port)))
+		require.NotNil(t, gw.NATS())
+		t.Cleanup(gw.NATS().Close)
+		assert.True(t, gw.NATS().IsConnected())
+	})
+
+	t.Run("new connection failed", func(t *testing.T) {
+		gw := New(
+			WithConfig(testNATSConfig(freePort(t))),
+			WithTaskHandler(func(_ context.Context, _ *Task) *TaskResult { return nil }),
+		)
+		assert.Nil(t, gw.NATS())
+		assert.ErrorContains(t, gw.Start(), "connecting to nats")
+	})
+}
+
+func TestConnect(t *testing.T) {
+	t.Run("success with hooks", func(t *testing.T) {
+		port := freePort(t)
+		startNATSServer(t, port)
+		connected := make(chan string, 1)
+		closed := make(chan struct{})
+		gw, err := NewWithError(
+			WithConfig(testNATSConfig(port)),
+			WithConnectionHooks(&ConnectionHooks{
+				Connected: func(nc *nats.Conn) { connected <- nc.Opts.Name },
+				Closed:    func(_ *nats.Conn) { close(closed) },
+			}),
+		)
+		require.NoError(t, err)
+		require.NoError(t, gw.Connect(context.Background()))
+		require.NotNil(t, gw.NATS())
+		assert.Equal(t, "test", <-connected)
+
+		nc := gw.NATS()
+		require.NoError(t, gw.Connect(context.Background()))
+		assert.Same(t, nc, gw.NATS(), "already connected")
+
+		nc.Close()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("closed hook not called")
+		}
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		port := freePort(t)
+		ns := newNATSServer(t, port)
+		time.AfterFunc(100*time.Millisecond, ns.Start)
+		gw, err := NewWithError(
+			WithConfig(testNATSConfig(port)),
+			WithConnectRetryPolicy(&RetryPolicy{MaxAttempts: 50, MinWait: 20 * time.Millisecond, MaxWait: 50 * time.Millisecond}),
+		)
+		require.NoError(t, err)
+		require.NoError(t, gw.Connect(context.Background()))
+		gw.NATS().Close()
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		gw, err := NewWithError(
+			WithConfig(testNATSConfig(freePort(t))),
+			WithConnectRetryPolicy(&RetryPolicy{MaxAttempts: 2, MinWait: time.Millisecond}),
+		)
+		require.NoError(t, err)
+		err = gw.Connect(context.Background())
+		assert.ErrorContains(t, err, "connecting to nats")
+		assert.ErrorIs(t, err, nats.ErrNoServers)
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		gw, err := NewWithError(
+			WithConfig(testNATSConfig(freePort(t))),
+			WithConnectRetryPolicy(&RetryPolicy{MaxAttempts: 100, MinWait: 10 * time.Millisecond}),
+		)
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = gw.Connect(ctx)
+		assert.ErrorContains(t, err, "connecting to nats")
+		assert.Nil(t, gw.NATS())
+	})
+
+	t.Run("start connects", func(t *testing.T) {
+		port := freePort(t)
+		startNATSServer(t, port)
+		gw, err := NewWithError(
+			WithConfig(testNATSConfig(port)),
+			WithTaskHandler(func(_ context.Context, _ *Task) *TaskResult { return nil }),
+		)
+		require.NoError(t, err)
+		require.NoError(t, gw.Start())
+		nc := gw.NATS()
+		assert.True(t, nc.IsConnected())
+
+		gw.Stop()
+		assert.Eventually(t, nc.IsClosed, time.Second, 10*time.Millisecond, "own connection closed")
+	})
+
+	t.Run("start failure closes connection", func(t *testing.T) {
+		port := freePort(t)
+		startNATSServer(t, port)
+		conns := make(chan *nats.Conn, 1)
+		gw, err := NewWithError(
+			WithConfig(testNATSConfig(port)),
+			WithConnectionHooks(&ConnectionHooks{
+				Connected: func(nc *nats.Conn) { conns <- nc },
+			}),
+			WithJetStream(&JetStream{Stream: "MISSING"}),
+			WithTaskHandler(func(_ context.Context, _ *Task) *TaskResult { return nil }),
+		)
+		require.NoError(t, err)
+		assert.ErrorContains(t, gw.Start(), "subscribing to jetstream")
+		assert.Nil(t, gw.NATS())
+		nc := <-conns
+		assert.True(t, nc.IsClosed())
+	})
+
+	t.Run("provided connection left open", func(t *testing.T) {
+		port := freePort(t)
+		startNATSServer(t, port)
+		nc, err := nats.Connect(fmt.Sprintf("nats://127.0.0.1:%d", port))
+		require.NoError(t, err)
+		t.Cleanup(nc.Close)
+		gw := New(
+			WithName("test"),
+			WithNATS(nc),
+			WithTaskHandler(func(_ context.Context, _ *Task) *TaskResult { return nil }),
+		)
+		require.NoError(t, gw.Start())
+		gw.Stop()
+		assert.True(t, nc.IsConnected())
+	})
+}
@@ -0,0 +1,310 @@
+// Package decode provides helpers to parse the config and GOBL envelope
+// included in gateway tasks, with optional JSON Schema and GOBL validation,
+// providing KO results with the details of each invalid field ready to send
+// back to the gateway.
+//
+// Usage example:
+//
+//	conf, res := decode.Config[Config](task, decode.WithReflectedSchema())
+//	if res != nil {
+//		return res
+//	}
+//	inv, env, res := decode.Document[*bill.Invoice](task, decode.WithValidation())
+//	if res != nil {
+//		return res
+//	}
+package decode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/invopop/client.go/gateway"
+	"github.com/invopop/gobl"
+	ijsonschema "github.com/invopop/jsonschema"
+	"github.com/rs/zerolog/log"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// configSchemaURL is used as a placeholder location when compiling schemas.
+const configSchemaURL = "config.json"
+
+// schemaCache holds compiled JSON schemas by their source, while
+// reflectedSchemas holds the schemas generated for each config type.
+var (
+	schemaCache      sync.Map
+	reflectedSchemas sync.Map
+)
+
+// Option is used to configure how task payloads are decoded.
+type Option func(o *options)
+
+type options struct {
+	schema   []byte
+	reflect  bool
+	validate bool
+}
+
+// WithJSONSchema validates the task's config against the provided JSON
+// Schema document before decoding.
+func WithJSONSchema(schema []byte) Option {
+	return func(o *options) {
+		o.schema = schema
+	}
+}
+
+// WithReflectedSchema validates the task's config against a JSON Schema
+// generated from the config type using the invopop/jsonschema package, so
+// that struct tags such as `jsonschema:"required"` will be respected.
+func WithReflectedSchema() Option {
+	return func(o *options) {
+		o.reflect = true
+	}
+}
+
+// WithValidation will call the decoded object's Validate method, if it has
+// one, after decoding. GOBL envelopes will be validated according to GOBL's
+// rules.
+func WithValidation() Option {
+	return func(o *options) {
+		o.validate = true
+	}
+}
+
+// Config parses the task's config into a new instance of T. Decoding and
+// validation problems are provided as a KO result containing details of
+// each field with an error, ready to send back to the gateway:
+//
+//	conf, res := decode.Config[Config](task, decode.WithValidation())
+//	if res != nil {
+//		return res
+//	}
+//
+// Problems preparing the schema itself are not the task's fault, so are
+// logged and provided as an error result so that the task will be retried.
+// An empty config will be treated as an empty JSON object.
+func Config[T any](t *gateway.Task, opts ...Option) (*T, *gateway.TaskResult) {
+	o := prepareOptions(opts)
+	data := t.Config
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte("{}")
+	}
+	conf := new(T)
+	if o.schema != nil || o.reflect {
+		sch, err := configSchema(o, conf)
+		if err != nil {
+			log.Error().Str("task_id", t.Id).Err(err).Msg("gateway: preparing config schema")
+			return nil, gateway.TaskError(fmt.Errorf("preparing config schema: %w", err))
+		}
+		if err := validateSchema(sch, data); err != nil {
+			return nil, failure("invalid config", err)
+		}
+	}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, failure("invalid config", err)
+	}
+	if o.validate {
+		if v, ok := any(conf).(interface{ Validate() error }); ok {
+			if err := v.Validate(); err != nil {
+				return nil, failure("invalid config", err)
+			}
+		}
+	}
+	return conf, nil
+}
+
+// Envelope parses the task's envelope data. Use Document to also extract
+// the document.
+func Envelope(t *gateway.Task, opts ...Option) (*gobl.Envelope, *gateway.TaskResult) {
+	o := prepareOptions(opts)
+	if len(t.Envelope) == 0 {
+		return nil, gateway.TaskKO(errors.New("missing envelope"))
+	}
+	env := new(gobl.Envelope)
+	if err := json.Unmarshal(t.Envelope, env); err != nil {
+		return nil, failure("invalid envelope", err)
+	}
+	if o.validate {
+		if err := env.Validate(); err != nil {
+			return nil, failure("invalid envelope", err)
+		}
+	}
+	return env, nil
+}
+
+// Document parses the task's envelope and extracts the document, ensuring
+// it is of the expected type, for example:
+//
+//	inv, env, res := decode.Document[*bill.Invoice](task)
+//	if res != nil {
+//		return res
+//	}
+func Document[T any](t *gateway.Task, opts ...Option) (T, *gobl.Envelope, *gateway.TaskResult) {
+	var doc T
+	env, res := Envelope(t, opts...)
+	if res != nil {
+		return doc, nil, res
+	}
+	obj := env.Extract()
+	if obj == nil {
+		return doc, nil, gateway.TaskKO(errors.New("envelope missing document"))
+	}
+	doc, ok := obj.(T)
+	if !ok {
+		return doc, nil, gateway.TaskKO(fmt.Errorf("unexpected document type %T, expected %T", obj, doc))
+	}
+	return doc, env, nil
+}
+
+func prepareOptions(opts []Option) *options {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// configSchema provides the compiled schema to validate the config with,
+// either as provided in the options or reflected from the type of v.
+func configSchema(o *options, v any) (*jsonschema.Schema, error) {
+	schema := o.schema
+	if schema == nil {
+		var err error
+		if schema, err = reflectSchema(v); err != nil {
+			return nil, err
+		}
+	}
+	return compileSchema(schema)
+}
+
+// reflectSchema generates the JSON schema for the type of v.
+func reflectSchema(v any) ([]byte, error) {
+	typ := reflect.TypeOf(v)
+	if s, ok := reflectedSchemas.Load(typ); ok {
+		return s.([]byte), nil
+	}
+	schema, err := json.Marshal(ijsonschema.Reflect(v))
+	if err != nil {
+		return nil, err
+	}
+	reflectedSchemas.Store(typ, schema)
+	return schema, nil
+}
+
+// compileSchema prepares the JSON schema, caching it for next time.
+func compileSchema(schema []byte) (*jsonschema.Schema, error) {
+	if s, ok := schemaCache.Load(string(schema)); ok {
+		return s.(*jsonschema.Schema), nil
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(configSchemaURL, doc); err != nil {
+		return nil, fmt.Errorf("adding schema: %w", err)
+	}
+	sch, err := c.Compile(configSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("compiling schema: %w", err)
+	}
+	schemaCache.Store(string(schema), sch)
+	return sch, nil
+}
+
+// validateSchema checks the data against the compiled JSON schema.
+func validateSchema(sch *jsonschema.Schema, data []byte) error {
+	v, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := sch.Validate(v); err != nil {
+		var ve *jsonschema.ValidationError
+		if errors.As(err, &ve) {
+			return &schemaError{ve}
+		}
+		return err
+	}
+	return nil
+}
+
+// failure prepares a KO result with the details of any fields that could
+// not be decoded or failed validation.
+func failure(msg string, err error) *gateway.TaskResult {
+	res := gateway.TaskKOWithFaults(err)
+	if len(res.Faults) == 0 {
+		res.Message = fmt.Sprintf("%s: %s", msg, err)
+		return res
+	}
+	parts := make([]string, len(res.Faults))
+	for i, f := range res.Faults {
+		parts[i] = strings.Join(f.Paths, ", ") + ": " + f.Message
+	}
+	res.Message = fmt.Sprintf("%s: %s", msg, strings.Join(parts, "; "))
+	return res
+}
+
+// schemaError wraps JSON schema validation errors so that they can be
+// provided as faults.
+type schemaError struct {
+	*jsonschema.ValidationError
+}
+
+func (e *schemaError) Unwrap() error {
+	return e.ValidationError
+}
+
+// Faults flattens the nested validation errors into a list of faults, one
+// for each problem found.
+func (e *schemaError) Faults() []*gateway.Fault {
+	return schemaFaults(e.ValidationError, message.NewPrinter(language.English))
+}
+
+func schemaFaults(ve *jsonschema.ValidationError, p *message.Printer) []*gateway.Fault {
+	if len(ve.Causes) > 0 {
+		var faults []*gateway.Fault
+		for _, c := range ve.Causes {
+			faults = append(faults, schemaFaults(c, p)...)
+		}
+		return faults
+	}
+	f := &gateway.Fault{
+		Message: ve.ErrorKind.LocalizedString(p),
+	}
+	if kp := ve.ErrorKind.KeywordPath(); len(kp) > 0 {
+		f.Code = kp[len(kp)-1]
+	}
+	path := jsonPath(ve.InstanceLocation)
+	if r, ok := ve.ErrorKind.(*kind.Required); ok {
+		for _, m := range r.Missing {
+			f.Paths = append(f.Paths, path+"."+m)
+		}
+	}
+	if len(f.Paths) == 0 {
+		f.Paths = []string{path}
+	}
+	return []*gateway.Fault{f}
+}
+
+// jsonPath converts the JSON pointer tokens into a JSON Path expression.
+func jsonPath(tokens []string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, t := range tokens {
+		if _, err := strconv.Atoi(t); err == nil {
+			b.WriteString("[" + t + "]")
+			continue
+		}
+		b.WriteString("." + t)
+	}
+	return b.String()
+}
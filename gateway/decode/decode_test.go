@@ -0,0 +1,171 @@
+package decode
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/invopop/client.go/gateway"
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/note"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Name  string   `json:"name" jsonschema:"minLength=1"`
+	Count int      `json:"count,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func (c *testConfig) Validate() error {
+	if c.Name == "invalid" {
+		return errors.New("name is invalid")
+	}
+	return nil
+}
+
+const testConfigSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string"},
+		"count": {"type": "integer", "minimum": 1},
+		"tags": {"type": "array", "items": {"type": "string"}}
+	},
+	"required": ["name"]
+}`
+
+func decodeFaults(t *testing.T, res *gateway.TaskResult) []*gateway.Fault {
+	t.Helper()
+	require.NotEmpty(t, res.Fields)
+	return res.Faults
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		task := &gateway.Task{Config: []byte(`{"name":"test","count":2}`)}
+		conf, res := Config[testConfig](task, WithJSONSchema([]byte(testConfigSchema)))
+		require.Nil(t, res)
+		assert.Equal(t, "test", conf.Name)
+		assert.Equal(t, 2, conf.Count)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		conf, res := Config[testConfig](new(gateway.Task))
+		require.Nil(t, res)
+		assert.Empty(t, conf.Name)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		_, res := Config[testConfig](&gateway.Task{Config: []byte(`{"name":`)})
+		require.NotNil(t, res)
+		assert.Equal(t, gateway.TaskStatus_KO, res.Status)
+		assert.Contains(t, res.Message, "invalid config")
+		assert.Empty(t, res.Fields)
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		_, res := Config[testConfig](&gateway.Task{Config: []byte(`{"name":"test","count":"two"}`)})
+		require.NotNil(t, res)
+		assert.Equal(t, gateway.TaskStatus_KO, res.Status)
+		faults := decodeFaults(t, res)
+		require.Len(t, faults, 1)
+		assert.Equal(t, []string{"$.count"}, faults[0].Paths)
+	})
+
+	t.Run("schema", func(t *testing.T) {
+		task := &gateway.Task{Config: []byte(`{"count":0,"tags":["a",1]}`)}
+		_, res := Config[testConfig](task, WithJSONSchema([]byte(testConfigSchema)))
+		require.NotNil(t, res)
+		assert.Equal(t, gateway.TaskStatus_KO, res.Status)
+		faults := decodeFaults(t, res)
+		paths := make(map[string]string)
+		for _, f := range faults {
+			for _, p := range f.Paths {
+				paths[p] = f.Code
+			}
+		}
+		assert.Equal(t, map[string]string{
+			"$.name":    "required",
+			"$.count":   "minimum",
+			"$.tags[1]": "type",
+		}, paths)
+		assert.Contains(t, res.Message, "$.name: missing property 'name'")
+	})
+
+	t.Run("reflected schema", func(t *testing.T) {
+		_, res := Config[testConfig](&gateway.Task{Config: []byte(`{"name":""}`)}, WithReflectedSchema())
+		require.NotNil(t, res)
+		faults := decodeFaults(t, res)
+		require.Len(t, faults, 1)
+		assert.Equal(t, []string{"$.name"}, faults[0].Paths)
+		assert.Equal(t, "minLength", faults[0].Code)
+
+		conf, res := Config[testConfig](&gateway.Task{Config: []byte(`{"name":"test"}`)}, WithReflectedSchema())
+		require.Nil(t, res)
+		assert.Equal(t, "test", conf.Name)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		task := &gateway.Task{Config: []byte(`{"name":"invalid"}`)}
+		_, res := Config[testConfig](task)
+		assert.Nil(t, res, "validation only when requested")
+
+		_, res = Config[testConfig](task, WithValidation())
+		require.NotNil(t, res)
+		assert.Equal(t, gateway.TaskStatus_KO, res.Status)
+		assert.Equal(t, "invalid config: name is invalid", res.Message)
+	})
+
+	t.Run("broken schema", func(t *testing.T) {
+		task := &gateway.Task{Config: []byte(`{"name":"test"}`)}
+		for _, schema := range []string{`{"type":`, `{"type":"unknown"}`} {
+			_, res := Config[testConfig](task, WithJSONSchema([]byte(schema)))
+			require.NotNil(t, res)
+			assert.Equal(t, gateway.TaskStatus_ERR, res.Status, "schema problems can be retried")
+			assert.Contains(t, res.Message, "preparing config schema")
+		}
+	})
+}
+
+func TestDocument(t *testing.T) {
+	env, err := gobl.Envelop(&note.Message{Content: "hello"})
+	require.NoError(t, err)
+	data, err := json.Marshal(env)
+	require.NoError(t, err)
+	task := &gateway.Task{Envelope: data}
+
+	t.Run("envelope", func(t *testing.T) {
+		env, res := Envelope(task, WithValidation())
+		require.Nil(t, res)
+		assert.NotNil(t, env.Document)
+	})
+
+	t.Run("document", func(t *testing.T) {
+		msg, env, res := Document[*note.Message](task)
+		require.Nil(t, res)
+		assert.Equal(t, "hello", msg.Content)
+		assert.NotNil(t, env)
+	})
+
+	t.Run("unexpected type", func(t *testing.T) {
+		_, _, res := Document[*bill.Invoice](task)
+		require.NotNil(t, res)
+		assert.Equal(t, gateway.TaskStatus_KO, res.Status)
+		assert.Equal(t, "unexpected document type *note.Message, expected *bill.Invoice", res.Message)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, _, res := Document[*note.Message](new(gateway.Task))
+		require.NotNil(t, res)
+		assert.Equal(t, "missing envelope", res.Message)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, res := Envelope(&gateway.Task{Envelope: []byte(`{"doc":`)})
+		require.NotNil(t, res)
+		assert.Equal(t, gateway.TaskStatus_KO, res.Status)
+		assert.Contains(t, res.Message, "invalid envelope")
+	})
+}
@@ -0,0 +1,292 @@
+package gateway
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultDedupeWindow = 10 * time.Minute
+	// dedupeStoreKeyPrefix is used for the keys of results kept in the
+	// gateway's store.
+	dedupeStoreKeyPrefix = "gw-dedupe:"
+	// dedupeRetryIn is the number of seconds to wait before retrying a
+	// duplicate task when the original is still in progress.
+	dedupeRetryIn = 5
+	// dedupeStoreTimeout is how long to wait for the backend when keeping a
+	// result, as the task's own context may already be done.
+	dedupeStoreTimeout = 5 * time.Second
+)
+
+// Dedupe defines how duplicate tasks with the same ID should be suppressed.
+// Tasks received while another with the same ID is being processed by the
+// client will wait for the original to complete and reply with the same
+// result. Final results (OK, KO, and SKIP) are also kept for the window so
+// that tasks delivered again are not processed twice. Errors, queued, and
+// cancelled results are never kept as the task may be sent again to be
+// retried.
+type Dedupe struct {
+	// Window is how long to keep final results. Defaults to 10 minutes.
+	Window time.Duration
+
+	// Store, when true, will keep results in the gateway's key-value store
+	// scoped to the task's owner, so that they are shared between all the
+	// instances of the service. Otherwise, results are kept in memory.
+	Store bool
+
+	// Backend overrides where final results are kept.
+	Backend DedupeBackend
+}
+
+// DedupeBackend is used to keep the final results of tasks.
+type DedupeBackend interface {
+	// Get provides the result previously stored for the task, or nil if
+	// there is none.
+	Get(ctx context.Context, t *Task) (*TaskResult, error)
+	// Set stores the task's result until the ttl expires.
+	Set(ctx context.Context, t *Task, res *TaskResult, ttl time.Duration) error
+}
+
+// WithDedupe enables suppression of duplicate tasks.
+func WithDedupe(d *Dedupe) Option {
+	return func(gw *Client) {
+		gw.dedupe = d
+	}
+}
+
+type deduper struct {
+	window  time.Duration
+	backend DedupeBackend
+	mu      sync.Mutex
+	active  map[string]*dedupeCall
+}
+
+// dedupeCall tracks a task being processed so that duplicates may wait for
+// the result.
+type dedupeCall struct {
+	done chan struct{}
+	res  *TaskResult
+	dups int // duplicates received, protected by the deduper's mutex
+}
+
+func newDeduper(gw *Client, d *Dedupe) *deduper {
+	dd := &deduper{
+		window:  d.Window,
+		backend: d.Backend,
+		active:  make(map[string]*dedupeCall),
+	}
+	if dd.window <= 0 {
+		dd.window = defaultDedupeWindow
+	}
+	if dd.backend == nil {
+		if d.Store {
+			dd.backend = &storeDedupeBackend{gw: gw}
+		} else {
+			dd.backend = NewMemoryDedupeBackend()
+		}
+	}
+	return dd
+}
+
+// middleware provides the task middleware that suppresses duplicates.
+func (dd *deduper) middleware(next TaskHandler) TaskHandler {
+	return func(ctx context.Context, t *Task) *TaskResult {
+		if t.Id == "" {
+			return next(ctx, t)
+		}
+		call, dup := dd.start(t.Id)
+		if dup {
+			return dd.wait(ctx, t, call)
+		}
+		var res *TaskResult
+		defer func() {
+			// always called so that duplicates are not left waiting
+			dd.finish(t.Id, call, res)
+		}()
+		if res = dd.cached(ctx, t); res != nil {
+			return res
+		}
+		if res = next(ctx, t); res == nil {
+			res = TaskOK()
+		}
+		// results of cancelled tasks are replaced before being sent, so
+		// must not be kept in case the job retries the task
+		res = cancelledResult(ctx, res)
+		// the result should be kept even if the task's context is done
+		sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dedupeStoreTimeout)
+		defer cancel()
+		dd.store(sctx, t, res)
+		return res
+	}
+}
+
+// start registers the task as in progress, or provides the existing call
+// if there is one.
+func (dd *deduper) start(id string) (*dedupeCall, bool) {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	if call, ok := dd.active[id]; ok {
+		call.dups++
+		return call, true
+	}
+	call := &dedupeCall{done: make(chan struct{})}
+	dd.active[id] = call
+	return call, false
+}
+
+func (dd *deduper) finish(id string, call *dedupeCall, res *TaskResult) {
+	dd.mu.Lock()
+	delete(dd.active, id)
+	dd.mu.Unlock()
+	call.res = res
+	close(call.done)
+}
+
+func (dd *deduper) wait(ctx context.Context, t *Task, call *dedupeCall) *TaskResult {
+	log.Debug().Str("task_id", t.Id).Msg("gateway: waiting for duplicate task")
+	select {
+	case <-call.done:
+		if call.res == nil {
+			// the original task panicked
+			return TaskQueued("duplicate task failed", dedupeRetryIn)
+		}
+		return cloneResult(call.res)
+	case <-ctx.Done():
+		return TaskQueued("duplicate task in progress", dedupeRetryIn)
+	}
+}
+
+func (dd *deduper) cached(ctx context.Context, t *Task) *TaskResult {
+	res, err := dd.backend.Get(ctx, t)
+	if err != nil {
+		log.Warn().Str("task_id", t.Id).Err(err).Msg("gateway: fetching task result")
+		return nil
+	}
+	if res != nil {
+		log.Debug().Str("task_id", t.Id).Msg("gateway: replaying task result")
+	}
+	return res
+}
+
+func (dd *deduper) store(ctx context.Context, t *Task, res *TaskResult) {
+	if !isFinalStatus(res.Status) {
+		return
+	}
+	if err := dd.backend.Set(ctx, t, res, dd.window); err != nil {
+		log.Warn().Str("task_id", t.Id).Err(err).Msg("gateway: storing task result")
+	}
+}
+
+// isFinalStatus returns true if the task will not be sent again by the
+// gateway with the status unless it is duplicated. Cancelled tasks may be
+// retried later by the job, so their results are not final.
+func isFinalStatus(st TaskStatus) bool {
+	switch st {
+	case TaskStatus_OK, TaskStatus_KO, TaskStatus_SKIP:
+		return true
+	}
+	return false
+}
+
+func cloneResult(res *TaskResult) *TaskResult {
+	if res == nil {
+		return nil
+	}
+	return proto.Clone(res).(*TaskResult)
+}
+
+// MemoryDedupeBackend keeps task results in memory.
+type MemoryDedupeBackend struct {
+	mu      sync.Mutex
+	results map[string]*memoryDedupeEntry
+	swept   time.Time
+	now     func() time.Time
+}
+
+type memoryDedupeEntry struct {
+	res     *TaskResult
+	expires time.Time
+}
+
+// NewMemoryDedupeBackend instantiates a new in-memory dedupe backend.
+func NewMemoryDedupeBackend() *MemoryDedupeBackend {
+	return &MemoryDedupeBackend{
+		results: make(map[string]*memoryDedupeEntry),
+		swept:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+// Get provides the result stored for the task, if not expired.
+func (b *MemoryDedupeBackend) Get(_ context.Context, t *Task) (*TaskResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.results[t.Id]
+	if !ok || b.now().After(e.expires) {
+		return nil, nil
+	}
+	return cloneResult(e.res), nil
+}
+
+// Set stores the task's result, removing any expired results at most once
+// per ttl period.
+func (b *MemoryDedupeBackend) Set(_ context.Context, t *Task, res *TaskResult, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tn := b.now()
+	if tn.Sub(b.swept) > ttl {
+		for id, e := range b.results {
+			if tn.After(e.expires) {
+				delete(b.results, id)
+			}
+		}
+		b.swept = tn
+	}
+	b.results[t.Id] = &memoryDedupeEntry{res: cloneResult(res), expires: tn.Add(ttl)}
+	return nil
+}
+
+// storeDedupeBackend keeps task results in the gateway's store.
+type storeDedupeBackend struct {
+	gw *Client
+}
+
+func (b *storeDedupeBackend) Get(ctx context.Context, t *Task) (*TaskResult, error) {
+	if t.OwnerId == "" {
+		return nil, nil
+	}
+	e, err := b.gw.StoreGet(ctx, &StoreGet{OwnerId: t.OwnerId, Key: dedupeStoreKeyPrefix + t.Id})
+	if err != nil {
+		if IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	res := new(TaskResult)
+	if err := proto.Unmarshal(e.Value, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *storeDedupeBackend) Set(ctx context.Context, t *Task, res *TaskResult, ttl time.Duration) error {
+	if t.OwnerId == "" {
+		return nil
+	}
+	data, err := proto.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = b.gw.StoreSet(ctx, &StoreSet{
+		OwnerId: t.OwnerId,
+		Key:     dedupeStoreKeyPrefix + t.Id,
+		Value:   data,
+		Ttl:     int32(min(math.Ceil(ttl.Seconds()), math.MaxInt32)),
+	})
+	return err
+}
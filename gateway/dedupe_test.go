@@ -0,0 +1,258 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// duplicates provides the number of duplicates received while the task
+// is in progress.
+func duplicates(dd *deduper, id string) int {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	if call, ok := dd.active[id]; ok {
+		return call.dups
+	}
+	return 0
+}
+
+func TestDedupe(t *testing.T) {
+	t.Run("concurrent duplicates", func(t *testing.T) {
+		th, wait, release := blockingHandler(t)
+		var calls atomic.Int32
+		dd := newDeduper(nil, &Dedupe{})
+		h := dd.middleware(func(ctx context.Context, task *Task) *TaskResult {
+			calls.Add(1)
+			th(ctx, task)
+			return &TaskResult{Status: TaskStatus_OK, Ref: "done"}
+		})
+		ctx := context.Background()
+
+		results := make(chan *TaskResult, 3)
+		var wg sync.WaitGroup
+		for range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results <- h(ctx, &Task{Id: "task-1"})
+			}()
+		}
+		wait(1)
+		assert.Eventually(t, func() bool {
+			return duplicates(dd, "task-1") == 2
+		}, time.Second, time.Millisecond, "duplicates waiting")
+		release()
+		wg.Wait()
+		close(results)
+
+		assert.EqualValues(t, 1, calls.Load())
+		for res := range results {
+			assert.Equal(t, TaskStatus_OK, res.Status)
+			assert.Equal(t, "done", res.Ref)
+		}
+		assert.Empty(t, dd.active)
+	})
+
+	t.Run("replays final results", func(t *testing.T) {
+		var calls atomic.Int32
+		dd := newDeduper(nil, &Dedupe{})
+		h := dd.middleware(func(_ context.Context, _ *Task) *TaskResult {
+			calls.Add(1)
+			return TaskKO(errors.New("bad"))
+		})
+		ctx := context.Background()
+		res := h(ctx, &Task{Id: "task-1"})
+		assert.Equal(t, TaskStatus_KO, res.Status)
+		res = h(ctx, &Task{Id: "task-1"})
+		assert.Equal(t, TaskStatus_KO, res.Status)
+		assert.Equal(t, "bad", res.Message)
+		assert.EqualValues(t, 1, calls.Load())
+
+		h(ctx, &Task{Id: "task-2"})
+		assert.EqualValues(t, 2, calls.Load())
+	})
+
+	t.Run("ignores tasks without id", func(t *testing.T) {
+		var calls atomic.Int32
+		h := newDeduper(nil, &Dedupe{}).middleware(func(_ context.Context, _ *Task) *TaskResult {
+			calls.Add(1)
+			return nil
+		})
+		h(context.Background(), &Task{})
+		h(context.Background(), &Task{})
+		assert.EqualValues(t, 2, calls.Load())
+	})
+
+	t.Run("only keeps final results", func(t *testing.T) {
+		results := map[TaskStatus]*TaskResult{
+			TaskStatus_OK:     TaskOK(),
+			TaskStatus_KO:     TaskKO(errors.New("bad")),
+			TaskStatus_SKIP:   TaskSkip("skip"),
+			TaskStatus_ERR:    TaskError(errors.New("unavailable")),
+			TaskStatus_QUEUED: TaskQueued("later", 1),
+			TaskStatus_CANCEL: TaskCancelled("task cancelled"),
+		}
+		for st, res := range results {
+			t.Run(st.String(), func(t *testing.T) {
+				var calls atomic.Int32
+				h := newDeduper(nil, &Dedupe{}).middleware(func(_ context.Context, _ *Task) *TaskResult {
+					calls.Add(1)
+					return cloneResult(res)
+				})
+				h(context.Background(), &Task{Id: "task-1"})
+				out := h(context.Background(), &Task{Id: "task-1"})
+				assert.Equal(t, st, out.Status)
+				if isFinalStatus(st) {
+					assert.EqualValues(t, 1, calls.Load(), "replayed")
+				} else {
+					assert.EqualValues(t, 2, calls.Load(), "processed again")
+				}
+			})
+		}
+		assert.True(t, isFinalStatus(TaskStatus_OK))
+		assert.True(t, isFinalStatus(TaskStatus_KO))
+		assert.True(t, isFinalStatus(TaskStatus_SKIP))
+	})
+
+	t.Run("duplicate context done", func(t *testing.T) {
+		th, wait, release := blockingHandler(t)
+		h := newDeduper(nil, &Dedupe{}).middleware(th)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			h(context.Background(), &Task{Id: "task-1"})
+		}()
+		wait(1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		res := h(ctx, &Task{Id: "task-1"})
+		assert.Equal(t, TaskStatus_QUEUED, res.Status)
+		assert.EqualValues(t, dedupeRetryIn, res.RetryIn)
+		release()
+		<-done
+	})
+
+	t.Run("original panics", func(t *testing.T) {
+		th, wait, release := blockingHandler(t)
+		dd := newDeduper(nil, &Dedupe{})
+		h := dd.middleware(func(ctx context.Context, task *Task) *TaskResult {
+			th(ctx, task)
+			panic("boom")
+		})
+		go func() {
+			defer func() { _ = recover() }()
+			h(context.Background(), &Task{Id: "task-1"})
+		}()
+		wait(1)
+		results := make(chan *TaskResult, 1)
+		go func() {
+			results <- h(context.Background(), &Task{Id: "task-1"})
+		}()
+		assert.Eventually(t, func() bool {
+			return duplicates(dd, "task-1") == 1
+		}, time.Second, time.Millisecond, "duplicate waiting")
+		release()
+		res := <-results
+		assert.Equal(t, TaskStatus_QUEUED, res.Status)
+	})
+
+	t.Run("ignores cancelled results", func(t *testing.T) {
+		var calls atomic.Int32
+		dd := newDeduper(nil, &Dedupe{})
+		h := dd.middleware(func(_ context.Context, _ *Task) *TaskResult {
+			calls.Add(1)
+			return TaskKO(errors.New("interrupted"))
+		})
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(ErrTaskCancelled)
+		res := h(ctx, &Task{Id: "task-1"})
+		assert.Equal(t, TaskStatus_CANCEL, res.Status)
+
+		res = h(context.Background(), &Task{Id: "task-1"})
+		assert.Equal(t, TaskStatus_KO, res.Status)
+		assert.EqualValues(t, 2, calls.Load())
+	})
+
+	t.Run("store deadline", func(t *testing.T) {
+		b := &deadlineDedupeBackend{MemoryDedupeBackend: NewMemoryDedupeBackend()}
+		h := newDeduper(nil, &Dedupe{Backend: b}).middleware(func(_ context.Context, _ *Task) *TaskResult {
+			return TaskOK()
+		})
+		h(context.Background(), &Task{Id: "task-1"})
+		assert.True(t, b.deadline)
+	})
+
+	t.Run("custom backend", func(t *testing.T) {
+		b := NewMemoryDedupeBackend()
+		require.NoError(t, b.Set(context.Background(), &Task{Id: "task-1"}, TaskSkip("old"), time.Minute))
+		h := newDeduper(nil, &Dedupe{Backend: b}).middleware(func(_ context.Context, _ *Task) *TaskResult {
+			t.Error("handler should not be called")
+			return nil
+		})
+		res := h(context.Background(), &Task{Id: "task-1"})
+		assert.Equal(t, TaskStatus_SKIP, res.Status)
+	})
+}
+
+// deadlineDedupeBackend records whether results are stored with a deadline.
+type deadlineDedupeBackend struct {
+	*MemoryDedupeBackend
+	deadline bool
+}
+
+func (b *deadlineDedupeBackend) Set(ctx context.Context, t *Task, res *TaskResult, ttl time.Duration) error {
+	_, b.deadline = ctx.Deadline()
+	return b.MemoryDedupeBackend.Set(ctx, t, res, ttl)
+}
+
+func TestMemoryDedupeBackend(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryDedupeBackend()
+	tn := time.Now()
+	b.now = func() time.Time { return tn }
+	require.NoError(t, b.Set(ctx, &Task{Id: "a"}, TaskOK(), time.Minute))
+	res, err := b.Get(ctx, &Task{Id: "a"})
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	res.Message = "changed"
+	res, err = b.Get(ctx, &Task{Id: "a"})
+	require.NoError(t, err)
+	assert.Empty(t, res.Message, "results are copied")
+
+	tn = tn.Add(2 * time.Minute)
+	res, err = b.Get(ctx, &Task{Id: "a"})
+	require.NoError(t, err)
+	assert.Nil(t, res, "expired")
+
+	require.NoError(t, b.Set(ctx, &Task{Id: "b"}, TaskOK(), time.Minute))
+	assert.Len(t, b.results, 1, "expired results removed")
+}
+
+func TestDedupeWithStore(t *testing.T) {
+	var calls atomic.Int32
+	_, nc := startGateway(t, func(_ context.Context, task *Task) *TaskResult {
+		calls.Add(1)
+		return &TaskResult{Status: TaskStatus_OK, Ref: task.Id}
+	}, WithDedupe(&Dedupe{Store: true, Window: time.Minute}))
+	s := runTestStore(t, nc)
+
+	task := &Task{Id: "task-1", OwnerId: "owner"}
+	res := sendTask(t, nc, task)
+	assert.Equal(t, TaskStatus_OK, res.Status)
+	res = sendTask(t, nc, task)
+	assert.Equal(t, TaskStatus_OK, res.Status)
+	assert.Equal(t, "task-1", res.Ref)
+	assert.EqualValues(t, 1, calls.Load())
+
+	se := s.entry("owner", "test", "gw-dedupe:task-1")
+	require.NotNil(t, se)
+	assert.InDelta(t, time.Now().Add(time.Minute).Unix(), se.ExpiresTs, 2)
+}
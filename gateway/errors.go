@@ -42,3 +42,11 @@ func IsNotFoundError(err error) bool {
 	}
 	return false
 }
+
+// IsConflictError returns true if the resource was modified or already exists.
+func IsConflictError(err error) bool {
+	if e := AsError(err); e != nil {
+		return e.Code == ErrorCode_CONFLICT
+	}
+	return false
+}
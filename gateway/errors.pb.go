@@ -27,6 +27,7 @@ const (
 	ErrorCode_INTERNAL  ErrorCode = 0
 	ErrorCode_INVALID   ErrorCode = 1
 	ErrorCode_NOT_FOUND ErrorCode = 2 // The requested resource was not found.
+	ErrorCode_CONFLICT  ErrorCode = 3 // The resource was modified or already exists.
 )
 
 // Enum value maps for ErrorCode.
@@ -35,11 +36,13 @@ var (
 		0: "INTERNAL",
 		1: "INVALID",
 		2: "NOT_FOUND",
+		3: "CONFLICT",
 	}
 	ErrorCode_value = map[string]int32{
 		"INTERNAL":  0,
 		"INVALID":   1,
 		"NOT_FOUND": 2,
+		"CONFLICT":  3,
 	}
 )
 
@@ -134,12 +137,13 @@ var file_errors_proto_rawDesc = []byte{
 	0x6f, 0x70, 0x6f, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x2e, 0x76, 0x31,
 	0x2e, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x43, 0x6f, 0x64, 0x65, 0x52, 0x04, 0x63, 0x6f, 0x64, 0x65,
 	0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2a, 0x35, 0x0a, 0x09, 0x45, 0x72,
+	0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2a, 0x43, 0x0a, 0x09, 0x45, 0x72,
 	0x72, 0x6f, 0x72, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x0c, 0x0a, 0x08, 0x49, 0x4e, 0x54, 0x45, 0x52,
 	0x4e, 0x41, 0x4c, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x49, 0x4e, 0x56, 0x41, 0x4c, 0x49, 0x44,
 	0x10, 0x01, 0x12, 0x0d, 0x0a, 0x09, 0x4e, 0x4f, 0x54, 0x5f, 0x46, 0x4f, 0x55, 0x4e, 0x44, 0x10,
-	0x02, 0x42, 0x0c, 0x5a, 0x0a, 0x2e, 0x2f, 0x3b, 0x67, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x02, 0x12, 0x0c, 0x0a, 0x08, 0x43, 0x4f, 0x4e, 0x46, 0x4c, 0x49, 0x43, 0x54, 0x10, 0x03, 0x42,
+	0x0c, 0x5a, 0x0a, 0x2e, 0x2f, 0x3b, 0x67, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
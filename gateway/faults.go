@@ -0,0 +1,241 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/rules"
+)
+
+// FaultCodeValidation is the result code used when field faults are
+// provided without a more specific code.
+const FaultCodeValidation = "validation"
+
+// FaultsError is implemented by errors that can describe the problems found
+// with specific fields as a list of faults, such as FieldErrors.
+type FaultsError interface {
+	error
+	Faults() []*Fault
+}
+
+// FieldErrors is a map of field names or paths to the errors that were found
+// with them, and may be used by providers to report problems with specific
+// fields in the task's config or envelope. Keys may be JSON Paths ("$.a.b"),
+// JSON Pointers ("/a/b"), or plain field names ("a.b", "lines[0]") which are
+// assumed to be relative to the root. Nested FieldErrors will have their keys
+// appended to the parent's path:
+//
+//	return gateway.TaskKOWithFaults(gateway.FieldErrors{
+//		"doc.supplier.tax_id": errors.New("not registered"),
+//	})
+type FieldErrors map[string]error
+
+// Error provides a summary of all the field errors sorted by path.
+func (fe FieldErrors) Error() string {
+	faults := fe.Faults()
+	parts := make([]string, len(faults))
+	for i, f := range faults {
+		parts[i] = strings.Join(f.Paths, ", ") + ": " + f.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Faults flattens the field errors into a list of faults sorted by path.
+func (fe FieldErrors) Faults() []*Fault {
+	faults := fe.faults("$")
+	slices.SortFunc(faults, func(a, b *Fault) int {
+		return strings.Compare(a.Paths[0], b.Paths[0])
+	})
+	return faults
+}
+
+func (fe FieldErrors) faults(parent string) []*Fault {
+	var faults []*Fault
+	for k, err := range fe {
+		path := fieldPath(parent, k)
+		var nested FieldErrors
+		if errors.As(err, &nested) {
+			faults = append(faults, nested.faults(path)...)
+			continue
+		}
+		msg := "invalid"
+		if err != nil {
+			msg = err.Error()
+		}
+		faults = append(faults, &Fault{Paths: []string{path}, Message: msg})
+	}
+	return faults
+}
+
+// fieldPath appends the field key to the parent JSON Path, converting JSON
+// Pointers and plain field names as required.
+func fieldPath(parent, key string) string {
+	switch {
+	case key == "", key == "$":
+		return parent
+	case strings.HasPrefix(key, "$"):
+		if parent == "$" {
+			return key
+		}
+		return parent + key[1:]
+	case strings.HasPrefix(key, "/"):
+		tokens := strings.Split(key[1:], "/")
+		for i, t := range tokens {
+			tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+		}
+		return parent + strings.TrimPrefix(jsonPath(tokens), "$")
+	case strings.HasPrefix(key, "["):
+		return parent + key
+	}
+	if _, err := strconv.Atoi(key); err == nil {
+		return parent + "[" + key + "]"
+	}
+	return parent + "." + key
+}
+
+// TaskKOWithFaults behaves like TaskKO, but will also check the error for
+// GOBL validation faults, JSON decoding problems, or any FaultsError such as
+// FieldErrors, and include them in the result's Faults property so that the
+// offending fields can be highlighted. The Fields property is also set with
+// the nested field errors for clients that do not support faults.
+func TaskKOWithFaults(err error) *TaskResult {
+	return withFaults(TaskKO(err), err, errorFaults(err))
+}
+
+// TaskErrorWithFaults behaves like TaskError, but includes any field faults
+// found in the error in the same way as TaskKOWithFaults.
+func TaskErrorWithFaults(err error) *TaskResult {
+	return withFaults(TaskError(err), err, errorFaults(err))
+}
+
+// withFaults adds the faults to the result along with a code based on the
+// type of error, unless a code has already been set.
+func withFaults(res *TaskResult, err error, faults []*Fault) *TaskResult {
+	if len(faults) == 0 {
+		return res
+	}
+	res.Faults = faults
+	res.Fields, _ = json.Marshal(nestedFields(faults)) // maps of strings are always serializable
+	if res.Code == "" {
+		res.Code = faultCode(err)
+	}
+	return res
+}
+
+// nestedFields converts the faults into the nested map of field names to
+// error messages used by the `gobl.FieldErrors` type, with array indexes as
+// keys. Messages for the same field are joined.
+func nestedFields(faults []*Fault) map[string]any {
+	out := make(map[string]any)
+	for _, f := range faults {
+		for _, p := range f.Paths {
+			addNestedField(out, pathTokens(p), f.Message)
+		}
+	}
+	return out
+}
+
+func addNestedField(m map[string]any, tokens []string, msg string) {
+	if len(tokens) == 0 {
+		tokens = []string{"$"} // fault on the root object
+	}
+	k := tokens[0]
+	if len(tokens) > 1 {
+		sub, ok := m[k].(map[string]any)
+		if !ok {
+			// a message for the parent field is replaced by its children
+			sub = make(map[string]any)
+			m[k] = sub
+		}
+		addNestedField(sub, tokens[1:], msg)
+		return
+	}
+	switch v := m[k].(type) {
+	case string:
+		m[k] = v + "; " + msg
+	case nil:
+		m[k] = msg
+	}
+}
+
+// pathTokens splits the JSON Path into its field names and array indexes.
+func pathTokens(path string) []string {
+	path = strings.TrimPrefix(path, "$")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	var tokens []string
+	for _, t := range strings.Split(path, ".") {
+		if t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// faultCode provides the GOBL error key if available, or a generic
+// validation code otherwise.
+func faultCode(err error) string {
+	var ge *gobl.Error
+	if errors.As(err, &ge) && ge.Key() != "" {
+		return ge.Key().String()
+	}
+	return FaultCodeValidation
+}
+
+// errorFaults extracts the list of field faults from the error, if possible.
+func errorFaults(err error) []*Fault {
+	var (
+		ute *json.UnmarshalTypeError
+		ge  *gobl.Error
+		rf  rules.Faults
+		fe  FaultsError
+	)
+	switch {
+	case errors.As(err, &fe):
+		return fe.Faults()
+	case errors.As(err, &ute):
+		path := "$"
+		if ute.Field != "" {
+			path += "." + ute.Field
+		}
+		return []*Fault{{
+			Paths:   []string{path},
+			Message: fmt.Sprintf("cannot decode %s into %s", ute.Value, ute.Type),
+		}}
+	case errors.As(err, &ge) && ge.Faults() != nil:
+		return ruleFaults(ge.Faults())
+	case errors.As(err, &rf):
+		return ruleFaults(rf)
+	}
+	return nil
+}
+
+func ruleFaults(rf rules.Faults) []*Fault {
+	faults := make([]*Fault, 0, rf.Len())
+	for _, f := range rf.List() {
+		faults = append(faults, &Fault{
+			Code:    string(f.Code()),
+			Paths:   f.Paths(),
+			Message: f.Message(),
+		})
+	}
+	return faults
+}
+
+// jsonPath converts the JSON pointer tokens into a JSON Path expression.
+func jsonPath(tokens []string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, t := range tokens {
+		if _, err := strconv.Atoi(t); err == nil {
+			b.WriteString("[" + t + "]")
+			continue
+		}
+		b.WriteString("." + t)
+	}
+	return b.String()
+}
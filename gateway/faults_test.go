@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/currency"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldErrors(t *testing.T) {
+	fe := FieldErrors{
+		"name":        errors.New("required"),
+		"$.code":      errors.New("invalid"),
+		"/lines/0/id": errors.New("duplicate"),
+		"supplier": FieldErrors{
+			"tax_id":     errors.New("not registered"),
+			"emails[1]":  errors.New("invalid email"),
+			"identities": FieldErrors{"0": errors.New("missing type")},
+		},
+		"empty": nil,
+	}
+	faults := fe.Faults()
+	paths := make([]string, len(faults))
+	for i, f := range faults {
+		require.Len(t, f.Paths, 1)
+		paths[i] = f.Paths[0]
+	}
+	assert.Equal(t, []string{
+		"$.code",
+		"$.empty",
+		"$.lines[0].id",
+		"$.name",
+		"$.supplier.emails[1]",
+		"$.supplier.identities[0]",
+		"$.supplier.tax_id",
+	}, paths)
+	assert.Equal(t, "invalid", faults[1].Message)
+	assert.Contains(t, fe.Error(), "$.name: required; $.supplier.emails[1]: invalid email")
+}
+
+func TestTaskKOWithFaults(t *testing.T) {
+	t.Run("field errors", func(t *testing.T) {
+		err := fmt.Errorf("checking: %w", FieldErrors{"name": errors.New("required")})
+		res := TaskKOWithFaults(err)
+		assert.Equal(t, TaskStatus_KO, res.Status)
+		assert.Equal(t, FaultCodeValidation, res.Code)
+		assert.Equal(t, "checking: $.name: required", res.Message)
+		require.Len(t, res.Faults, 1)
+		assert.Equal(t, []string{"$.name"}, res.Faults[0].Paths)
+		assert.JSONEq(t, `{"name":"required"}`, string(res.Fields))
+	})
+
+	t.Run("gobl validation", func(t *testing.T) {
+		env, err := gobl.Envelop(&bill.Invoice{Currency: currency.EUR})
+		require.NoError(t, err)
+		err = env.Validate()
+		require.Error(t, err)
+		res := TaskKOWithFaults(err)
+		assert.Equal(t, TaskStatus_KO, res.Status)
+		assert.Equal(t, "validation", res.Code)
+		require.NotEmpty(t, res.Faults)
+		for _, f := range res.Faults {
+			assert.NotEmpty(t, f.Code)
+			require.NotEmpty(t, f.Paths)
+			assert.Regexp(t, `^\$`, f.Paths[0])
+		}
+	})
+
+	t.Run("plain error", func(t *testing.T) {
+		res := TaskKOWithFaults(errors.New("failed"))
+		assert.Equal(t, "failed", res.Message)
+		assert.Empty(t, res.Code)
+		assert.Empty(t, res.Fields)
+		assert.Nil(t, res.Faults)
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		res := TaskErrorWithFaults(FieldErrors{"$.doc.code": errors.New("required")})
+		assert.Equal(t, TaskStatus_ERR, res.Status)
+		assert.Equal(t, FaultCodeValidation, res.Code)
+		require.Len(t, res.Faults, 1)
+		assert.Equal(t, "required", res.Faults[0].Message)
+		assert.JSONEq(t, `{"doc":{"code":"required"}}`, string(res.Fields))
+	})
+
+	t.Run("nested fields", func(t *testing.T) {
+		res := TaskKOWithFaults(FieldErrors{
+			"supplier": FieldErrors{
+				"tax_id":    errors.New("not registered"),
+				"emails[1]": errors.New("invalid email"),
+			},
+			"lines": FieldErrors{"0": errors.New("missing item")},
+			"name":  errors.New("required"),
+		})
+		assert.JSONEq(t, `{
+			"lines": {"0": "missing item"},
+			"name": "required",
+			"supplier": {"emails": {"1": "invalid email"}, "tax_id": "not registered"}
+		}`, string(res.Fields))
+	})
+}
@@ -9,11 +9,22 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/gabriel-vasile/mimetype"
+	"go.opentelemetry.io/otel/propagation"
 	"google.golang.org/protobuf/proto"
 )
 
+var (
+	// ErrFileTooLarge is returned when a fetched file exceeds the maximum
+	// size allowed.
+	ErrFileTooLarge = errors.New("file too large")
+	// ErrFileHashMismatch is returned when a fetched file's data does not
+	// match the file's hash.
+	ErrFileHashMismatch = errors.New("file hash mismatch")
+)
+
 // CreateFile allows us to build a file place holder and upload the data afterwards
 // by posting to the URL provided.
 func (gw *Client) CreateFile(ctx context.Context, req *CreateFile) (*File, error) {
@@ -21,7 +32,7 @@ func (gw *Client) CreateFile(ctx context.Context, req *CreateFile) (*File, error
 	if err != nil {
 		return nil, err
 	}
-	out, err := gw.nc.RequestWithContext(ctx, SubjectFilesCreate, in)
+	out, err := gw.request(ctx, SubjectFilesCreate, in)
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +50,8 @@ func (gw *Client) CreateFile(ctx context.Context, req *CreateFile) (*File, error
 // as the ID, Job, Envelope, Name, and Description, and automatically add the
 // SHA256, MIME, and Size attributes based on the provided data.
 // The tradeoff here as opposed to two separate calls is that the data is kept
-// in memory and not sent through a buffer.
+// in memory and not sent through a buffer. Use CreateAndUploadFileFromReader
+// for large files.
 func (gw *Client) CreateAndUploadFile(ctx context.Context, req *CreateFile, data []byte) (*File, error) {
 	gw.prepareCreateFileFromData(req, data)
 
@@ -60,48 +72,168 @@ func (gw *Client) CreateAndUploadFile(ctx context.Context, req *CreateFile, data
 // from the file object. Provided the SHA256 data matches, the file uploaded will
 // function as expected.
 func (gw *Client) UploadFile(ctx context.Context, f *File, data io.Reader) error {
+	tn := time.Now()
+	err := gw.uploadFile(ctx, f, data)
+	gw.metrics.FileUploaded(gw.name, time.Since(tn), err)
+	return err
+}
+
+func (gw *Client) uploadFile(ctx context.Context, f *File, data io.Reader) error {
 	url, err := gw.fileUploadURL(f)
 	if err != nil {
 		return fmt.Errorf("upload url: %w", err)
 	}
-	req, err := http.NewRequest(http.MethodPut, url, data)
+	rp := gw.fileRetry
+	rewind, ok := rewinder(data)
+	if !ok {
+		// the data can only be sent once, so don't wait to find out
+		rp = &RetryPolicy{MaxAttempts: 1}
+	}
+	return rp.retry(ctx, func() error {
+		return gw.putFile(ctx, url, f, data)
+	}, rewind)
+}
+
+func (gw *Client) putFile(ctx context.Context, url string, f *File, data io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, data)
 	if err != nil {
 		return fmt.Errorf("http request: %w", err)
 	}
-	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", f.Mime)
-	client := new(http.Client)
-	res, err := client.Do(req)
+	if sr, ok := data.(*sizedReader); ok {
+		// avoid chunked encoding when streaming as the size is known
+		req.ContentLength = sr.size
+	}
+	res, err := gw.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("http do: %w", err)
 	}
-	if res.StatusCode != 200 {
-		return fmt.Errorf("upload error, status: %s", res.Status)
+	defer closeBody(res)
+	if res.StatusCode != http.StatusOK {
+		return &statusError{op: "upload", code: res.StatusCode, status: res.Status}
 	}
 	return nil
 }
 
+// rewinder prepares a function to move the data back to its current
+// position before an upload is retried, or returns false if the data
+// cannot be sent again.
+func rewinder(data io.Reader) (func() bool, bool) {
+	if data == nil {
+		return nil, true
+	}
+	s, ok := data.(io.Seeker)
+	if !ok {
+		return nil, false
+	}
+	pos, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false
+	}
+	return func() bool {
+		_, err := s.Seek(pos, io.SeekStart)
+		return err == nil
+	}, true
+}
+
+// FetchOption is used to configure how files are fetched.
+type FetchOption func(o *fetchOptions)
+
+type fetchOptions struct {
+	maxSize int64
+}
+
+// WithMaxFileSize sets the maximum number of bytes that will be accepted
+// when fetching a file, after which ErrFileTooLarge will be returned.
+func WithMaxFileSize(size int64) FetchOption {
+	return func(o *fetchOptions) {
+		o.maxSize = size
+	}
+}
+
 // FetchFile performs an HTTP GET action to retrieve a file's data from the
-// silo. The URL comes from the file object.
-func (gw *Client) FetchFile(ctx context.Context, f *File) ([]byte, error) {
+// silo. The URL comes from the file object. Use FetchFileTo for large files.
+func (gw *Client) FetchFile(ctx context.Context, f *File, opts ...FetchOption) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if _, err := gw.FetchFileTo(ctx, f, buf, opts...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FetchFileTo performs an HTTP GET action to retrieve a file's data from the
+// silo and streams it to the writer, returning the number of bytes written.
+// If the file has a hash, the data will be verified once received and
+// ErrFileHashMismatch returned if it does not match, in which case the data
+// already written should be discarded.
+func (gw *Client) FetchFileTo(ctx context.Context, f *File, w io.Writer, opts ...FetchOption) (n int64, err error) {
+	ctx, span := gw.startFileSpan(ctx, f)
+	defer func() {
+		endFileSpan(span, n, err)
+	}()
+	o := new(fetchOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
 	url, err := gw.fileUploadURL(f)
 	if err != nil {
-		return nil, fmt.Errorf("upload url: %w", err)
+		return 0, fmt.Errorf("upload url: %w", err)
 	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	var res *http.Response
+	err = gw.fileRetry.retry(ctx, func() error {
+		res, err = gw.getFile(ctx, url)
+		return err
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer closeBody(res)
+
+	if o.maxSize > 0 && res.ContentLength > o.maxSize {
+		return 0, ErrFileTooLarge
+	}
+	body := io.Reader(res.Body)
+	if o.maxSize > 0 {
+		body = io.LimitReader(body, o.maxSize)
+	}
+	h := sha256.New()
+	n, err = io.Copy(io.MultiWriter(w, h), body)
+	if err != nil {
+		return n, fmt.Errorf("reading data: %w", err)
+	}
+	if o.maxSize > 0 && n == o.maxSize {
+		if m, _ := res.Body.Read(make([]byte, 1)); m > 0 {
+			return n, ErrFileTooLarge
+		}
+	}
+	if f.Hash != "" && hex.EncodeToString(h.Sum(nil)) != f.Hash {
+		return n, ErrFileHashMismatch
+	}
+	return n, nil
+}
+
+func (gw *Client) getFile(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("http request: %w", err)
 	}
-	req = req.WithContext(ctx)
-	client := new(http.Client)
-	res, err := client.Do(req)
+	gw.textMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	res, err := gw.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("http do: %w", err)
 	}
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("fetch error, status: %s", res.Status)
+	if res.StatusCode != http.StatusOK {
+		closeBody(res)
+		return nil, &statusError{op: "fetch", code: res.StatusCode, status: res.Status}
 	}
-	return io.ReadAll(res.Body)
+	return res, nil
+}
+
+// closeBody drains and closes the response body so that the connection
+// can be reused.
+func closeBody(res *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096)) // nolint:errcheck
+	res.Body.Close()                                    // nolint:errcheck
 }
 
 func (gw *Client) prepareCreateFileFromData(req *CreateFile, data []byte) {
@@ -1,9 +1,20 @@
 package gateway
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPrepareCreateFileFromData(t *testing.T) {
@@ -28,3 +39,146 @@ func TestPrepareCreateFileFromData(t *testing.T) {
 		assert.Equal(t, "text/plain", req.Mime)
 	})
 }
+
+type countingTransport struct {
+	calls atomic.Int32
+}
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ct.calls.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newFileTestClient(t *testing.T, h http.HandlerFunc) (*Client, *countingTransport) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	ct := new(countingTransport)
+	gw := New(
+		WithSiloPublicBaseURL(srv.URL),
+		WithHTTPClient(&http.Client{Transport: ct}),
+		WithFileRetryPolicy(&RetryPolicy{MinWait: time.Millisecond}),
+	)
+	return gw, ct
+}
+
+func TestUploadFile(t *testing.T) {
+	f := &File{Id: "file-1", SiloEntryId: "entry-1", Name: "test.txt", Mime: "text/plain"}
+
+	t.Run("retries server errors", func(t *testing.T) {
+		var bodies []string
+		gw, ct := newFileTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(data))
+			if len(bodies) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		})
+		err := gw.UploadFile(context.Background(), f, bytes.NewReader([]byte("hello")))
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, ct.calls.Load())
+		assert.Equal(t, []string{"hello", "hello"}, bodies)
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		gw, ct := newFileTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		})
+		err := gw.UploadFile(context.Background(), f, bytes.NewReader([]byte("hello")))
+		assert.ErrorContains(t, err, "upload error, status: 502")
+		assert.EqualValues(t, 3, ct.calls.Load())
+	})
+
+	t.Run("client errors", func(t *testing.T) {
+		gw, ct := newFileTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		})
+		err := gw.UploadFile(context.Background(), f, bytes.NewReader([]byte("hello")))
+		assert.ErrorContains(t, err, "400")
+		assert.EqualValues(t, 1, ct.calls.Load())
+	})
+
+	t.Run("unseekable data", func(t *testing.T) {
+		gw, ct := newFileTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		gw.fileRetry = (&RetryPolicy{MinWait: time.Hour}).withDefaults()
+		done := make(chan error, 1)
+		go func() {
+			done <- gw.UploadFile(context.Background(), f, io.MultiReader(strings.NewReader("hello")))
+		}()
+		select {
+		case err := <-done:
+			assert.ErrorContains(t, err, "503")
+		case <-time.After(5 * time.Second):
+			t.Fatal("waited to retry data that cannot be sent again")
+		}
+		assert.EqualValues(t, 1, ct.calls.Load())
+	})
+}
+
+func TestFetchFileTo(t *testing.T) {
+	data := []byte("hello world")
+	sum := sha256.Sum256(data)
+	f := &File{Id: "file-1", SiloEntryId: "entry-1", Name: "test.txt", Hash: hex.EncodeToString(sum[:])}
+	ctx := context.Background()
+
+	t.Run("success with retry", func(t *testing.T) {
+		var calls int
+		gw, ct := newFileTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			assert.Equal(t, f.Hash, r.URL.Query().Get("h"))
+			w.Write(data) // nolint:errcheck
+		})
+		buf := new(bytes.Buffer)
+		n, err := gw.FetchFileTo(ctx, f, buf)
+		require.NoError(t, err)
+		assert.EqualValues(t, len(data), n)
+		assert.Equal(t, data, buf.Bytes())
+		assert.EqualValues(t, 2, ct.calls.Load())
+	})
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		gw, _ := newFileTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("hello there")) // nolint:errcheck
+		})
+		_, err := gw.FetchFile(ctx, f)
+		assert.ErrorIs(t, err, ErrFileHashMismatch)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		gw, _ := newFileTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Write(data) // nolint:errcheck
+		})
+		_, err := gw.FetchFile(ctx, f, WithMaxFileSize(5))
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+
+		out, err := gw.FetchFile(ctx, f, WithMaxFileSize(int64(len(data))))
+		require.NoError(t, err)
+		assert.Equal(t, data, out)
+	})
+
+	t.Run("too large streamed", func(t *testing.T) {
+		gw, _ := newFileTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Write(data[:5]) // nolint:errcheck
+			w.(http.Flusher).Flush()
+			w.Write(data[5:]) // nolint:errcheck
+		})
+		n, err := gw.FetchFileTo(ctx, f, io.Discard, WithMaxFileSize(8))
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+		assert.EqualValues(t, 8, n)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		gw, ct := newFileTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+		_, err := gw.FetchFile(ctx, f)
+		assert.ErrorContains(t, err, "fetch error, status: 404")
+		assert.EqualValues(t, 1, ct.calls.Load())
+	})
+}
@@ -12,33 +12,113 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"runtime/debug"
+	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/invopop/configure/pkg/natsconf"
 	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 )
 
 const (
 	defaultWorkerCount = 8
 	defaultTaskTimeout = 1 * time.Minute
+	// fetchTaskTimeout is how long to wait for each message before checking
+	// the subscription again.
+	fetchTaskTimeout = time.Minute
+	// shutdownRetryIn is the number of seconds the gateway should wait before
+	// retrying tasks that were interrupted by a shutdown.
+	shutdownRetryIn = 5
+	// abandonGrace is how long to wait for handlers to return once their
+	// context has been cancelled by a shutdown before abandoning them.
+	abandonGrace = 100 * time.Millisecond
 )
 
+// ErrShuttingDown is the cause provided by task handler contexts when they
+// are cancelled because the Shutdown deadline was reached.
+var ErrShuttingDown = errors.New("gateway shutting down")
+
+// ShutdownError is returned by Shutdown when the deadline was reached before
+// all the in-flight tasks were completed.
+type ShutdownError struct {
+	// Err is the context's error.
+	Err error
+	// Aborted contains the IDs of the tasks that were interrupted and
+	// requeued.
+	Aborted []string
+	// Abandoned contains the IDs of the aborted tasks whose handlers had
+	// still not returned. These will continue running in the background
+	// until they do, so handlers should always respect the context to
+	// avoid side effects after their tasks were requeued.
+	Abandoned []string
+}
+
+// Error provides a summary of the tasks that were aborted.
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("gateway shutdown: %d tasks aborted, %d abandoned: %v", len(e.Aborted), len(e.Abandoned), e.Err)
+}
+
+// Unwrap provides the underlying context error.
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
 // Client wraps around the functionality provided by the
 // the gateway service, accessed via NATS.
 type Client struct {
 	name              string // service name
 	nc                *nats.Conn
+	ownConn           bool // true if nc was established by Connect
 	wg                sync.WaitGroup
+	err               error // problems found while applying options
+	natsConf          *natsconf.Config
+	hooks             *ConnectionHooks
+	connRetry         *RetryPolicy
+	ctx               context.Context // parent of all task contexts
+	cancel            context.CancelCauseFunc
+	stopOnce          sync.Once
+	started           atomic.Bool
+	stopping          atomic.Bool
+	lastTaskAt        atomic.Int64 // unix nanoseconds
+	abortedMu         sync.Mutex
+	aborted           []string
 	th                TaskHandler
+	mux               *Mux
+	mw                []TaskMiddleware
+	ownerLimits       *OwnerLimits
+	dedupe            *Dedupe
+	panicHandler      PanicHandler
+	handler           TaskHandler // th wrapped with middleware
+	tracer            trace.Tracer
+	propagator        propagation.TextMapPropagator
+	metrics           Metrics
+	js                *JetStream
+	jsReady           chan struct{} // workers waiting for a task
+	jsIncoming        chan jetstream.Msg
+	jsStop            chan struct{}
+	jsDone            chan struct{}
 	timeout           time.Duration
 	incoming          chan *nats.Msg
 	sub               *nats.Subscription
+	subDone           chan struct{}
+	cancelSub         *nats.Subscription
+	cancellation      bool // subscribe to cancel requests
+	running           taskRegistry
+	handling          taskRegistry // handlers that have not returned
 	siloPublicBaseURL string
+	httpClient        *http.Client
+	fileRetry         *RetryPolicy
 	workerCount       int
+	adaptive          *AdaptiveWorkers
+	pool              workerPool
+	stats             workerStats
 }
 
 // Option provides a way to configure the gateway client using a
@@ -62,9 +142,25 @@ type Option func(gw *Client)
 //		gateway.WithNATS(nc),
 //		gateway.WithTaskHandler(handler),
 //	)
+//
+// When using WithConfig, the NATS connection will be established straight
+// away as in previous versions, retrying according to any connect retry
+// policy. Problems with the options or the connection will be returned by
+// Start. Use NewWithError and Connect to control when to connect.
 func New(opts ...Option) *Client {
+	gw := newClient(opts)
+	if gw.natsConf != nil {
+		if err := gw.Connect(context.Background()); err != nil {
+			gw.err = err
+		}
+	}
+	return gw
+}
+
+func newClient(opts []Option) *Client {
 	gw := new(Client)
 	gw.incoming = make(chan *nats.Msg)
+	gw.ctx, gw.cancel = context.WithCancelCause(context.Background())
 
 	for _, opt := range opts {
 		opt(gw)
@@ -73,15 +169,30 @@ func New(opts ...Option) *Client {
 	if gw.workerCount == 0 {
 		gw.workerCount = defaultWorkerCount
 	}
+	if gw.adaptive != nil {
+		gw.adaptive = gw.adaptive.withDefaults(gw.workerCount)
+		gw.workerCount = min(max(gw.workerCount, gw.adaptive.Min), gw.adaptive.Max)
+	}
+	gw.pool.size = gw.workerCount
 	if gw.timeout == 0 {
 		gw.timeout = defaultTaskTimeout
 	}
+	if gw.metrics == nil {
+		gw.metrics = noopMetrics{}
+	}
+	if gw.httpClient == nil {
+		gw.httpClient = http.DefaultClient
+	}
+	if gw.fileRetry == nil {
+		gw.fileRetry = new(RetryPolicy).withDefaults()
+	}
 
 	return gw
 }
 
 // NATS provides the NATS Connection so that it can be used
-// elsewhere if needed.
+// elsewhere if needed. When using NewWithError, this will be nil until
+// connected by Connect or Start.
 func (gw *Client) NATS() *nats.Conn {
 	return gw.nc
 }
@@ -97,143 +208,308 @@ func (gw *Client) Subscribe(th TaskHandler) {
 
 // Start begins the gateway service and starts listening for incoming tasks.
 func (gw *Client) Start() error {
+	if gw.err != nil {
+		return gw.err
+	}
 	if gw.name == "" {
 		return errors.New("name required")
 	}
 	if gw.th == nil {
 		return errors.New("task handler required")
 	}
-	if gw.nc == nil {
-		return errors.New("nats connection required")
+	connected := gw.nc != nil
+	if err := gw.Connect(context.Background()); err != nil {
+		return err
 	}
-	if err := gw.subscribeIncomingTasks(); err != nil {
+	if err := gw.subscribe(); err != nil {
+		if !connected {
+			// don't leave behind the connection established here
+			gw.nc.Close()
+			gw.nc = nil
+			gw.ownConn = false
+		}
+		return err
+	}
+	log.Debug().Int("count", gw.WorkerCount()).Msg("gateway: starting workers")
+	if gw.js != nil {
+		gw.pool.start(&gw.wg, gw.startJetStreamWorker)
+	} else {
+		gw.pool.start(&gw.wg, gw.startTaskWorker)
+	}
+	if gw.adaptive != nil {
+		go gw.scaleWorkers(gw.adaptive)
+	}
+	gw.started.Store(true)
+	return nil
+}
+
+// subscribe prepares the handler and subscribes to receive tasks.
+func (gw *Client) subscribe() error {
+	mw := []TaskMiddleware{RecoverTasksWithHandler(gw.recoverPanic)}
+	if gw.dedupe != nil {
+		mw = append(mw, newDeduper(gw, gw.dedupe).middleware)
+	}
+	if gw.ownerLimits != nil {
+		mw = append(mw, newOwnerLimiter(gw.ownerLimits, gw.WorkerCount).middleware)
+	}
+	gw.handler = chainTaskMiddleware(gw.th, append(mw, gw.mw...)...)
+	if gw.js != nil {
+		if err := gw.subscribeJetStream(); err != nil {
+			return fmt.Errorf("subscribing to jetstream: %w", err)
+		}
+	} else if err := gw.subscribeIncomingTasks(); err != nil {
 		return fmt.Errorf("subscribing for tasks: %w", err)
 	}
-	log.Debug().Int("count", gw.workerCount).Msg("gateway: starting workers")
-	for i := 0; i < gw.workerCount; i++ {
-		go gw.startTaskWorker()
+	if gw.cancellation {
+		if err := gw.subscribeCancel(); err != nil {
+			return fmt.Errorf("subscribing for cancellations: %w", err)
+		}
 	}
 	return nil
 }
 
 // Stop is used to gracefully drain all requests and wait for them to complete.
+// Use Shutdown to avoid waiting indefinitely for stuck tasks.
 func (gw *Client) Stop() {
+	gw.Shutdown(context.Background()) // nolint:errcheck
+}
+
+// Shutdown stops receiving new tasks and waits for those in-flight to
+// complete, or for the context to be done. Once the context is done, the
+// contexts of any task handlers still running will be cancelled with the
+// ErrShuttingDown cause. Results from handlers that return shortly after
+// are kept, except for errors which are assumed to be caused by the
+// cancellation. The remaining tasks will be sent back to the gateway to be
+// retried shortly without waiting any longer for their handlers. A
+// *ShutdownError will be returned with the IDs of the tasks that were
+// aborted, and of those whose handlers were abandoned. A NATS connection
+// established by the client itself will be drained and closed, while those
+// provided with WithNATS are left open.
+func (gw *Client) Shutdown(ctx context.Context) error {
 	tn := time.Now()
+	gw.stopping.Store(true)
 	log.Debug().Msg("gateway: shutting down")
 
+	done := make(chan struct{})
+	go func() {
+		gw.stopOnce.Do(gw.stopReceiving)
+		gw.wg.Wait()
+		if gw.cancelSub != nil {
+			gw.cancelSub.Unsubscribe() // nolint:errcheck
+		}
+		if gw.ownConn {
+			// flush any replies before closing
+			gw.nc.Drain() // nolint:errcheck
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info().Dur("dur", time.Since(tn)).Msg("gateway: shutdown complete")
+		return nil
+	case <-ctx.Done():
+	}
+
+	gw.cancel(ErrShuttingDown)
+	<-done
+	err := &ShutdownError{
+		Err:       ctx.Err(),
+		Aborted:   gw.abortedTasks(),
+		Abandoned: gw.handling.ids(),
+	}
+	log.Warn().Dur("dur", time.Since(tn)).Strs("aborted", err.Aborted).Strs("abandoned", err.Abandoned).Msg("gateway: shutdown deadline reached")
+	return err
+}
+
+// stopReceiving prevents new tasks from being received and lets the workers
+// finish once any pending tasks have been processed.
+func (gw *Client) stopReceiving() {
+	gw.pool.close()
 	if gw.sub != nil {
-		gw.sub.Unsubscribe() // nolint:errcheck
-		gw.sub.Drain()       // nolint:errcheck
+		gw.stopTasks()
+	}
+	if gw.jsStop != nil {
+		gw.stopJetStream()
 	}
-	close(gw.incoming) // this stops workers from receiving more
-	gw.wg.Wait()
+}
 
-	log.Info().Dur("dur", time.Since(tn)).Msg("gateway: shutdown complete")
+func (gw *Client) abortedTasks() []string {
+	gw.abortedMu.Lock()
+	defer gw.abortedMu.Unlock()
+	return slices.Clone(gw.aborted)
 }
 
 func (gw *Client) subscribeIncomingTasks() error {
 	subj := fmt.Sprintf(SubjectTaskFmt, gw.name)
 	queue := fmt.Sprintf(QueueNameTaskFmt, gw.name)
 	var err error
-	gw.sub, err = gw.nc.QueueSubscribeSyncWithChan(subj, queue, gw.incoming)
+	gw.sub, err = gw.nc.QueueSubscribeSync(subj, queue)
 	if err != nil {
 		return fmt.Errorf("error subscribing to queue: %w", err)
 	}
+	gw.subDone = make(chan struct{})
+	go gw.fetchTasks()
 	return nil
 }
 
-func (gw *Client) startTaskWorker() {
-	for m := range gw.incoming {
-		gw.processTask(m)
+// fetchTasks passes messages from the subscription to the workers until the
+// subscription is closed. Messages wait in the subscription's pending
+// buffer while all the workers are busy.
+func (gw *Client) fetchTasks() {
+	defer close(gw.subDone)
+	defer close(gw.incoming) // this stops workers from receiving more
+	for {
+		m, err := gw.sub.NextMsg(fetchTaskTimeout)
+		if err != nil {
+			switch {
+			case errors.Is(err, nats.ErrTimeout):
+				continue
+			case errors.Is(err, nats.ErrSlowConsumer):
+				log.Warn().Err(err).Msg("gateway: tasks dropped")
+				continue
+			}
+			return
+		}
+		tn := time.Now()
+		gw.incoming <- m
+		gw.stats.taskWaited(time.Since(tn))
+	}
+}
+
+// stopTasks removes interest in new tasks and waits for those already
+// pending in the subscription to be passed to the workers.
+func (gw *Client) stopTasks() {
+	if err := gw.sub.Drain(); err != nil {
+		// connection already closed, nothing else will be received
+		gw.sub.Unsubscribe() // nolint:errcheck
+	}
+	<-gw.subDone
+}
+
+// queueDepth provides the number of tasks waiting in the subscription.
+func (gw *Client) queueDepth() int {
+	n, _, err := gw.sub.Pending()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func (gw *Client) startTaskWorker(stop <-chan struct{}) {
+	for !stopped(stop) {
+		select {
+		case m, ok := <-gw.incoming:
+			if !ok {
+				return
+			}
+			gw.metrics.QueueDepth(gw.name, gw.queueDepth())
+			gw.processTask(m)
+		case <-stop:
+			return
+		}
 	}
 }
 
 func (gw *Client) processTask(m *nats.Msg) {
-	gw.wg.Add(1)
-	defer gw.wg.Done()
-	ctx, cancel := context.WithTimeout(context.Background(), gw.timeout)
-	defer cancel()
+	t, res := gw.runTask(m)
+	gw.reply(m.Reply, t, res)
+}
 
+// runTask parses the task contained in the message and passes it to the
+// handler, providing the result that should be sent back to the gateway.
+func (gw *Client) runTask(m *nats.Msg) (*Task, *TaskResult) {
 	// Handling the incoming data
+	tn := time.Now()
+	gw.stats.taskStarted()
+	defer gw.stats.taskCompleted()
 	t := new(Task)
 	var res *TaskResult
-	if err := proto.Unmarshal(m.Data, t); err != nil {
+	err := proto.Unmarshal(m.Data, t)
+	gw.metrics.TaskStarted(gw.name, t.Action)
+	if err != nil {
 		res = TaskError(fmt.Errorf("parsing incoming task: %w", err))
 	} else {
-		// Handle panics from task handler
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					// Get stack trace for debugging
-					stack := debug.Stack()
-
-					// Log the panic with full details for monitoring
-					log.Error().
-						Str("task_id", t.Id).
-						Str("action", t.Action).
-						Str("trace", string(stack)).
-						Str("job_id", t.JobId).
-						Str("owner_id", t.OwnerId).
-						Str("silo_entry_id", t.SiloEntryId).
-						Msgf("[PANIC RECOVERED] %v", r)
-
-					// Convert panic to user-friendly TaskKO so that we stop any
-					// future retries. We assume here that retrying will not work
-					// until the underlying issue is fixed.
-					res = TaskKO(fmt.Errorf("unexpected data error"))
-				}
-			}()
-
-			res = gw.th(ctx, t)
-			if res == nil {
-				// assume the response is okay if no content
-				res = TaskOK()
-			}
-		}()
+		ctx, span := gw.startTaskSpan(gw.ctx, m, t)
+		ctx, cancelTask := context.WithCancelCause(ctx)
+		defer cancelTask(nil)
+		gw.running.add(t, cancelTask)
+		defer gw.running.remove(t)
+		ctx, cancel := context.WithTimeout(ctx, gw.taskTimeout(t))
+		defer cancel()
+
+		res = cancelledResult(ctx, gw.handle(ctx, t))
+		endTaskSpan(span, res)
+	}
+	gw.metrics.TaskCompleted(gw.name, t.Action, res.Status, time.Since(tn))
+	gw.lastTaskAt.Store(time.Now().UnixNano())
+	return t, res
+}
+
+// handle passes the task to the handler, but will give up waiting as soon
+// as the client's context is cancelled during a shutdown so that stuck
+// handlers cannot prevent it from completing. Handlers are tracked until
+// they return so that any left behind can be reported.
+func (gw *Client) handle(ctx context.Context, t *Task) *TaskResult {
+	done := make(chan *TaskResult, 1)
+	gw.handling.add(t, nil)
+	go func() {
+		res := gw.handler(ctx, t)
+		gw.handling.remove(t) // before anyone could be told the task is done
+		done <- res
+	}()
+	var res *TaskResult
+	select {
+	case res = <-done:
+	case <-gw.ctx.Done():
+		// give the handler a chance to respond to the cancellation
+		timer := time.NewTimer(abandonGrace)
+		defer timer.Stop()
+		select {
+		case res = <-done:
+		case <-timer.C:
+			return gw.abortTask(t)
+		}
 	}
+	if res == nil {
+		// assume the response is okay if no content
+		res = TaskOK()
+	}
+	if res.Status == TaskStatus_ERR && errors.Is(context.Cause(ctx), ErrShuttingDown) {
+		// the handler most likely failed due to the cancelled context,
+		// while any other result is final and must be kept
+		return gw.abortTask(t)
+	}
+	return res
+}
+
+// abortTask records the interrupted task and provides a result so that it
+// will be retried.
+func (gw *Client) abortTask(t *Task) *TaskResult {
+	gw.abortedMu.Lock()
+	gw.aborted = append(gw.aborted, t.Id)
+	gw.abortedMu.Unlock()
+	return TaskQueued(ErrShuttingDown.Error(), shutdownRetryIn)
+}
 
-	// Send the reply back
+// reply sends the task result back to the gateway using the subject.
+func (gw *Client) reply(subj string, t *Task, res *TaskResult) {
 	data, err := proto.Marshal(res)
 	if err != nil {
 		log.Error().Str("task_id", t.Id).Err(err).Msg("unable to marshal task response, dropping")
 	}
-	if err := gw.nc.Publish(m.Reply, data); err != nil {
+	if err := gw.nc.Publish(subj, data); err != nil {
 		log.Error().Str("task_id", t.Id).Err(err).Msg("unable to publish response")
 	}
 }
 
-func prepareNATSClient(conf *natsconf.Config, name string) *nats.Conn {
-	// prepare base options
-	opts, err := conf.Options()
-	if err != nil {
-		log.Fatal().Err(err).Msg("preparing nats options")
-	}
-
-	opts = append(opts,
-		nats.Name(name),
-		// Add our own connection logging stuff
-		nats.ConnectHandler(func(_ *nats.Conn) {
-			log.Info().Str("url", conf.URL).Msg("nats connected")
-		}),
-		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
-			log.Warn().Str("url", conf.URL).Err(err).Msg("nats disconnected")
-		}),
-		nats.ReconnectHandler(func(_ *nats.Conn) {
-			log.Info().Str("url", conf.URL).Msg("nats reconnected")
-		}),
-		nats.ReconnectErrHandler(func(_ *nats.Conn, err error) {
-			log.Warn().Str("url", conf.URL).Err(err).Msg("nats reconnect error")
-		}),
-		nats.ClosedHandler(func(_ *nats.Conn) {
-			log.Warn().Str("url", conf.URL).Msg("nats closed")
-		}),
-	)
-
-	// Create the connection
-	nc, err := nats.Connect(conf.URL, opts...)
-	if err != nil {
-		log.Fatal().Err(err).Str("url", conf.URL).Msg("failed to connect to nats")
+// taskTimeout determines how long the task may take to process, giving
+// priority to any timeout defined by the mux's routes.
+func (gw *Client) taskTimeout(t *Task) time.Duration {
+	if gw.mux != nil {
+		if d := gw.mux.timeout(t); d > 0 {
+			return d
+		}
 	}
-
-	return nc
+	return gw.timeout
 }
@@ -0,0 +1,236 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// runNATSServer starts a plain NATS server without JetStream and provides
+// a connection to it.
+func runNATSServer(t *testing.T) *nats.Conn {
+	t.Helper()
+	port := freePort(t)
+	startNATSServer(t, port)
+	nc, err := nats.Connect(testNATSConfig(port).NATS.URL)
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// startGateway starts a gateway client named "test" with the handler
+// receiving tasks from core NATS.
+func startGateway(t *testing.T, th TaskHandler, opts ...Option) (*Client, *nats.Conn) {
+	t.Helper()
+	nc := runNATSServer(t)
+	gw := New(append([]Option{
+		WithName("test"),
+		WithNATS(nc),
+		WithTaskHandler(th),
+	}, opts...)...)
+	require.NoError(t, gw.Start())
+	t.Cleanup(gw.Stop)
+	return gw, nc
+}
+
+// requestTask sends the task to the gateway client as the gateway would,
+// waiting for the result.
+func requestTask(nc *nats.Conn, task *Task) (*TaskResult, error) {
+	data, err := proto.Marshal(task)
+	if err != nil {
+		return nil, err
+	}
+	m, err := nc.Request("gw.test.task", data, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	res := new(TaskResult)
+	if err := proto.Unmarshal(m.Data, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func sendTask(t *testing.T, nc *nats.Conn, task *Task) *TaskResult {
+	t.Helper()
+	res, err := requestTask(nc, task)
+	require.NoError(t, err)
+	return res
+}
+
+// testStore responds to store requests in the same way as the gateway,
+// without support for revisions.
+type testStore struct {
+	mu      sync.Mutex
+	entries map[string]*StoreEntry
+}
+
+func runTestStore(t *testing.T, nc *nats.Conn) *testStore {
+	t.Helper()
+	s := &testStore{entries: make(map[string]*StoreEntry)}
+	handlers := map[string]func(data []byte) *StoreResponse{
+		SubjectStoreGet:    s.get,
+		SubjectStoreSet:    s.set,
+		SubjectStoreDelete: s.delete,
+	}
+	for subj, fn := range handlers {
+		sub, err := nc.Subscribe(subj, func(m *nats.Msg) {
+			data, err := proto.Marshal(fn(m.Data))
+			if assert.NoError(t, err) {
+				assert.NoError(t, m.Respond(data))
+			}
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { sub.Unsubscribe() }) // nolint:errcheck
+	}
+	require.NoError(t, nc.Flush())
+	return s
+}
+
+func testStoreKey(owner, provider, key string) string {
+	return owner + "/" + provider + "/" + key
+}
+
+// entry provides the stored entry, or nil.
+func (s *testStore) entry(owner, provider, key string) *StoreEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[testStoreKey(owner, provider, key)]
+}
+
+func (s *testStore) get(data []byte) *StoreResponse {
+	req := new(StoreGet)
+	if err := proto.Unmarshal(data, req); err != nil {
+		return &StoreResponse{Err: &Error{Code: ErrorCode_INVALID, Message: err.Error()}}
+	}
+	if e := s.entry(req.OwnerId, req.Provider, req.Key); e != nil {
+		return &StoreResponse{Entry: e}
+	}
+	return &StoreResponse{Err: &Error{Code: ErrorCode_NOT_FOUND, Message: "entry not found"}}
+}
+
+func (s *testStore) set(data []byte) *StoreResponse {
+	req := new(StoreSet)
+	if err := proto.Unmarshal(data, req); err != nil {
+		return &StoreResponse{Err: &Error{Code: ErrorCode_INVALID, Message: err.Error()}}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tn := time.Now().Unix()
+	e := &StoreEntry{Key: req.Key, Value: req.Value, Revision: 1, CreatedTs: tn, UpdatedTs: tn}
+	k := testStoreKey(req.OwnerId, req.Provider, req.Key)
+	if prev, ok := s.entries[k]; ok {
+		e.Revision = prev.Revision + 1
+		e.CreatedTs = prev.CreatedTs
+	}
+	if req.Ttl > 0 {
+		e.ExpiresTs = tn + int64(req.Ttl)
+	}
+	s.entries[k] = e
+	return &StoreResponse{Entry: e}
+}
+
+func (s *testStore) delete(data []byte) *StoreResponse {
+	req := new(StoreDelete)
+	if err := proto.Unmarshal(data, req); err != nil {
+		return &StoreResponse{Err: &Error{Code: ErrorCode_INVALID, Message: err.Error()}}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := testStoreKey(req.OwnerId, req.Provider, req.Key)
+	e, ok := s.entries[k]
+	if !ok {
+		return &StoreResponse{Err: &Error{Code: ErrorCode_NOT_FOUND, Message: "entry not found"}}
+	}
+	delete(s.entries, k)
+	return &StoreResponse{Entry: e}
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("graceful", func(t *testing.T) {
+		gw, nc := startGateway(t, func(_ context.Context, _ *Task) *TaskResult {
+			return TaskOK()
+		})
+		res := sendTask(t, nc, &Task{Id: "task-1"})
+		assert.Equal(t, TaskStatus_OK, res.Status)
+		assert.NoError(t, gw.Shutdown(context.Background()))
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		started := make(chan struct{}, 4)
+		release := make(chan struct{})
+		t.Cleanup(func() { close(release) })
+		var cause atomic.Value
+		gw, nc := startGateway(t, func(ctx context.Context, task *Task) *TaskResult {
+			started <- struct{}{}
+			if task.Action == "stuck" {
+				<-release // ignores the context
+				return TaskOK()
+			}
+			<-ctx.Done()
+			cause.Store(context.Cause(ctx))
+			switch task.Action {
+			case "ko":
+				return TaskKO(errors.New("rejected"))
+			case "ok":
+				return TaskOK()
+			}
+			return TaskError(ctx.Err())
+		}, WithWorkerCount(4))
+
+		tasks := []*Task{
+			{Id: "task-1", Action: "stuck"},
+			{Id: "task-2", Action: "wait"},
+			{Id: "task-3", Action: "ko"},
+			{Id: "task-4", Action: "ok"},
+		}
+		results := make(chan *TaskResult, len(tasks))
+		for _, task := range tasks {
+			go func() {
+				res, err := requestTask(nc, task)
+				assert.NoError(t, err)
+				if res != nil {
+					res.Ref = task.Id
+				}
+				results <- res
+			}()
+		}
+		for range tasks {
+			<-started
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := gw.Shutdown(ctx)
+		var se *ShutdownError
+		require.ErrorAs(t, err, &se)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ElementsMatch(t, []string{"task-1", "task-2"}, se.Aborted)
+		assert.Equal(t, []string{"task-1"}, se.Abandoned)
+
+		statuses := make(map[string]TaskStatus)
+		for range tasks {
+			res := <-results
+			require.NotNil(t, res)
+			statuses[res.Ref] = res.Status
+			if res.Status == TaskStatus_QUEUED {
+				assert.Positive(t, res.RetryIn)
+			}
+		}
+		assert.Equal(t, map[string]TaskStatus{
+			"task-1": TaskStatus_QUEUED,
+			"task-2": TaskStatus_QUEUED,
+			"task-3": TaskStatus_KO,
+			"task-4": TaskStatus_OK,
+		}, statuses)
+		assert.Equal(t, ErrShuttingDown, cause.Load())
+	})
+}
@@ -0,0 +1,158 @@
+package gatewaytest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/invopop/client.go/gateway"
+	"github.com/invopop/gobl/uuid"
+	nats "github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+)
+
+// File contains the details of a file created through the gateway alongside
+// any data uploaded to the fake silo.
+type File struct {
+	*gateway.File
+	Data []byte
+}
+
+// File provides a copy of the file with the matching ID, or nil.
+func (s *Server) File(id string) *File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		return nil
+	}
+	return &File{
+		File: proto.Clone(f.File).(*gateway.File),
+		Data: append([]byte(nil), f.Data...),
+	}
+}
+
+// Files provides a copy of all the files created so far.
+func (s *Server) Files() []*File {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.files))
+	for id := range s.files {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	list := make([]*File, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, s.File(id))
+	}
+	return list
+}
+
+func (s *Server) createFile(m *nats.Msg) {
+	req := new(gateway.CreateFile)
+	res := new(gateway.FileResponse)
+	if err := proto.Unmarshal(m.Data, req); err != nil {
+		res.Err = invalid("parsing request: %v", err)
+		respond(m, res)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.files[req.Id]; ok {
+		res.File = f.File
+		respond(m, res)
+		return
+	}
+	switch {
+	case req.SiloEntryId == "":
+		res.Err = invalid("silo_entry_id: cannot be blank")
+	case req.Name == "":
+		res.Err = invalid("name: cannot be blank")
+	case req.Sha256 == "":
+		res.Err = invalid("sha256: cannot be blank")
+	}
+	if res.Err != nil {
+		respond(m, res)
+		return
+	}
+	if req.Id == "" {
+		req.Id = uuid.V7().String()
+	}
+	f := &gateway.File{
+		Id:          req.Id,
+		SiloEntryId: req.SiloEntryId,
+		Hash:        req.Sha256,
+		Name:        req.Name,
+		Desc:        req.Desc,
+		Mime:        req.Mime,
+		PublicUrl:   s.silo.URL + "/" + req.SiloEntryId + "/" + req.Id + "/" + req.Name,
+		Meta:        req.Meta,
+		Embeddable:  req.Embeddable,
+		Private:     req.Private,
+	}
+	s.files[f.Id] = &File{File: f}
+	res.File = f
+	respond(m, res)
+}
+
+func (s *Server) siloHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /{entry}/{id}/{name}", s.uploadFile)
+	mux.HandleFunc("GET /{entry}/{id}/{name}", s.fetchFile)
+	return mux
+}
+
+// findFile provides the file matching the request path, or writes a
+// not found response.
+func (s *Server) findFile(w http.ResponseWriter, r *http.Request) *File {
+	f, ok := s.files[r.PathValue("id")]
+	if !ok || f.SiloEntryId != r.PathValue("entry") || f.Name != r.PathValue("name") {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return nil
+	}
+	return f
+}
+
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.findFile(w, r)
+	if f == nil {
+		return
+	}
+	if f.Uploaded {
+		http.Error(w, "file already uploaded", http.StatusConflict)
+		return
+	}
+	sum := sha256.Sum256(data)
+	if hash := hex.EncodeToString(sum[:]); hash != f.Hash || r.URL.Query().Get("h") != f.Hash {
+		http.Error(w, "hash mismatch", http.StatusBadRequest)
+		return
+	}
+	f.Data = data
+	f.Uploaded = true
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) fetchFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.findFile(w, r)
+	if f == nil {
+		return
+	}
+	if !f.Uploaded {
+		http.Error(w, "file not uploaded", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", f.Mime)
+	w.Write(f.Data) // nolint:errcheck
+}
@@ -0,0 +1,358 @@
+// Package gatewaytest provides an embedded NATS server that fakes the
+// Invopop gateway service so that task handlers can be tested end-to-end
+// without any external dependencies.
+//
+// Usage example:
+//
+//	srv := gatewaytest.NewServer()
+//	defer srv.Close()
+//	gw := srv.Client("my-service", gateway.WithTaskHandler(handler))
+//	if err := gw.Start(); err != nil {
+//		// ...
+//	}
+//	defer gw.Stop()
+//	res, err := srv.SendTask(ctx, "my-service", &gateway.Task{Action: "sign"})
+//
+// The server responds to requests to create files, poke tasks, and use the
+// key-value store, and provides a fake silo HTTP endpoint so that uploaded
+// files can be inspected.
+package gatewaytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/invopop/client.go/gateway"
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	readyTimeout = 10 * time.Second
+	streamSubj   = "gw.*.task"
+	// retryInterval is how long to wait before sending tasks again
+	retryInterval = 10 * time.Millisecond
+)
+
+// PokeHandler is called synchronously when a poke request is received so
+// that tests may simulate the gateway sending the task again. Any error
+// returned will be sent back to the client.
+type PokeHandler func(poke *gateway.TaskPoke) error
+
+// Server embeds a NATS server and responds to the gateway subjects used by
+// the gateway client.
+type Server struct {
+	ns     *server.Server
+	nc     *nats.Conn
+	silo   *httptest.Server
+	stream string
+	dir    string // jetstream storage
+	ph     PokeHandler
+
+	mu    sync.Mutex
+	conns []*nats.Conn
+	files map[string]*File
+	pokes []*gateway.TaskPoke
+	store map[string]*gateway.StoreEntry
+}
+
+// Option is used to configure the server.
+type Option func(s *Server)
+
+// WithPokeHandler sets the handler that will be called after each poke.
+func WithPokeHandler(ph PokeHandler) Option {
+	return func(s *Server) {
+		s.ph = ph
+	}
+}
+
+// WithJetStream enables JetStream in the embedded server and creates a
+// stream with the provided name to which all tasks sent will be published,
+// for use with clients configured with gateway.WithJetStream.
+func WithJetStream(stream string) Option {
+	return func(s *Server) {
+		s.stream = stream
+	}
+}
+
+// NewServer starts a new embedded NATS server and fake silo. Be sure to
+// call Close once finished. As with httptest.NewServer, this will panic if
+// the server cannot be started.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		files: make(map[string]*File),
+		store: make(map[string]*gateway.StoreEntry),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := s.start(); err != nil {
+		s.Close()
+		panic(fmt.Sprintf("gatewaytest: %v", err))
+	}
+	return s
+}
+
+func (s *Server) start() error {
+	sopts := &server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoLog:  true,
+		NoSigs: true,
+	}
+	var err error
+	if s.stream != "" {
+		sopts.JetStream = true
+		s.dir, err = os.MkdirTemp("", "gatewaytest")
+		if err != nil {
+			return err
+		}
+		sopts.StoreDir = s.dir
+	}
+	s.ns, err = server.NewServer(sopts)
+	if err != nil {
+		return fmt.Errorf("preparing nats server: %w", err)
+	}
+	s.ns.Start()
+	if !s.ns.ReadyForConnections(readyTimeout) {
+		return fmt.Errorf("nats server not ready")
+	}
+	s.nc, err = nats.Connect(s.ns.ClientURL())
+	if err != nil {
+		return fmt.Errorf("connecting to nats: %w", err)
+	}
+	if s.stream != "" {
+		if err := s.createStream(); err != nil {
+			return err
+		}
+	}
+	subs := map[string]nats.MsgHandler{
+		gateway.SubjectFilesCreate: s.createFile,
+		gateway.SubjectTasksPoke:   s.poke,
+		gateway.SubjectStoreGet:    s.storeGet,
+		gateway.SubjectStoreSet:    s.storeSet,
+		gateway.SubjectStoreDelete: s.storeDelete,
+	}
+	for subj, h := range subs {
+		if _, err := s.nc.Subscribe(subj, h); err != nil {
+			return fmt.Errorf("subscribing to %s: %w", subj, err)
+		}
+	}
+	if err := s.nc.Flush(); err != nil {
+		return fmt.Errorf("flushing subscriptions: %w", err)
+	}
+	s.silo = httptest.NewServer(s.siloHandler())
+	return nil
+}
+
+func (s *Server) createStream() error {
+	js, err := jetstream.New(s.nc)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+	_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:     s.stream,
+		Subjects: []string{streamSubj},
+	})
+	if err != nil {
+		return fmt.Errorf("creating stream: %w", err)
+	}
+	return nil
+}
+
+// URL provides the NATS URL clients should connect to.
+func (s *Server) URL() string {
+	return s.ns.ClientURL()
+}
+
+// SiloURL provides the base URL of the fake silo used for uploading and
+// fetching files.
+func (s *Server) SiloURL() string {
+	return s.silo.URL
+}
+
+// Close disconnects any clients prepared by the server and shuts down the
+// NATS server and fake silo.
+func (s *Server) Close() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, nc := range conns {
+		nc.Close()
+	}
+	if s.nc != nil {
+		s.nc.Close()
+	}
+	if s.silo != nil {
+		s.silo.Close()
+	}
+	if s.ns != nil {
+		s.ns.Shutdown()
+		s.ns.WaitForShutdown()
+	}
+	if s.dir != "" {
+		os.RemoveAll(s.dir) // nolint:errcheck
+	}
+}
+
+// Client provides a new gateway client with the service name, connected to
+// the server and configured to upload files to the fake silo. Additional
+// options may be provided to further configure the client, such as the task
+// handler. This will panic if a connection cannot be made.
+func (s *Server) Client(name string, opts ...gateway.Option) *gateway.Client {
+	nc, err := nats.Connect(s.URL(), nats.Name(name))
+	if err != nil {
+		panic(fmt.Sprintf("gatewaytest: connecting to nats: %v", err))
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, nc)
+	s.mu.Unlock()
+
+	opts = append([]gateway.Option{
+		gateway.WithName(name),
+		gateway.WithNATS(nc),
+		gateway.WithSiloPublicBaseURL(s.silo.URL),
+	}, opts...)
+	if s.stream != "" {
+		opts = append(opts, gateway.WithJetStream(&gateway.JetStream{Stream: s.stream}))
+	}
+	return gateway.New(opts...)
+}
+
+// SendTask sends the task to the service in the same way as the gateway and
+// waits for the result. Use the context to control how long to wait.
+func (s *Server) SendTask(ctx context.Context, name string, t *gateway.Task) (*gateway.TaskResult, error) {
+	data, err := proto.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	subj := fmt.Sprintf(gateway.SubjectTaskFmt, name)
+	var m *nats.Msg
+	if s.stream != "" {
+		m, err = s.sendJetStreamTask(ctx, subj, data)
+	} else {
+		m, err = s.requestTask(ctx, subj, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sending task: %w", err)
+	}
+	res := new(gateway.TaskResult)
+	if err := proto.Unmarshal(m.Data, res); err != nil {
+		return nil, fmt.Errorf("parsing task result: %w", err)
+	}
+	return res, nil
+}
+
+// CancelTask publishes the request to cancel tasks to all the instances of
+// the service without waiting for any responses. Clients will only receive
+// the request if created with gateway.WithTaskCancellation.
+func (s *Server) CancelTask(name string, req *gateway.TaskCancel) error {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if err := s.nc.Publish(fmt.Sprintf(gateway.SubjectTaskCancelFmt, name), data); err != nil {
+		return err
+	}
+	return s.nc.Flush()
+}
+
+// requestTask sends the task, retrying while there are no responders as
+// subscriptions from recently started clients may not yet be ready.
+func (s *Server) requestTask(ctx context.Context, subj string, data []byte) (*nats.Msg, error) {
+	for {
+		m, err := s.nc.RequestWithContext(ctx, subj, data)
+		if !errors.Is(err, nats.ErrNoResponders) {
+			return m, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (s *Server) sendJetStreamTask(ctx context.Context, subj string, data []byte) (*nats.Msg, error) {
+	js, err := jetstream.New(s.nc)
+	if err != nil {
+		return nil, err
+	}
+	inbox := nats.NewInbox()
+	sub, err := s.nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe() // nolint:errcheck
+
+	msg := nats.NewMsg(subj)
+	msg.Data = data
+	msg.Header.Set(gateway.HeaderTaskReply, inbox)
+	if _, err := js.PublishMsg(ctx, msg); err != nil {
+		return nil, err
+	}
+	return sub.NextMsgWithContext(ctx)
+}
+
+// Pokes provides the list of poke requests received so far.
+func (s *Server) Pokes() []*gateway.TaskPoke {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*gateway.TaskPoke(nil), s.pokes...)
+}
+
+func (s *Server) poke(m *nats.Msg) {
+	req := new(gateway.TaskPoke)
+	res := new(gateway.TaskPokeResponse)
+	if err := proto.Unmarshal(m.Data, req); err != nil {
+		res.Err = invalid("parsing request: %v", err)
+		respond(m, res)
+		return
+	}
+	s.mu.Lock()
+	s.pokes = append(s.pokes, req)
+	s.mu.Unlock()
+	if s.ph != nil {
+		if err := s.ph(req); err != nil {
+			res.Err = asError(err)
+		}
+	}
+	respond(m, res)
+}
+
+func respond(m *nats.Msg, res proto.Message) {
+	data, err := proto.Marshal(res)
+	if err != nil {
+		panic(fmt.Sprintf("gatewaytest: marshalling response: %v", err))
+	}
+	m.Respond(data) // nolint:errcheck
+}
+
+func invalid(format string, args ...any) *gateway.Error {
+	return &gateway.Error{Code: gateway.ErrorCode_INVALID, Message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(what string) *gateway.Error {
+	return &gateway.Error{Code: gateway.ErrorCode_NOT_FOUND, Message: what + " not found"}
+}
+
+func conflict(msg string) *gateway.Error {
+	return &gateway.Error{Code: gateway.ErrorCode_CONFLICT, Message: msg}
+}
+
+func asError(err error) *gateway.Error {
+	if e := gateway.AsError(err); e != nil {
+		return e
+	}
+	return &gateway.Error{Code: gateway.ErrorCode_INTERNAL, Message: err.Error()}
+}
@@ -0,0 +1,188 @@
+package gatewaytest_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/invopop/client.go/gateway"
+	"github.com/invopop/client.go/gateway/gatewaytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+const testService = "test"
+
+func startClient(t *testing.T, srv *gatewaytest.Server, th gateway.TaskHandler) *gateway.Client {
+	t.Helper()
+	gw := srv.Client(testService, gateway.WithTaskHandler(th))
+	require.NoError(t, gw.Start())
+	t.Cleanup(gw.Stop)
+	return gw
+}
+
+func sendTask(t *testing.T, srv *gatewaytest.Server, task *gateway.Task) *gateway.TaskResult {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := srv.SendTask(ctx, testService, task)
+	require.NoError(t, err)
+	return res
+}
+
+func TestSendTask(t *testing.T) {
+	srv := gatewaytest.NewServer()
+	t.Cleanup(srv.Close)
+	startClient(t, srv, func(_ context.Context, task *gateway.Task) *gateway.TaskResult {
+		if task.Action == "fail" {
+			return gateway.TaskKO(errors.New("failed"))
+		}
+		return &gateway.TaskResult{Status: gateway.TaskStatus_OK, Ref: task.Id}
+	})
+
+	res := sendTask(t, srv, &gateway.Task{Id: "task-1", Action: "sign"})
+	assert.Equal(t, gateway.TaskStatus_OK, res.Status)
+	assert.Equal(t, "task-1", res.Ref)
+
+	res = sendTask(t, srv, &gateway.Task{Id: "task-2", Action: "fail"})
+	assert.Equal(t, gateway.TaskStatus_KO, res.Status)
+	assert.Equal(t, "failed", res.Message)
+}
+
+func TestSendTaskWithJetStream(t *testing.T) {
+	srv := gatewaytest.NewServer(gatewaytest.WithJetStream("TASKS"))
+	t.Cleanup(srv.Close)
+	var calls atomic.Int32
+	startClient(t, srv, func(_ context.Context, _ *gateway.Task) *gateway.TaskResult {
+		if calls.Add(1) == 1 {
+			return gateway.TaskQueued("not ready", 0)
+		}
+		return gateway.TaskOK()
+	})
+
+	res := sendTask(t, srv, &gateway.Task{Id: "task-1"})
+	assert.Equal(t, gateway.TaskStatus_OK, res.Status)
+	assert.EqualValues(t, 2, calls.Load())
+}
+
+func TestFiles(t *testing.T) {
+	srv := gatewaytest.NewServer()
+	t.Cleanup(srv.Close)
+	var gw *gateway.Client
+	gw = startClient(t, srv, func(ctx context.Context, task *gateway.Task) *gateway.TaskResult {
+		f, err := gw.CreateAndUploadFile(ctx, &gateway.CreateFile{
+			Id:          "file-1",
+			SiloEntryId: task.SiloEntryId,
+			Name:        "test.txt",
+		}, []byte("hello world"))
+		if err != nil {
+			return gateway.TaskError(err)
+		}
+		data, err := gw.FetchFile(ctx, f)
+		if err != nil {
+			return gateway.TaskError(err)
+		}
+		return &gateway.TaskResult{Status: gateway.TaskStatus_OK, Data: data}
+	})
+
+	res := sendTask(t, srv, &gateway.Task{Id: "task-1", SiloEntryId: "entry-1"})
+	require.Equal(t, gateway.TaskStatus_OK, res.Status, res.Message)
+	assert.Equal(t, "hello world", string(res.Data))
+
+	f := srv.File("file-1")
+	require.NotNil(t, f)
+	assert.Equal(t, "entry-1", f.SiloEntryId)
+	assert.Equal(t, "text/plain; charset=utf-8", f.Mime)
+	assert.True(t, f.Uploaded)
+	assert.Equal(t, "hello world", string(f.Data))
+	assert.Len(t, srv.Files(), 1)
+	assert.Nil(t, srv.File("missing"))
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := gw.CreateFile(context.Background(), &gateway.CreateFile{SiloEntryId: "entry-1"})
+		assert.True(t, gateway.IsValidationError(err))
+	})
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		f, err := gw.CreateFile(context.Background(), &gateway.CreateFile{
+			SiloEntryId: "entry-1",
+			Name:        "other.txt",
+			Sha256:      "invalid",
+		})
+		require.NoError(t, err)
+		err = gw.UploadFile(context.Background(), f, nil)
+		assert.ErrorContains(t, err, "400")
+	})
+}
+
+func TestPoke(t *testing.T) {
+	var poked *gateway.TaskPoke
+	srv := gatewaytest.NewServer(gatewaytest.WithPokeHandler(func(p *gateway.TaskPoke) error {
+		poked = p
+		if p.Ref == "missing" {
+			return &gateway.Error{Code: gateway.ErrorCode_NOT_FOUND, Message: "task not found"}
+		}
+		return nil
+	}))
+	t.Cleanup(srv.Close)
+	gw := srv.Client(testService)
+
+	ctx := context.Background()
+	require.NoError(t, gw.Poke(ctx, &gateway.TaskPoke{Ref: "ref-1"}))
+	assert.Equal(t, "ref-1", poked.Ref)
+
+	err := gw.Poke(ctx, &gateway.TaskPoke{Ref: "missing"})
+	assert.True(t, gateway.IsNotFoundError(err))
+
+	pokes := srv.Pokes()
+	require.Len(t, pokes, 2)
+	assert.Equal(t, "ref-1", pokes[0].Ref)
+}
+
+func TestStore(t *testing.T) {
+	srv := gatewaytest.NewServer()
+	t.Cleanup(srv.Close)
+	gw := srv.Client(testService)
+	ctx := context.Background()
+
+	_, err := gw.StoreGet(ctx, &gateway.StoreGet{OwnerId: "owner", Key: "token"})
+	assert.True(t, gateway.IsNotFoundError(err))
+
+	e, err := gw.StoreSet(ctx, &gateway.StoreSet{
+		OwnerId:  "owner",
+		Key:      "token",
+		Value:    []byte("abc"),
+		Revision: proto.Uint64(0),
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, e.Revision)
+
+	_, err = gw.StoreSet(ctx, &gateway.StoreSet{
+		OwnerId:  "owner",
+		Key:      "token",
+		Revision: proto.Uint64(0),
+	})
+	assert.True(t, gateway.IsConflictError(err))
+
+	e, err = gw.StoreGet(ctx, &gateway.StoreGet{OwnerId: "owner", Key: "token"})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(e.Value))
+
+	se := srv.StoreEntry("owner", testService, "token")
+	require.NotNil(t, se)
+	assert.Equal(t, "abc", string(se.Value))
+
+	srv.SetStoreEntry("owner", testService, "token", []byte("def"))
+	e, err = gw.StoreGet(ctx, &gateway.StoreGet{OwnerId: "owner", Key: "token"})
+	require.NoError(t, err)
+	assert.Equal(t, "def", string(e.Value))
+	assert.EqualValues(t, 2, e.Revision)
+
+	err = gw.StoreDelete(ctx, &gateway.StoreDelete{OwnerId: "owner", Key: "token", Revision: proto.Uint64(1)})
+	assert.True(t, gateway.IsConflictError(err))
+	require.NoError(t, gw.StoreDelete(ctx, &gateway.StoreDelete{OwnerId: "owner", Key: "token"}))
+	assert.Nil(t, srv.StoreEntry("owner", testService, "token"))
+}
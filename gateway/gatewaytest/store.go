@@ -0,0 +1,146 @@
+package gatewaytest
+
+import (
+	"time"
+
+	"github.com/invopop/client.go/gateway"
+	nats "github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+)
+
+// StoreEntry provides a copy of the entry in the key-value store, or nil
+// if it does not exist or has expired.
+func (s *Server) StoreEntry(ownerID, provider, key string) *gateway.StoreEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.findStoreEntry(storeKey(ownerID, provider, key))
+	if e == nil {
+		return nil
+	}
+	return proto.Clone(e).(*gateway.StoreEntry)
+}
+
+// SetStoreEntry adds or replaces the value in the key-value store directly
+// so that it can be read by clients.
+func (s *Server) SetStoreEntry(ownerID, provider, key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putStoreEntry(storeKey(ownerID, provider, key), key, value, 0)
+}
+
+func storeKey(ownerID, provider, key string) string {
+	return ownerID + "/" + provider + "/" + key
+}
+
+// findStoreEntry provides the entry with the key, removing it if it has
+// expired.
+func (s *Server) findStoreEntry(sk string) *gateway.StoreEntry {
+	e, ok := s.store[sk]
+	if !ok {
+		return nil
+	}
+	if e.ExpiresTs != 0 && e.ExpiresTs <= time.Now().Unix() {
+		delete(s.store, sk)
+		return nil
+	}
+	return e
+}
+
+func (s *Server) putStoreEntry(sk, key string, value []byte, ttl int32) *gateway.StoreEntry {
+	tn := time.Now().Unix()
+	e := s.findStoreEntry(sk)
+	if e == nil {
+		e = &gateway.StoreEntry{Key: key, CreatedTs: tn}
+		s.store[sk] = e
+	}
+	e.Value = value
+	e.Revision++
+	e.UpdatedTs = tn
+	e.ExpiresTs = 0
+	if ttl > 0 {
+		e.ExpiresTs = tn + int64(ttl)
+	}
+	return e
+}
+
+func (s *Server) storeGet(m *nats.Msg) {
+	req := new(gateway.StoreGet)
+	res := new(gateway.StoreResponse)
+	if err := proto.Unmarshal(m.Data, req); err != nil {
+		res.Err = invalid("parsing request: %v", err)
+		respond(m, res)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if res.Entry = s.findStoreEntry(storeKey(req.OwnerId, req.Provider, req.Key)); res.Entry == nil {
+		res.Err = notFound("entry")
+	}
+	respond(m, res)
+}
+
+func (s *Server) storeSet(m *nats.Msg) {
+	req := new(gateway.StoreSet)
+	res := new(gateway.StoreResponse)
+	if err := proto.Unmarshal(m.Data, req); err != nil {
+		res.Err = invalid("parsing request: %v", err)
+		respond(m, res)
+		return
+	}
+	if req.Key == "" {
+		res.Err = invalid("key: cannot be blank")
+		respond(m, res)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sk := storeKey(req.OwnerId, req.Provider, req.Key)
+	if req.Revision != nil {
+		if err := checkRevision(s.findStoreEntry(sk), *req.Revision); err != nil {
+			res.Err = err
+			respond(m, res)
+			return
+		}
+	}
+	res.Entry = s.putStoreEntry(sk, req.Key, req.Value, req.Ttl)
+	respond(m, res)
+}
+
+func (s *Server) storeDelete(m *nats.Msg) {
+	req := new(gateway.StoreDelete)
+	res := new(gateway.StoreResponse)
+	if err := proto.Unmarshal(m.Data, req); err != nil {
+		res.Err = invalid("parsing request: %v", err)
+		respond(m, res)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sk := storeKey(req.OwnerId, req.Provider, req.Key)
+	e := s.findStoreEntry(sk)
+	switch {
+	case e == nil:
+		res.Err = notFound("entry")
+	case req.Revision != nil:
+		res.Err = checkRevision(e, *req.Revision)
+	}
+	if res.Err == nil {
+		delete(s.store, sk)
+	}
+	respond(m, res)
+}
+
+// checkRevision ensures the entry matches the expected revision, where zero
+// implies the entry should not exist.
+func checkRevision(e *gateway.StoreEntry, rev uint64) *gateway.Error {
+	switch {
+	case rev == 0 && e != nil:
+		return conflict("entry already exists")
+	case rev != 0 && (e == nil || e.Revision != rev):
+		return conflict("entry revision mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// Health describes the current state of the client, as provided by the
+// Health method.
+type Health struct {
+	// Started is true once Start has completed successfully.
+	Started bool `json:"started"`
+	// Stopping is true once Stop or Shutdown have been called.
+	Stopping bool `json:"stopping"`
+	// Connection is the status of the NATS connection, such as "CONNECTED"
+	// or "RECONNECTING", or empty if not started.
+	Connection string `json:"connection,omitempty"`
+	// Subscribed is true while the subscriptions used to receive tasks and
+	// cancellation requests, if enabled, are active.
+	Subscribed bool `json:"subscribed"`
+	// Workers is the number of workers available to process tasks, of which
+	// Busy are currently processing one.
+	Workers int `json:"workers"`
+	Busy    int `json:"busy"`
+	// Utilisation is the fraction of busy workers, between 0 and 1.
+	Utilisation float64 `json:"utilisation"`
+	// LastTaskAt is when the last task was completed, if any.
+	LastTaskAt time.Time `json:"last_task_at,omitzero"`
+}
+
+// Connected returns true if the NATS connection is currently established.
+func (h *Health) Connected() bool {
+	return h.Connection == nats.CONNECTED.String()
+}
+
+// Live returns false if the client cannot recover by itself because the NATS
+// connection was closed while it should still be receiving tasks, implying
+// the process should be restarted.
+func (h *Health) Live() bool {
+	return !h.Started || h.Stopping || h.Connection != nats.CLOSED.String()
+}
+
+// Ready returns true if the client is connected and able to receive new
+// tasks.
+func (h *Health) Ready() bool {
+	return h.Started && !h.Stopping && h.Connected() && h.Subscribed
+}
+
+// Health provides a snapshot of the client's current state.
+func (gw *Client) Health() *Health {
+	h := &Health{
+		Stopping: gw.stopping.Load(),
+		Workers:  gw.WorkerCount(),
+		Busy:     gw.stats.busy(),
+	}
+	if ts := gw.lastTaskAt.Load(); ts > 0 {
+		h.LastTaskAt = time.Unix(0, ts)
+	}
+	if h.Workers > 0 {
+		h.Utilisation = float64(h.Busy) / float64(h.Workers)
+	}
+	// the client's fields are only safe to read once started
+	if h.Started = gw.started.Load(); h.Started {
+		h.Connection = gw.nc.Status().String()
+		h.Subscribed = gw.subscribed()
+	}
+	return h
+}
+
+// subscribed checks if the subscriptions used to receive tasks are still
+// active.
+func (gw *Client) subscribed() bool {
+	if gw.cancellation && (gw.cancelSub == nil || !gw.cancelSub.IsValid()) {
+		return false
+	}
+	if gw.js != nil {
+		select {
+		case <-gw.jsDone:
+			return false
+		default:
+			return true
+		}
+	}
+	return gw.sub != nil && gw.sub.IsValid()
+}
+
+// HealthHandler provides an http.Handler that responds to the "/healthz"
+// and "/readyz" paths for use with liveness and readiness probes, with a
+// 200 status if live or ready respectively, or 503 otherwise. Readiness
+// will fail as soon as the client starts stopping, so that no new requests
+// are routed to the service. The body contains the client's Health as JSON.
+//
+// Usage example:
+//
+//	go http.ListenAndServe(":8081", gw.HealthHandler())
+func (gw *Client) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		h := gw.Health()
+		writeHealth(w, h, h.Live())
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		h := gw.Health()
+		writeHealth(w, h, h.Ready())
+	})
+	return mux
+}
+
+func writeHealth(w http.ResponseWriter, h *Health, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h) // nolint:errcheck
+}
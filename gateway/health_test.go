@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthStates(t *testing.T) {
+	tests := []struct {
+		name  string
+		h     Health
+		live  bool
+		ready bool
+	}{
+		{"not started", Health{}, true, false},
+		{"ready", Health{Started: true, Connection: "CONNECTED", Subscribed: true}, true, true},
+		{"reconnecting", Health{Started: true, Connection: "RECONNECTING", Subscribed: true}, true, false},
+		{"unsubscribed", Health{Started: true, Connection: "CONNECTED"}, true, false},
+		{"closed", Health{Started: true, Connection: "CLOSED"}, false, false},
+		{"stopping", Health{Started: true, Stopping: true, Connection: "CLOSED"}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.live, tt.h.Live())
+			assert.Equal(t, tt.ready, tt.h.Ready())
+		})
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	nc := runNATSServer(t)
+	gw := New(
+		WithName("test"),
+		WithNATS(nc),
+		WithWorkerCount(2),
+		WithTaskHandler(func(_ context.Context, _ *Task) *TaskResult {
+			started <- struct{}{}
+			<-release
+			return nil
+		}),
+	)
+	hh := gw.HealthHandler()
+	probe := func(path string) (int, *Health) {
+		rec := httptest.NewRecorder()
+		hh.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		h := new(Health)
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), h))
+		return rec.Code, h
+	}
+
+	code, h := probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, h.Started)
+	code, _ = probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	require.NoError(t, gw.Start())
+	code, h = probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "CONNECTED", h.Connection)
+	assert.True(t, h.Subscribed)
+	assert.Equal(t, 2, h.Workers)
+	assert.True(t, h.LastTaskAt.IsZero())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := requestTask(nc, &Task{Id: "task-1"})
+		assert.NoError(t, err)
+	}()
+	<-started
+	h = gw.Health()
+	assert.Equal(t, 1, h.Busy)
+	assert.InDelta(t, 0.5, h.Utilisation, 0.001)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		gw.Stop()
+	}()
+	require.Eventually(t, func() bool {
+		code, _ := probe("/readyz")
+		return code == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond, "not ready while stopping")
+	code, h = probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, h.Stopping)
+
+	close(release)
+	<-done
+	<-stopped
+	h = gw.Health()
+	assert.Zero(t, h.Busy)
+	assert.False(t, h.Subscribed)
+	assert.False(t, h.LastTaskAt.IsZero())
+}
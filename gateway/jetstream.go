@@ -0,0 +1,267 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+// HeaderTaskReply is the message header used by the gateway to indicate where
+// task results should be sent when tasks are consumed from JetStream, as
+// the original reply subject is not preserved by streams.
+const HeaderTaskReply = "Gw-Task-Reply"
+
+const (
+	defaultJetStreamMaxDeliver = 5
+	defaultJetStreamAckWait    = 30 * time.Second
+	// minJetStreamAckWait prevents progress heartbeats from being sent
+	// excessively.
+	minJetStreamAckWait   = 100 * time.Millisecond
+	jetStreamSetupTimeout = 10 * time.Second
+	// jetStreamFetchWait is how long each request for a task waits before
+	// being sent again.
+	jetStreamFetchWait = 10 * time.Second
+	// jetStreamFetchRetry is how long to wait after failing to fetch a task.
+	jetStreamFetchRetry = time.Second
+)
+
+// JetStream contains the configuration used to consume tasks from a durable
+// JetStream pull consumer instead of a core NATS queue subscription, so
+// that tasks being processed are delivered again if the service stops
+// before completing them.
+type JetStream struct {
+	// Stream is the name of the existing stream that tasks are published to.
+	Stream string
+	// Consumer is the name of the durable consumer, which will be created or
+	// updated when starting. Defaults to "<name>_tasks".
+	Consumer string
+	// MaxDeliver is the maximum number of times a task will be delivered
+	// before giving up. Defaults to 5, use -1 for no limit.
+	MaxDeliver int
+	// AckWait is how long the server waits for a task to be acknowledged
+	// before delivering it again. In progress heartbeats are sent at half
+	// this interval while the task handler is running. Defaults to 30 seconds,
+	// and must be at least 100ms.
+	AckWait time.Duration
+}
+
+// WithJetStream configures the gateway client to consume tasks from a
+// JetStream durable pull consumer with explicit acknowledgements. Completed
+// tasks will be acknowledged after sending the result, while tasks that
+// respond with a QUEUED status will be delivered again after the RetryIn
+// period, until the maximum number of deliveries is reached.
+func WithJetStream(conf *JetStream) Option {
+	return func(gw *Client) {
+		js := *conf
+		if js.MaxDeliver == 0 {
+			js.MaxDeliver = defaultJetStreamMaxDeliver
+		}
+		if js.AckWait <= 0 {
+			js.AckWait = defaultJetStreamAckWait
+		}
+		if js.AckWait < minJetStreamAckWait {
+			gw.err = fmt.Errorf("jetstream ack wait must be at least %s", minJetStreamAckWait)
+		}
+		gw.js = &js
+	}
+}
+
+func (gw *Client) subscribeJetStream() error {
+	if gw.js.Stream == "" {
+		return errors.New("stream required")
+	}
+	js, err := jetstream.New(gw.nc)
+	if err != nil {
+		return err
+	}
+	name := gw.js.Consumer
+	if name == "" {
+		// durable names cannot contain dots
+		name = strings.ReplaceAll(fmt.Sprintf(QueueNameTaskFmt, gw.name), ".", "_")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jetStreamSetupTimeout)
+	defer cancel()
+	cons, err := js.CreateOrUpdateConsumer(ctx, gw.js.Stream, jetstream.ConsumerConfig{
+		Durable:       name,
+		FilterSubject: fmt.Sprintf(SubjectTaskFmt, gw.name),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       gw.js.AckWait,
+		MaxDeliver:    gw.js.MaxDeliver,
+	})
+	if err != nil {
+		return fmt.Errorf("preparing consumer: %w", err)
+	}
+	gw.jsReady = make(chan struct{})
+	gw.jsIncoming = make(chan jetstream.Msg)
+	gw.jsStop = make(chan struct{})
+	gw.jsDone = make(chan struct{})
+	go gw.fetchJetStreamTasks(cons)
+	return nil
+}
+
+// fetchJetStreamTasks requests a task from the consumer each time a worker
+// is free and passes it on, until stopped. Tasks are not fetched in advance
+// as they would not receive progress heartbeats while waiting for a worker,
+// and could be delivered again before being processed.
+func (gw *Client) fetchJetStreamTasks(cons jetstream.Consumer) {
+	defer close(gw.jsDone)
+	defer close(gw.jsIncoming) // releases any worker waiting for a task
+	pending := false
+	for {
+		tn := time.Now()
+		select {
+		case <-gw.jsReady:
+		case <-gw.jsStop:
+			return
+		}
+		if pending {
+			// tasks were known to be waiting in the consumer
+			gw.stats.taskWaited(time.Since(tn))
+		}
+		msg := gw.fetchJetStreamTask(cons)
+		if msg == nil {
+			return
+		}
+		pending = jetStreamQueueDepth(msg) > 0
+		gw.jsIncoming <- msg
+	}
+}
+
+// fetchJetStreamTask waits for the next task from the consumer, or provides
+// nil once stopped. A task delivered after stopping will be left for the
+// server to deliver again once the ack wait period has passed.
+func (gw *Client) fetchJetStreamTask(cons jetstream.Consumer) jetstream.Msg {
+	for {
+		batch, err := cons.Fetch(1, jetstream.FetchMaxWait(jetStreamFetchWait))
+		if err == nil {
+			select {
+			case msg, ok := <-batch.Messages():
+				if ok {
+					return msg
+				}
+				err = batch.Error()
+			case <-gw.jsStop:
+				return nil
+			}
+		}
+		if err == nil || errors.Is(err, nats.ErrTimeout) {
+			continue
+		}
+		if gw.nc.IsClosed() {
+			// nothing else can be fetched or acknowledged
+			return nil
+		}
+		log.Warn().Err(err).Msg("gateway: fetching jetstream task")
+		select {
+		case <-time.After(jetStreamFetchRetry):
+		case <-gw.jsStop:
+			return nil
+		}
+	}
+}
+
+// stopJetStream stops fetching new tasks and waits for any already fetched
+// to be passed to the workers.
+func (gw *Client) stopJetStream() {
+	close(gw.jsStop)
+	<-gw.jsDone
+}
+
+// startJetStreamWorker lets the fetcher know when the worker is free, and
+// processes the task fetched for it.
+func (gw *Client) startJetStreamWorker(stop <-chan struct{}) {
+	for !stopped(stop) {
+		select {
+		case gw.jsReady <- struct{}{}:
+		case <-gw.jsDone:
+			return
+		case <-stop:
+			return
+		}
+		// a task is now being fetched for this worker, which must be
+		// received even if asked to stop
+		msg, ok := <-gw.jsIncoming
+		if !ok {
+			return
+		}
+		gw.metrics.QueueDepth(gw.name, jetStreamQueueDepth(msg))
+		gw.processJetStreamTask(msg)
+	}
+}
+
+// jetStreamQueueDepth provides the number of tasks waiting in the consumer
+// to be delivered, as reported alongside the message.
+func jetStreamQueueDepth(msg jetstream.Msg) int {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return 0
+	}
+	return int(meta.NumPending)
+}
+
+func (gw *Client) processJetStreamTask(msg jetstream.Msg) {
+	m := &nats.Msg{
+		Subject: msg.Subject(),
+		Header:  msg.Headers(),
+		Data:    msg.Data(),
+	}
+	stop := gw.sendAckProgress(msg)
+	t, res := gw.runTask(m)
+	stop()
+
+	if res.Status == TaskStatus_QUEUED && !gw.lastDelivery(msg) {
+		delay := time.Duration(res.RetryIn) * time.Second
+		if err := msg.NakWithDelay(delay); err != nil {
+			log.Error().Str("task_id", t.Id).Err(err).Msg("unable to nak task")
+		}
+		return
+	}
+
+	if subj := m.Header.Get(HeaderTaskReply); subj != "" {
+		gw.reply(subj, t, res)
+	}
+	if err := msg.Ack(); err != nil {
+		log.Error().Str("task_id", t.Id).Err(err).Msg("unable to ack task")
+	}
+}
+
+// sendAckProgress will periodically let the server know that the task is
+// still being processed so that it is not delivered again. The returned
+// function must be called to stop.
+func (gw *Client) sendAckProgress(msg jetstream.Msg) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(gw.js.AckWait / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := msg.InProgress(); err != nil {
+					log.Warn().Err(err).Msg("gateway: sending task progress")
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// lastDelivery returns true if the message will not be delivered again.
+func (gw *Client) lastDelivery(msg jetstream.Msg) bool {
+	if gw.js.MaxDeliver < 0 {
+		return false
+	}
+	meta, err := msg.Metadata()
+	if err != nil {
+		return true
+	}
+	return meta.NumDelivered >= uint64(gw.js.MaxDeliver)
+}
@@ -0,0 +1,270 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+const testStream = "TASKS"
+
+func runJetStreamServer(t *testing.T) *nats.Conn {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	require.True(t, ns.ReadyForConnections(5*time.Second), "nats server not ready")
+
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	_, err = js.CreateStream(context.Background(), jetstream.StreamConfig{
+		Name:     testStream,
+		Subjects: []string{"gw.*.task"},
+	})
+	require.NoError(t, err)
+	return nc
+}
+
+// startJetStreamGateway starts a gateway client with the handler and
+// provides a function to publish tasks alongside a channel to receive the
+// results.
+func startJetStreamGateway(t *testing.T, conf *JetStream, th TaskHandler, opts ...Option) (func(*Task), <-chan *TaskResult) {
+	t.Helper()
+	nc := runJetStreamServer(t)
+	conf.Stream = testStream
+	gw := New(append([]Option{
+		WithName("test"),
+		WithNATS(nc),
+		WithJetStream(conf),
+		WithTaskHandler(th),
+		WithWorkerCount(2),
+	}, opts...)...)
+	require.NoError(t, gw.Start())
+	t.Cleanup(gw.Stop)
+
+	results := make(chan *TaskResult, 10)
+	sub, err := nc.Subscribe("test.results", func(m *nats.Msg) {
+		res := new(TaskResult)
+		if assert.NoError(t, proto.Unmarshal(m.Data, res)) {
+			results <- res
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { sub.Unsubscribe() }) // nolint:errcheck
+
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	publish := func(task *Task) {
+		data, err := proto.Marshal(task)
+		require.NoError(t, err)
+		msg := nats.NewMsg("gw.test.task")
+		msg.Data = data
+		msg.Header.Set(HeaderTaskReply, "test.results")
+		_, err = js.PublishMsg(context.Background(), msg)
+		require.NoError(t, err)
+	}
+	return publish, results
+}
+
+func waitForResult(t *testing.T, results <-chan *TaskResult) *TaskResult {
+	t.Helper()
+	select {
+	case res := <-results:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for task result")
+		return nil
+	}
+}
+
+func assertNoResult(t *testing.T, results <-chan *TaskResult) {
+	t.Helper()
+	select {
+	case res := <-results:
+		t.Fatalf("unexpected result: %v", res)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestJetStream(t *testing.T) {
+	t.Run("ack", func(t *testing.T) {
+		var calls atomic.Int32
+		publish, results := startJetStreamGateway(t, new(JetStream), func(_ context.Context, task *Task) *TaskResult {
+			calls.Add(1)
+			return &TaskResult{Status: TaskStatus_OK, Ref: task.Id}
+		})
+		publish(&Task{Id: "task-1"})
+		res := waitForResult(t, results)
+		assert.Equal(t, TaskStatus_OK, res.Status)
+		assert.Equal(t, "task-1", res.Ref)
+		assertNoResult(t, results)
+		assert.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("queued tasks are delivered again", func(t *testing.T) {
+		var calls atomic.Int32
+		publish, results := startJetStreamGateway(t, new(JetStream), func(_ context.Context, _ *Task) *TaskResult {
+			if calls.Add(1) == 1 {
+				return TaskQueued("not ready", 0)
+			}
+			return TaskOK()
+		})
+		publish(&Task{Id: "task-1"})
+		res := waitForResult(t, results)
+		assert.Equal(t, TaskStatus_OK, res.Status)
+		assert.EqualValues(t, 2, calls.Load())
+	})
+
+	t.Run("queued tasks respect retry in", func(t *testing.T) {
+		var first time.Time
+		var retried atomic.Int64
+		publish, results := startJetStreamGateway(t, new(JetStream), func(_ context.Context, _ *Task) *TaskResult {
+			if first.IsZero() {
+				first = time.Now()
+				return TaskQueued("not ready", 1)
+			}
+			retried.Store(int64(time.Since(first)))
+			return TaskOK()
+		})
+		publish(&Task{Id: "task-1"})
+		waitForResult(t, results)
+		assert.GreaterOrEqual(t, time.Duration(retried.Load()), time.Second)
+	})
+
+	t.Run("max deliver", func(t *testing.T) {
+		var calls atomic.Int32
+		conf := &JetStream{MaxDeliver: 2}
+		publish, results := startJetStreamGateway(t, conf, func(_ context.Context, _ *Task) *TaskResult {
+			calls.Add(1)
+			return TaskQueued("not ready", 0)
+		})
+		publish(&Task{Id: "task-1"})
+		res := waitForResult(t, results)
+		assert.Equal(t, TaskStatus_QUEUED, res.Status)
+		assertNoResult(t, results)
+		assert.EqualValues(t, 2, calls.Load())
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		var calls atomic.Int32
+		release := make(chan struct{})
+		conf := &JetStream{AckWait: 200 * time.Millisecond}
+		var gw *Client
+		publish, results := startJetStreamGateway(t, conf, func(_ context.Context, _ *Task) *TaskResult {
+			calls.Add(1)
+			<-release
+			return TaskOK()
+		}, func(c *Client) { gw = c })
+
+		// watch for heartbeats sent while the handler is busy
+		progress := make(chan struct{}, 10)
+		sub, err := gw.NATS().Subscribe("$JS.ACK.>", func(m *nats.Msg) {
+			if string(m.Data) == "+WPI" {
+				progress <- struct{}{}
+			}
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { sub.Unsubscribe() }) // nolint:errcheck
+
+		publish(&Task{Id: "task-1"})
+		// several heartbeats imply the ack wait has been exceeded
+		for range 3 {
+			select {
+			case <-progress:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no progress sent")
+			}
+		}
+		close(release)
+		res := waitForResult(t, results)
+		assert.Equal(t, TaskStatus_OK, res.Status)
+		assertNoResult(t, results)
+		assert.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("busy workers", func(t *testing.T) {
+		var calls sync.Map
+		release := make(chan struct{})
+		conf := &JetStream{AckWait: 200 * time.Millisecond}
+		var gw *Client
+		publish, results := startJetStreamGateway(t, conf, func(_ context.Context, task *Task) *TaskResult {
+			n, _ := calls.LoadOrStore(task.Id, new(atomic.Int32))
+			n.(*atomic.Int32).Add(1)
+			<-release
+			return TaskOK()
+		}, WithWorkerCount(1), func(c *Client) { gw = c })
+
+		progress := make(chan struct{}, 10)
+		sub, err := gw.NATS().Subscribe("$JS.ACK.>", func(m *nats.Msg) {
+			if string(m.Data) == "+WPI" {
+				progress <- struct{}{}
+			}
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { sub.Unsubscribe() }) // nolint:errcheck
+
+		publish(&Task{Id: "task-1"})
+		publish(&Task{Id: "task-2"})
+		// the second task waits for longer than the ack wait
+		for range 3 {
+			select {
+			case <-progress:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no progress sent")
+			}
+		}
+		close(release)
+		waitForResult(t, results)
+		waitForResult(t, results)
+		assertNoResult(t, results)
+		for _, id := range []string{"task-1", "task-2"} {
+			n, ok := calls.Load(id)
+			require.True(t, ok, id)
+			assert.EqualValues(t, 1, n.(*atomic.Int32).Load(), id)
+		}
+	})
+
+	t.Run("ack wait", func(t *testing.T) {
+		gw := New(WithJetStream(&JetStream{AckWait: -time.Second}))
+		assert.Equal(t, defaultJetStreamAckWait, gw.js.AckWait)
+		assert.NoError(t, gw.err)
+
+		gw = New(
+			WithName("test"),
+			WithJetStream(&JetStream{AckWait: time.Nanosecond}),
+			WithTaskHandler(func(_ context.Context, _ *Task) *TaskResult { return nil }),
+		)
+		assert.ErrorContains(t, gw.Start(), "ack wait must be at least")
+	})
+
+	t.Run("missing stream", func(t *testing.T) {
+		gw := New(
+			WithName("test"),
+			WithNATS(runJetStreamServer(t)),
+			WithJetStream(&JetStream{Stream: "MISSING"}),
+			WithTaskHandler(func(_ context.Context, _ *Task) *TaskResult { return nil }),
+		)
+		assert.ErrorContains(t, gw.Start(), "subscribing to jetstream")
+	})
+}
@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"context"
+	"time"
+)
+
+// Metrics is implemented by types that record statistics about how the
+// gateway client is processing tasks so that they can be exported to a
+// monitoring system. Implementations must be safe for concurrent use.
+//
+// See the pkg/gatewayprom package for a Prometheus implementation.
+type Metrics interface {
+	// QueueDepth reports the number of incoming tasks waiting for a worker,
+	// taken from the subscription's pending messages or, with JetStream, the
+	// consumer's pending count. It is called as each task is received.
+	QueueDepth(service string, depth int)
+	// TaskStarted is called when a worker starts processing a task.
+	TaskStarted(service, action string)
+	// TaskCompleted is called once a task's result is ready to be sent back
+	// to the gateway, including the time it took to process.
+	TaskCompleted(service, action string, status TaskStatus, dur time.Duration)
+	// TaskPanicked is called when a panic is recovered from a task handler.
+	TaskPanicked(service, action string)
+	// FileUploaded is called once for each file uploaded to the silo,
+	// including any retries in the duration, alongside the final error if
+	// the upload failed.
+	FileUploaded(service string, dur time.Duration, err error)
+}
+
+// WithMetrics configures the gateway client to record statistics about
+// task processing and file uploads using the provided implementation.
+func WithMetrics(m Metrics) Option {
+	return func(gw *Client) {
+		gw.metrics = m
+	}
+}
+
+// recoverPanic is used as the panic handler of the recovery middleware so
+// that panics are recorded before passing them on to any custom handler.
+func (gw *Client) recoverPanic(ctx context.Context, t *Task, rec any, stack []byte) *TaskResult {
+	gw.metrics.TaskPanicked(gw.name, t.Action)
+	if gw.panicHandler != nil {
+		return gw.panicHandler(ctx, t, rec, stack)
+	}
+	return nil
+}
+
+// noopMetrics is used when no metrics have been configured.
+type noopMetrics struct{}
+
+func (noopMetrics) QueueDepth(string, int)                                  {}
+func (noopMetrics) TaskStarted(string, string)                              {}
+func (noopMetrics) TaskCompleted(string, string, TaskStatus, time.Duration) {}
+func (noopMetrics) TaskPanicked(string, string)                             {}
+func (noopMetrics) FileUploaded(string, time.Duration, error)               {}
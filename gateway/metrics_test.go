@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+type testMetrics struct {
+	mu        sync.Mutex
+	started   []string
+	completed map[string]TaskStatus
+	panics    []string
+	maxDepth  int
+}
+
+func (tm *testMetrics) QueueDepth(_ string, depth int) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.maxDepth = max(tm.maxDepth, depth)
+}
+
+func (tm *testMetrics) depth() int {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.maxDepth
+}
+
+func (tm *testMetrics) TaskStarted(_, action string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.started = append(tm.started, action)
+}
+
+func (tm *testMetrics) TaskCompleted(_, action string, status TaskStatus, _ time.Duration) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.completed[action] = status
+}
+
+func (tm *testMetrics) TaskPanicked(_, action string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.panics = append(tm.panics, action)
+}
+
+func (tm *testMetrics) FileUploaded(string, time.Duration, error) {}
+
+func TestMetrics(t *testing.T) {
+	tm := &testMetrics{completed: make(map[string]TaskStatus)}
+	var rec any
+	gw := New(
+		WithName("test"),
+		WithMetrics(tm),
+		WithPanicHandler(func(_ context.Context, _ *Task, r any, _ []byte) *TaskResult {
+			rec = r
+			return nil
+		}),
+		WithTaskHandler(func(_ context.Context, t *Task) *TaskResult {
+			if t.Action == "panic" {
+				panic("boom")
+			}
+			return TaskOK()
+		}),
+	)
+	gw.handler = chainTaskMiddleware(gw.th, RecoverTasksWithHandler(gw.recoverPanic))
+
+	for _, action := range []string{"ok", "panic"} {
+		data, err := proto.Marshal(&Task{Id: "1", Action: action})
+		require.NoError(t, err)
+		gw.processTask(&nats.Msg{Data: data})
+	}
+
+	assert.Equal(t, []string{"ok", "panic"}, tm.started)
+	assert.Equal(t, map[string]TaskStatus{
+		"ok":    TaskStatus_OK,
+		"panic": TaskStatus_KO,
+	}, tm.completed)
+	assert.Equal(t, []string{"panic"}, tm.panics)
+	assert.Equal(t, "boom", rec)
+}
+
+func TestQueueDepth(t *testing.T) {
+	const tasks = 5
+
+	t.Run("core", func(t *testing.T) {
+		tm := &testMetrics{completed: make(map[string]TaskStatus)}
+		th, wait, release := blockingHandler(t)
+		nc := runJetStreamServer(t)
+		gw := New(
+			WithName("test"),
+			WithNATS(nc),
+			WithMetrics(tm),
+			WithWorkerCount(1),
+			WithTaskHandler(th),
+		)
+		require.NoError(t, gw.Start())
+		t.Cleanup(gw.Stop)
+
+		results := make(chan *nats.Msg, tasks)
+		sub, err := nc.ChanSubscribe("test.results", results)
+		require.NoError(t, err)
+		t.Cleanup(func() { sub.Unsubscribe() }) // nolint:errcheck
+
+		data, err := proto.Marshal(&Task{Id: "1"})
+		require.NoError(t, err)
+		for range tasks {
+			require.NoError(t, nc.PublishRequest("gw.test.task", "test.results", data))
+		}
+		wait(1)
+		// one task is being processed and another is waiting for the worker
+		assert.Eventually(t, func() bool {
+			return gw.queueDepth() == tasks-2
+		}, 5*time.Second, 10*time.Millisecond)
+		release()
+
+		for range tasks {
+			select {
+			case <-results:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for task result")
+			}
+		}
+		assert.GreaterOrEqual(t, tm.depth(), tasks-3)
+	})
+
+	t.Run("jetstream", func(t *testing.T) {
+		tm := &testMetrics{completed: make(map[string]TaskStatus)}
+		th, wait, release := blockingHandler(t)
+		publish, results := startJetStreamGateway(t, new(JetStream), th,
+			WithMetrics(tm),
+			WithWorkerCount(1),
+		)
+		for i := range tasks {
+			publish(&Task{Id: fmt.Sprintf("task-%d", i)})
+		}
+		wait(1)
+		release()
+		for range tasks {
+			waitForResult(t, results)
+		}
+		assert.Greater(t, tm.depth(), 0)
+	})
+}
@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TaskMiddleware wraps around a task handler to be able to perform actions
+// before or after the task is processed, or even prevent the task from being
+// processed at all.
+type TaskMiddleware func(next TaskHandler) TaskHandler
+
+// PanicHandler is called when a task handler panics with the recovered value
+// and stack trace. The returned result will be sent back to the gateway, or
+// if nil, a KO response will be used instead.
+type PanicHandler func(ctx context.Context, t *Task, rec any, stack []byte) *TaskResult
+
+// RecoverTasks provides the default panic recovery middleware used by the
+// gateway client which logs the panic and responds with a KO status.
+func RecoverTasks() TaskMiddleware {
+	return RecoverTasksWithHandler(nil)
+}
+
+// RecoverTasksWithHandler provides middleware that will recover from panics
+// and pass the details to the handler provided, alongside the default logging.
+func RecoverTasksWithHandler(ph PanicHandler) TaskMiddleware {
+	return func(next TaskHandler) TaskHandler {
+		return func(ctx context.Context, t *Task) (res *TaskResult) {
+			defer func() {
+				if r := recover(); r != nil {
+					// Get stack trace for debugging
+					stack := debug.Stack()
+
+					// Log the panic with full details for monitoring
+					log.Error().
+						Str("task_id", t.Id).
+						Str("action", t.Action).
+						Str("trace", string(stack)).
+						Str("job_id", t.JobId).
+						Str("owner_id", t.OwnerId).
+						Str("silo_entry_id", t.SiloEntryId).
+						Msgf("[PANIC RECOVERED] %v", r)
+
+					if ph != nil {
+						res = ph(ctx, t, r, stack)
+					}
+					if res == nil {
+						// Convert panic to user-friendly TaskKO so that we stop any
+						// future retries. We assume here that retrying will not work
+						// until the underlying issue is fixed.
+						res = TaskKO(errors.New("unexpected data error"))
+					}
+				}
+			}()
+			return next(ctx, t)
+		}
+	}
+}
+
+// chainTaskMiddleware wraps the handler with the middleware so that the first
+// in the list will be the first to be called.
+func chainTaskMiddleware(th TaskHandler, mw ...TaskMiddleware) TaskHandler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		th = mw[i](th)
+	}
+	return th
+}
@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainTaskMiddleware(t *testing.T) {
+	var calls []string
+	mw := func(name string) TaskMiddleware {
+		return func(next TaskHandler) TaskHandler {
+			return func(ctx context.Context, t *Task) *TaskResult {
+				calls = append(calls, name)
+				return next(ctx, t)
+			}
+		}
+	}
+	th := chainTaskMiddleware(func(_ context.Context, _ *Task) *TaskResult {
+		calls = append(calls, "handler")
+		return TaskOK()
+	}, mw("first"), mw("second"))
+
+	res := th(context.Background(), new(Task))
+	assert.Equal(t, TaskStatus_OK, res.Status)
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+}
+
+func TestRecoverTasks(t *testing.T) {
+	panics := func(_ context.Context, _ *Task) *TaskResult {
+		panic("boom")
+	}
+
+	t.Run("default", func(t *testing.T) {
+		res := RecoverTasks()(panics)(context.Background(), new(Task))
+		assert.Equal(t, TaskStatus_KO, res.Status)
+		assert.Equal(t, "unexpected data error", res.Message)
+	})
+
+	t.Run("with handler", func(t *testing.T) {
+		var rec any
+		ph := func(_ context.Context, _ *Task, r any, stack []byte) *TaskResult {
+			rec = r
+			assert.NotEmpty(t, stack)
+			return TaskQueued("try again", 10)
+		}
+		res := RecoverTasksWithHandler(ph)(panics)(context.Background(), new(Task))
+		assert.Equal(t, "boom", rec)
+		assert.Equal(t, TaskStatus_QUEUED, res.Status)
+	})
+
+	t.Run("with handler without result", func(t *testing.T) {
+		ph := func(_ context.Context, _ *Task, _ any, _ []byte) *TaskResult {
+			return nil
+		}
+		res := RecoverTasksWithHandler(ph)(panics)(context.Background(), new(Task))
+		assert.Equal(t, TaskStatus_KO, res.Status)
+	})
+}
@@ -4,12 +4,14 @@ import "context"
 
 // Subject and Queue names
 const (
-	SubjectTaskFmt     = "gw.%s.task" // for specific task messages
-	SubjectFilesCreate = "gw.files.create"
-	SubjectTasksPoke   = "gw.tasks.poke"
-	SubjectStoreGet    = "gw.store.get"
-	SubjectStoreSet    = "gw.store.set"
-	QueueNameTaskFmt   = "%s.tasks"
+	SubjectTaskFmt       = "gw.%s.task"   // for specific task messages
+	SubjectTaskCancelFmt = "gw.%s.cancel" // for requests to cancel tasks, see WithTaskCancellation
+	SubjectFilesCreate   = "gw.files.create"
+	SubjectTasksPoke     = "gw.tasks.poke"
+	SubjectStoreGet      = "gw.store.get"
+	SubjectStoreSet      = "gw.store.set"
+	SubjectStoreDelete   = "gw.store.delete"
+	QueueNameTaskFmt     = "%s.tasks"
 )
 
 // MIME Content Types supported by the "silo" service.
@@ -54,6 +56,12 @@ func TaskSkip(msg string) *TaskResult {
 	return &TaskResult{Status: TaskStatus_SKIP, Message: msg}
 }
 
+// TaskCancelled provides a cancel response with the provided message, used
+// when the task was stopped at the request of the user.
+func TaskCancelled(msg string) *TaskResult {
+	return &TaskResult{Status: TaskStatus_CANCEL, Message: msg}
+}
+
 // TaskQueued provides a queued task result with the provided message and
 // retryIn value. This is used to indicate that the task processing didn't
 // complete for whatever reason and must be retried after the specified time.
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MuxWildcard may be used as an action name to register a fallback handler
+// that will receive all tasks whose action was not matched by any other route.
+// Actions ending with the wildcard, like "sign.*", will match any action with
+// the same prefix.
+const MuxWildcard = "*"
+
+// Mux routes incoming tasks to a handler according to the task's action.
+// Exact action matches take priority, followed by the longest matching prefix
+// wildcard, and finally the fallback handler. Tasks that do not match any
+// route will be responded to with a KO status.
+//
+// Usage example:
+//
+//	mux := gateway.NewMux()
+//	mux.Handle("sign", signHandler)
+//	mux.Handle("cancel", cancelHandler, gateway.WithRouteTimeout(5*time.Minute))
+//	gw := gateway.New(
+//		gateway.WithConfig(conf),
+//		gateway.WithMux(mux),
+//	)
+type Mux struct {
+	mu       sync.RWMutex
+	routes   map[string]*muxRoute
+	prefixes []string // sorted by length, longest first
+}
+
+type muxRoute struct {
+	th      TaskHandler
+	timeout time.Duration
+}
+
+// RouteOption is used to configure a route when registering a handler.
+type RouteOption func(r *muxRoute)
+
+// WithRouteTimeout overrides the gateway's task timeout for the route.
+// Longer timeouts will only be respected if the mux is configured using
+// the WithMux option.
+func WithRouteTimeout(dur time.Duration) RouteOption {
+	return func(r *muxRoute) {
+		r.timeout = dur
+	}
+}
+
+// NewMux instantiates a new empty task router.
+func NewMux() *Mux {
+	return &Mux{
+		routes: make(map[string]*muxRoute),
+	}
+}
+
+// Handle registers the handler for the given action. A handler already
+// registered for the same action will be replaced.
+func (m *Mux) Handle(action string, th TaskHandler, opts ...RouteOption) {
+	r := &muxRoute{th: th}
+	for _, opt := range opts {
+		opt(r)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.routes[action]; !ok && isMuxPrefix(action) {
+		m.prefixes = append(m.prefixes, action)
+		slices.SortStableFunc(m.prefixes, func(a, b string) int {
+			return len(b) - len(a)
+		})
+	}
+	m.routes[action] = r
+}
+
+// HandleFallback registers the handler to use when no other route matches
+// the task's action. This is equivalent to using the MuxWildcard action.
+func (m *Mux) HandleFallback(th TaskHandler, opts ...RouteOption) {
+	m.Handle(MuxWildcard, th, opts...)
+}
+
+// HandleTask implements the TaskHandler signature so that the mux may be
+// used directly with the WithTaskHandler option.
+func (m *Mux) HandleTask(ctx context.Context, t *Task) *TaskResult {
+	r := m.match(t.Action)
+	if r == nil {
+		return TaskKO(fmt.Errorf("unsupported action: %q", t.Action))
+	}
+	if r.timeout > 0 {
+		// will only ever shorten the context's existing deadline
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	return r.th(ctx, t)
+}
+
+// timeout provides the route's timeout for the task, or zero.
+func (m *Mux) timeout(t *Task) time.Duration {
+	if r := m.match(t.Action); r != nil {
+		return r.timeout
+	}
+	return 0
+}
+
+func (m *Mux) match(action string) *muxRoute {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if r, ok := m.routes[action]; ok {
+		return r
+	}
+	for _, p := range m.prefixes {
+		if strings.HasPrefix(action, strings.TrimSuffix(p, MuxWildcard)) {
+			return m.routes[p]
+		}
+	}
+	return nil
+}
+
+// isMuxPrefix returns true for any action ending with the wildcard, including
+// the fallback itself, which will always be sorted last.
+func isMuxPrefix(action string) bool {
+	return strings.HasSuffix(action, MuxWildcard)
+}
@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMux(t *testing.T) {
+	handler := func(msg string) TaskHandler {
+		return func(_ context.Context, _ *Task) *TaskResult {
+			return TaskSkip(msg)
+		}
+	}
+	ctx := context.Background()
+
+	t.Run("exact and prefix matches", func(t *testing.T) {
+		m := NewMux()
+		m.Handle("sign", handler("sign"))
+		m.Handle("sign.*", handler("sign prefix"))
+		m.Handle("sign.test.*", handler("sign test prefix"))
+
+		assert.Equal(t, "sign", m.HandleTask(ctx, &Task{Action: "sign"}).Message)
+		assert.Equal(t, "sign prefix", m.HandleTask(ctx, &Task{Action: "sign.foo"}).Message)
+		assert.Equal(t, "sign test prefix", m.HandleTask(ctx, &Task{Action: "sign.test.foo"}).Message)
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		m := NewMux()
+		m.Handle("sign", handler("sign"))
+		res := m.HandleTask(ctx, &Task{Action: "cancel"})
+		assert.Equal(t, TaskStatus_KO, res.Status)
+		assert.Equal(t, `unsupported action: "cancel"`, res.Message)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		m := NewMux()
+		m.Handle("sign.*", handler("sign prefix"))
+		m.HandleFallback(handler("fallback"))
+		assert.Equal(t, "fallback", m.HandleTask(ctx, &Task{Action: "cancel"}).Message)
+		assert.Equal(t, "fallback", m.HandleTask(ctx, &Task{}).Message)
+		assert.Equal(t, "sign prefix", m.HandleTask(ctx, &Task{Action: "sign.x"}).Message)
+	})
+
+	t.Run("route timeouts", func(t *testing.T) {
+		m := NewMux()
+		m.Handle("slow", func(ctx context.Context, _ *Task) *TaskResult {
+			dl, ok := ctx.Deadline()
+			assert.True(t, ok)
+			assert.WithinDuration(t, time.Now().Add(time.Second), dl, 100*time.Millisecond)
+			return nil
+		}, WithRouteTimeout(time.Second))
+		m.Handle("fast", handler("fast"))
+
+		gw := New(WithMux(m), WithTaskTimeout(time.Minute))
+		assert.Equal(t, time.Second, gw.taskTimeout(&Task{Action: "slow"}))
+		assert.Equal(t, time.Minute, gw.taskTimeout(&Task{Action: "fast"}))
+		assert.Nil(t, m.HandleTask(ctx, &Task{Action: "slow"}))
+	})
+}
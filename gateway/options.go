@@ -1,6 +1,9 @@
 package gateway
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
 	"time"
 
 	nats "github.com/nats-io/nats.go"
@@ -14,14 +17,21 @@ func WithName(name string) Option {
 }
 
 // WithConfig allows the gateway connection to be configured with a specific
-// configuration object.
+// configuration object. The NATS connection will be established using the
+// configuration by New, or when calling Connect or Start after NewWithError.
 func WithConfig(conf Configuration) Option {
 	return func(gw *Client) {
 		gconf := conf.config()
 
 		gw.name = gconf.Name
 		gw.workerCount = gconf.WorkerCount
-		gw.nc = prepareNATSClient(gconf.NATS, gconf.Name)
+		if gconf.NATS == nil {
+			gw.err = errors.New("missing nats config")
+		} else if _, err := gconf.NATS.Options(); err != nil {
+			gw.err = fmt.Errorf("preparing nats options: %w", err)
+		} else {
+			gw.natsConf = gconf.NATS
+		}
 
 		if gconf.Silo != nil {
 			gw.siloPublicBaseURL = gconf.Silo.PublicBaseURL
@@ -45,6 +55,35 @@ func WithTaskHandler(th TaskHandler) Option {
 	}
 }
 
+// WithTaskMiddleware adds middleware that will wrap around the task handler
+// in the order provided, so that the first middleware will be the first to
+// receive the task. Panics in middleware will be recovered from in the same
+// way as in the task handler.
+func WithTaskMiddleware(mw ...TaskMiddleware) Option {
+	return func(gw *Client) {
+		gw.mw = append(gw.mw, mw...)
+	}
+}
+
+// WithPanicHandler replaces the default panic recovery behaviour so that
+// panics may be reported elsewhere, such as to an error tracker, or a custom
+// response provided.
+func WithPanicHandler(ph PanicHandler) Option {
+	return func(gw *Client) {
+		gw.panicHandler = ph
+	}
+}
+
+// WithMux configures the gateway to route incoming tasks using the provided
+// mux, and to respect any timeouts defined for each route instead of the
+// default task timeout.
+func WithMux(m *Mux) Option {
+	return func(gw *Client) {
+		gw.th = m.HandleTask
+		gw.mux = m
+	}
+}
+
 // WithNATS configures the gateway  to use the provided
 // NATS connection.
 func WithNATS(nc *nats.Conn) Option {
@@ -53,7 +92,8 @@ func WithNATS(nc *nats.Conn) Option {
 	}
 }
 
-// WithWorkerCount sets the number of workers to use for processing.
+// WithWorkerCount sets the number of workers to use for processing, which
+// may be changed later using SetWorkerCount or WithAdaptiveWorkers.
 func WithWorkerCount(count int) Option {
 	return func(gw *Client) {
 		gw.workerCount = count
@@ -68,3 +108,12 @@ func WithSiloPublicBaseURL(url string) Option {
 		gw.siloPublicBaseURL = url
 	}
 }
+
+// WithHTTPClient sets the HTTP client used to upload and fetch files from
+// the silo, useful for configuring timeouts, transports, and connection
+// pooling. The default is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(gw *Client) {
+		gw.httpClient = hc
+	}
+}
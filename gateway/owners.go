@@ -0,0 +1,189 @@
+package gateway
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOwnerMinRetryIn = 1  // seconds
+	defaultOwnerMaxRetryIn = 60 // seconds
+	// ownerAvgWeight is the weight given to each new task duration when
+	// calculating an owner's moving average.
+	ownerAvgWeight = 0.2
+	// defaultOwnerStatsTTL is how long to remember the average duration of
+	// tasks for owners that are idle.
+	defaultOwnerStatsTTL = 10 * time.Minute
+)
+
+// OwnerLimits defines how many tasks from the same owner, identified by the
+// task's OwnerId, may be processed at the same time, so that a single owner
+// sending large numbers of tasks cannot monopolise the workers. Tasks that
+// exceed the limit are answered immediately with a queued result so that the
+// gateway will retry them later, leaving the workers free for other owners.
+// Tasks without an OwnerId are never limited.
+type OwnerLimits struct {
+	// MaxConcurrent is the maximum number of tasks from a single owner that
+	// may be processed at the same time. Zero implies no fixed limit.
+	MaxConcurrent int
+
+	// FairShare, when true, divides the workers evenly between the owners
+	// with tasks in progress, so that each owner may use at least one
+	// worker. When combined with MaxConcurrent, the lowest limit applies.
+	FairShare bool
+
+	// MinRetryIn and MaxRetryIn define the range in seconds of the RetryIn
+	// values provided with queued results, which are based on the average
+	// time taken to process the owner's tasks. Defaults to 1 and 60.
+	MinRetryIn int32
+	MaxRetryIn int32
+
+	// StatsTTL is how long the average time taken to process an owner's
+	// tasks is kept once they have no tasks in progress, so that the RetryIn
+	// estimates improve across bursts of tasks. Defaults to 10 minutes.
+	StatsTTL time.Duration
+}
+
+// WithOwnerLimits enables per-owner concurrency limits for the tasks
+// processed by the client.
+func WithOwnerLimits(ol *OwnerLimits) Option {
+	return func(gw *Client) {
+		gw.ownerLimits = ol
+	}
+}
+
+type ownerLimiter struct {
+	OwnerLimits
+	workers func() int
+	now     func() time.Time
+	mu      sync.Mutex
+	owners  map[string]*ownerState
+	pruned  time.Time
+}
+
+type ownerState struct {
+	active int
+	avg    time.Duration
+	seen   time.Time // when the last task was completed
+}
+
+// newOwnerLimiter prepares the limiter, using the function to determine the
+// current number of workers to share between owners.
+func newOwnerLimiter(ol *OwnerLimits, workers func() int) *ownerLimiter {
+	l := &ownerLimiter{
+		OwnerLimits: *ol,
+		workers:     workers,
+		now:         time.Now,
+		owners:      make(map[string]*ownerState),
+	}
+	if l.StatsTTL <= 0 {
+		l.StatsTTL = defaultOwnerStatsTTL
+	}
+	if l.MinRetryIn <= 0 {
+		l.MinRetryIn = defaultOwnerMinRetryIn
+	}
+	if l.MaxRetryIn <= 0 {
+		l.MaxRetryIn = defaultOwnerMaxRetryIn
+	}
+	if l.MaxRetryIn < l.MinRetryIn {
+		l.MaxRetryIn = l.MinRetryIn
+	}
+	return l
+}
+
+// middleware provides the task middleware that applies the limits.
+func (l *ownerLimiter) middleware(next TaskHandler) TaskHandler {
+	return func(ctx context.Context, t *Task) *TaskResult {
+		if t.OwnerId == "" {
+			return next(ctx, t)
+		}
+		if retryIn, ok := l.acquire(t.OwnerId); !ok {
+			return TaskQueued("owner concurrency limit reached", retryIn)
+		}
+		tn := l.now()
+		defer func() {
+			l.release(t.OwnerId, l.now().Sub(tn))
+		}()
+		return next(ctx, t)
+	}
+}
+
+// acquire reserves a slot for the owner, or provides the number of seconds
+// to wait before trying again.
+func (l *ownerLimiter) acquire(owner string) (int32, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune()
+	st := l.owners[owner]
+	if st == nil {
+		st = new(ownerState)
+		l.owners[owner] = st
+	}
+	if n := l.limit(st); n > 0 && st.active >= n {
+		return l.retryIn(st), false
+	}
+	st.active++
+	return 0, true
+}
+
+func (l *ownerLimiter) release(owner string, dur time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := l.owners[owner]
+	st.active--
+	st.seen = l.now()
+	if st.avg == 0 {
+		st.avg = dur
+	} else {
+		st.avg += time.Duration(ownerAvgWeight * float64(dur-st.avg))
+	}
+}
+
+// prune removes the owners that have been idle for longer than the TTL,
+// checking at most once per TTL.
+func (l *ownerLimiter) prune() {
+	tn := l.now()
+	if tn.Sub(l.pruned) < l.StatsTTL {
+		return
+	}
+	l.pruned = tn
+	for owner, st := range l.owners {
+		if st.active <= 0 && tn.Sub(st.seen) > l.StatsTTL {
+			delete(l.owners, owner)
+		}
+	}
+}
+
+// limit determines the maximum number of tasks the owner may currently
+// process, or zero if there is no limit.
+func (l *ownerLimiter) limit(st *ownerState) int {
+	n := l.MaxConcurrent
+	if l.FairShare {
+		owners := 0
+		for _, o := range l.owners {
+			if o.active > 0 {
+				owners++
+			}
+		}
+		if st.active == 0 {
+			owners++ // this owner is about to become active
+		}
+		share := l.workers() / owners
+		if share < 1 {
+			share = 1
+		}
+		if n == 0 || share < n {
+			n = share
+		}
+	}
+	return n
+}
+
+// retryIn estimates how long it will take for one of the owner's tasks to
+// complete.
+func (l *ownerLimiter) retryIn(st *ownerState) int32 {
+	secs := int32(math.Ceil(st.avg.Seconds()))
+	return min(max(secs, l.MinRetryIn), l.MaxRetryIn)
+}
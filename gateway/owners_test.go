@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler provides a handler that will wait until released, and a
+// function to wait for the expected number of tasks to start.
+func blockingHandler(t *testing.T) (TaskHandler, func(n int), func()) {
+	t.Helper()
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	th := func(_ context.Context, _ *Task) *TaskResult {
+		started <- struct{}{}
+		<-release
+		return TaskOK()
+	}
+	wait := func(n int) {
+		for range n {
+			select {
+			case <-started:
+			case <-time.After(time.Second):
+				t.Fatal("task not started")
+			}
+		}
+	}
+	var once sync.Once
+	done := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(done)
+	return th, wait, done
+}
+
+func TestOwnerLimits(t *testing.T) {
+	t.Run("max concurrent", func(t *testing.T) {
+		th, wait, release := blockingHandler(t)
+		l := newOwnerLimiter(&OwnerLimits{MaxConcurrent: 2}, workerCount(8))
+		h := l.middleware(th)
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h(ctx, &Task{OwnerId: "a"})
+			}()
+		}
+		wait(2)
+
+		res := h(ctx, &Task{OwnerId: "a"})
+		assert.Equal(t, TaskStatus_QUEUED, res.Status)
+		assert.EqualValues(t, 1, res.RetryIn)
+
+		// other owners and tasks without owners are not affected
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, TaskStatus_OK, h(ctx, &Task{OwnerId: "b"}).Status)
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, TaskStatus_OK, h(ctx, &Task{}).Status)
+		}()
+		wait(2)
+
+		release()
+		wg.Wait()
+		assert.Zero(t, l.owners["a"].active)
+		assert.Equal(t, TaskStatus_OK, h(ctx, &Task{OwnerId: "a"}).Status)
+	})
+
+	t.Run("fair share", func(t *testing.T) {
+		th, wait, release := blockingHandler(t)
+		l := newOwnerLimiter(&OwnerLimits{FairShare: true}, workerCount(4))
+		h := l.middleware(th)
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		run := func(owner string) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h(ctx, &Task{OwnerId: owner})
+			}()
+		}
+		// a single owner may use all the workers
+		for range 3 {
+			run("a")
+		}
+		wait(3)
+
+		// once another owner is active, a's share is reduced
+		run("b")
+		wait(1)
+		res := h(ctx, &Task{OwnerId: "a"})
+		assert.Equal(t, TaskStatus_QUEUED, res.Status)
+		run("b")
+		wait(1)
+		res = h(ctx, &Task{OwnerId: "b"})
+		assert.Equal(t, TaskStatus_QUEUED, res.Status)
+
+		release()
+		wg.Wait()
+	})
+
+	t.Run("retry in", func(t *testing.T) {
+		l := newOwnerLimiter(&OwnerLimits{MinRetryIn: 2, MaxRetryIn: 30}, workerCount(8))
+		assert.EqualValues(t, 2, l.retryIn(&ownerState{}))
+		assert.EqualValues(t, 5, l.retryIn(&ownerState{avg: 4100 * time.Millisecond}))
+		assert.EqualValues(t, 30, l.retryIn(&ownerState{avg: time.Hour}))
+
+		l = newOwnerLimiter(&OwnerLimits{MaxConcurrent: 2}, workerCount(8))
+		require.True(t, acquired(l.acquire("a")))
+		require.True(t, acquired(l.acquire("a")))
+		l.release("a", 10*time.Second)
+		require.True(t, acquired(l.acquire("a")))
+		retryIn, ok := l.acquire("a")
+		assert.False(t, ok)
+		assert.EqualValues(t, 10, retryIn)
+	})
+
+	t.Run("stats kept while idle", func(t *testing.T) {
+		tn := time.Now()
+		l := newOwnerLimiter(&OwnerLimits{MaxConcurrent: 1, StatsTTL: time.Minute}, workerCount(8))
+		l.now = func() time.Time { return tn }
+		require.True(t, acquired(l.acquire("a")))
+		l.release("a", 10*time.Second)
+
+		// the next burst uses the average from the previous one
+		tn = tn.Add(30 * time.Second)
+		require.True(t, acquired(l.acquire("a")))
+		retryIn, ok := l.acquire("a")
+		assert.False(t, ok)
+		assert.EqualValues(t, 10, retryIn)
+		l.release("a", 10*time.Second)
+
+		tn = tn.Add(2 * time.Minute)
+		require.True(t, acquired(l.acquire("b")))
+		assert.NotContains(t, l.owners, "a", "idle owner removed after ttl")
+		assert.Contains(t, l.owners, "b")
+	})
+
+	t.Run("task durations", func(t *testing.T) {
+		tn := time.Now()
+		l := newOwnerLimiter(&OwnerLimits{MaxConcurrent: 1}, workerCount(8))
+		l.now = func() time.Time { return tn }
+		h := l.middleware(func(_ context.Context, _ *Task) *TaskResult {
+			tn = tn.Add(12 * time.Second)
+			return nil
+		})
+		h(context.Background(), &Task{OwnerId: "a"})
+		assert.Equal(t, 12*time.Second, l.owners["a"].avg)
+	})
+}
+
+func workerCount(n int) func() int {
+	return func() int { return n }
+}
+
+func acquired(_ int32, ok bool) bool {
+	return ok
+}
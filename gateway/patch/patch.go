@@ -0,0 +1,317 @@
+// Package patch prepares minimal RFC 7396 merge patches or RFC 6902 JSON
+// Patches from the original and modified versions of a task's envelope, so
+// that providers only send back their changes and never clobber concurrent
+// updates to the envelope.
+//
+// Usage example:
+//
+//	inv.Notes = append(inv.Notes, note)
+//	return patch.TaskOK(task, inv)
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/invopop/client.go/gateway"
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/schema"
+)
+
+// envelopeDocKey is the property containing the document in GOBL envelopes.
+const envelopeDocKey = "doc"
+
+// ErrMergeNull is returned when a merge patch cannot be created as the
+// modified document contains null values which would be interpreted as
+// removals.
+var ErrMergeNull = errors.New("merge patch cannot contain null values")
+
+// jsonPatchOp represents a single RFC 6902 JSON Patch operation.
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// Merge compares the original and modified JSON documents and provides the
+// minimal RFC 7396 merge patch required to transform the original into the
+// modified version.
+func Merge(original, modified []byte) ([]byte, error) {
+	o, m, err := decodePatchDocs(original, modified)
+	if err != nil {
+		return nil, err
+	}
+	p, err := mergeDiff(o, m)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		p = map[string]any{}
+	}
+	return json.Marshal(p)
+}
+
+// JSON compares the original and modified JSON documents and provides an
+// RFC 6902 JSON Patch containing the operations required to transform the
+// original into the modified version.
+func JSON(original, modified []byte) ([]byte, error) {
+	o, m, err := decodePatchDocs(original, modified)
+	if err != nil {
+		return nil, err
+	}
+	ops := jsonDiff(nil, "", o, m)
+	if ops == nil {
+		ops = []*jsonPatchOp{}
+	}
+	return json.Marshal(ops)
+}
+
+// TaskOK compares the task's original envelope with the modified envelope
+// or document and provides an OK result containing only the changes, so
+// that any concurrent updates to the envelope are respected. A merge patch
+// will be used unless the document contains null values, in which case a
+// JSON Patch will be used instead. If there are no changes, a plain OK
+// result is provided.
+func TaskOK(t *gateway.Task, modified any) *gateway.TaskResult {
+	ct := gateway.MIMEApplicationMergePatchJSON
+	data, err := Envelope(t, modified, ct)
+	if errors.Is(err, ErrMergeNull) {
+		ct = gateway.MIMEApplicationJSONPatch
+		data, err = Envelope(t, modified, ct)
+	}
+	if err != nil {
+		return gateway.TaskError(fmt.Errorf("preparing patch: %w", err))
+	}
+	switch string(data) {
+	case "{}", "[]":
+		return gateway.TaskOK()
+	}
+	return &gateway.TaskResult{
+		Status:      gateway.TaskStatus_OK,
+		Data:        data,
+		ContentType: ct,
+	}
+}
+
+// Envelope compares the task's original envelope with the modified version
+// and provides a patch using the content type, which must be one of
+// gateway.MIMEApplicationMergePatchJSON or gateway.MIMEApplicationJSONPatch.
+// The modified version may be a complete *gobl.Envelope, raw JSON, or a GOBL
+// document such as *bill.Invoice, which will replace the original envelope's
+// document.
+func Envelope(t *gateway.Task, modified any, contentType string) ([]byte, error) {
+	data, err := modifiedEnvelope(t, modified)
+	if err != nil {
+		return nil, err
+	}
+	switch contentType {
+	case gateway.MIMEApplicationMergePatchJSON:
+		return Merge(t.Envelope, data)
+	case gateway.MIMEApplicationJSONPatch:
+		return JSON(t.Envelope, data)
+	}
+	return nil, fmt.Errorf("unsupported patch content type: %s", contentType)
+}
+
+func modifiedEnvelope(t *gateway.Task, modified any) ([]byte, error) {
+	switch v := modified.(type) {
+	case []byte:
+		return v, nil
+	case json.RawMessage:
+		return v, nil
+	case *gobl.Envelope:
+		return json.Marshal(v)
+	}
+	// assume we have a document to insert in the original envelope
+	env := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(t.Envelope, &env); err != nil {
+		return nil, fmt.Errorf("parsing envelope: %w", err)
+	}
+	obj, err := schema.NewObject(modified)
+	if err != nil {
+		return nil, fmt.Errorf("preparing document: %w", err)
+	}
+	doc, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling document: %w", err)
+	}
+	env[envelopeDocKey] = doc
+	return json.Marshal(env)
+}
+
+func decodePatchDocs(original, modified []byte) (any, any, error) {
+	o, err := decodePatchDoc(original)
+	if err != nil {
+		return nil, nil, fmt.Errorf("original: %w", err)
+	}
+	m, err := decodePatchDoc(modified)
+	if err != nil {
+		return nil, nil, fmt.Errorf("modified: %w", err)
+	}
+	return o, m, nil
+}
+
+// decodePatchDoc parses the JSON data using numbers so that values are
+// not modified by floating point conversion.
+func decodePatchDoc(data []byte) (any, error) {
+	var v any
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// mergeDiff provides the merge patch value required to go from o to m, or
+// nil if they are the same.
+func mergeDiff(o, m any) (any, error) {
+	mm, ok := m.(map[string]any)
+	if !ok {
+		if reflect.DeepEqual(o, m) {
+			return nil, nil
+		}
+		if m == nil || containsNull(m) {
+			return nil, ErrMergeNull
+		}
+		return m, nil
+	}
+	om, ok := o.(map[string]any)
+	if !ok {
+		if containsNull(m) {
+			return nil, ErrMergeNull
+		}
+		return m, nil
+	}
+	p := make(map[string]any)
+	for k := range om {
+		if _, ok := mm[k]; !ok {
+			p[k] = nil
+		}
+	}
+	for k, mv := range mm {
+		ov, ok := om[k]
+		if !ok {
+			if containsNull(mv) {
+				return nil, ErrMergeNull
+			}
+			p[k] = mv
+			continue
+		}
+		d, err := mergeDiff(ov, mv)
+		if err != nil {
+			return nil, err
+		}
+		if d != nil {
+			p[k] = d
+		}
+	}
+	if len(p) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+func containsNull(v any) bool {
+	switch tv := v.(type) {
+	case nil:
+		return true
+	case map[string]any:
+		for _, x := range tv {
+			if containsNull(x) {
+				return true
+			}
+		}
+	case []any:
+		return slices.ContainsFunc(tv, containsNull)
+	}
+	return false
+}
+
+// jsonDiff appends the operations required to go from o to m at the path.
+func jsonDiff(ops []*jsonPatchOp, path string, o, m any) []*jsonPatchOp {
+	switch mv := m.(type) {
+	case map[string]any:
+		if ov, ok := o.(map[string]any); ok {
+			return jsonDiffObject(ops, path, ov, mv)
+		}
+	case []any:
+		if ov, ok := o.([]any); ok {
+			return jsonDiffArray(ops, path, ov, mv)
+		}
+	}
+	if reflect.DeepEqual(o, m) {
+		return ops
+	}
+	return append(ops, &jsonPatchOp{Op: "replace", Path: path, Value: jsonValue(m)})
+}
+
+func jsonDiffObject(ops []*jsonPatchOp, path string, o, m map[string]any) []*jsonPatchOp {
+	keys := make([]string, 0, len(o)+len(m))
+	for k := range o {
+		keys = append(keys, k)
+	}
+	for k := range m {
+		if _, ok := o[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		kp := path + "/" + escapePointer(k)
+		ov, inO := o[k]
+		mv, inM := m[k]
+		switch {
+		case !inM:
+			ops = append(ops, &jsonPatchOp{Op: "remove", Path: kp})
+		case !inO:
+			ops = append(ops, &jsonPatchOp{Op: "add", Path: kp, Value: jsonValue(mv)})
+		default:
+			ops = jsonDiff(ops, kp, ov, mv)
+		}
+	}
+	return ops
+}
+
+// jsonDiffArray compares arrays by skipping any common prefix and suffix
+// and comparing the remaining items by position.
+func jsonDiffArray(ops []*jsonPatchOp, path string, o, m []any) []*jsonPatchOp {
+	start := 0
+	for start < len(o) && start < len(m) && reflect.DeepEqual(o[start], m[start]) {
+		start++
+	}
+	oe, me := len(o), len(m)
+	for oe > start && me > start && reflect.DeepEqual(o[oe-1], m[me-1]) {
+		oe--
+		me--
+	}
+	i := start
+	for ; i < oe && i < me; i++ {
+		ops = jsonDiff(ops, path+"/"+strconv.Itoa(i), o[i], m[i])
+	}
+	for j := oe - 1; j >= i; j-- {
+		ops = append(ops, &jsonPatchOp{Op: "remove", Path: path + "/" + strconv.Itoa(j)})
+	}
+	for ; i < me; i++ {
+		ops = append(ops, &jsonPatchOp{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: jsonValue(m[i])})
+	}
+	return ops
+}
+
+// jsonValue ensures null values are still included in operations.
+func jsonValue(v any) any {
+	if v == nil {
+		return json.RawMessage("null")
+	}
+	return v
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,205 @@
+package patch_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/invopop/client.go/gateway"
+	"github.com/invopop/client.go/gateway/decode"
+	"github.com/invopop/client.go/gateway/patch"
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/note"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		modified string
+		patch    string
+		err      error
+	}{
+		{
+			name:     "no changes",
+			original: `{"a":1,"b":{"c":"d"}}`,
+			modified: `{"b":{"c":"d"},"a":1}`,
+			patch:    `{}`,
+		},
+		{
+			name:     "nested changes",
+			original: `{"a":1,"b":{"c":"d","e":"f"},"g":[1,2]}`,
+			modified: `{"a":1,"b":{"c":"x"},"g":[1,2,3],"h":true}`,
+			patch:    `{"b":{"c":"x","e":null},"g":[1,2,3],"h":true}`,
+		},
+		{
+			name:     "precise numbers",
+			original: `{"a":12345678901234567890}`,
+			modified: `{"a":12345678901234567891}`,
+			patch:    `{"a":12345678901234567891}`,
+		},
+		{
+			name:     "null values",
+			original: `{"a":1}`,
+			modified: `{"a":null}`,
+			err:      patch.ErrMergeNull,
+		},
+		{
+			name:     "nested null values",
+			original: `{"a":1}`,
+			modified: `{"a":1,"b":{"c":null}}`,
+			err:      patch.ErrMergeNull,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := patch.Merge([]byte(tt.original), []byte(tt.modified))
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.patch, string(p))
+
+			out, err := jsonpatch.MergePatch([]byte(tt.original), p)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.modified, string(out))
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := patch.Merge([]byte(`{`), []byte(`{}`))
+		assert.ErrorContains(t, err, "original")
+	})
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		modified string
+		patch    string
+	}{
+		{
+			name:     "no changes",
+			original: `{"a":[1,2]}`,
+			modified: `{"a":[1,2]}`,
+			patch:    `[]`,
+		},
+		{
+			name:     "objects",
+			original: `{"a":1,"b":{"c":"d","e":"f"},"x/y":1}`,
+			modified: `{"a":2,"b":{"c":"d","g":null},"x/y":1}`,
+			patch: `[
+				{"op":"replace","path":"/a","value":2},
+				{"op":"remove","path":"/b/e"},
+				{"op":"add","path":"/b/g","value":null}
+			]`,
+		},
+		{
+			name:     "array removal",
+			original: `{"a":[1,2,3,4]}`,
+			modified: `{"a":[1,4]}`,
+			patch: `[
+				{"op":"remove","path":"/a/2"},
+				{"op":"remove","path":"/a/1"}
+			]`,
+		},
+		{
+			name:     "array insertion",
+			original: `{"a":[1,4]}`,
+			modified: `{"a":[1,2,3,4]}`,
+			patch: `[
+				{"op":"add","path":"/a/1","value":2},
+				{"op":"add","path":"/a/2","value":3}
+			]`,
+		},
+		{
+			name:     "array item changes",
+			original: `{"a":[{"i":1,"v":"x"},{"i":2,"v":"y"}]}`,
+			modified: `{"a":[{"i":1,"v":"z"},{"i":2,"v":"y"},{"i":3}]}`,
+			patch: `[
+				{"op":"replace","path":"/a/0/v","value":"z"},
+				{"op":"add","path":"/a/2","value":{"i":3}}
+			]`,
+		},
+		{
+			name:     "type change",
+			original: `{"a":[1]}`,
+			modified: `{"a":{"b":1}}`,
+			patch:    `[{"op":"replace","path":"/a","value":{"b":1}}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := patch.JSON([]byte(tt.original), []byte(tt.modified))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.patch, string(p))
+
+			jp, err := jsonpatch.DecodePatch(p)
+			require.NoError(t, err)
+			out, err := jp.Apply([]byte(tt.original))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.modified, string(out))
+		})
+	}
+}
+
+func TestTaskOK(t *testing.T) {
+	env, err := gobl.Envelop(&note.Message{Title: "Test", Content: "hello"})
+	require.NoError(t, err)
+	data, err := json.Marshal(env)
+	require.NoError(t, err)
+	task := &gateway.Task{Envelope: data}
+
+	t.Run("document", func(t *testing.T) {
+		msg, _, res := decode.Document[*note.Message](task)
+		require.Nil(t, res)
+		msg.Content = "bye"
+		res = patch.TaskOK(task, msg)
+		assert.Equal(t, gateway.TaskStatus_OK, res.Status)
+		assert.Equal(t, gateway.MIMEApplicationMergePatchJSON, res.ContentType)
+		assert.JSONEq(t, `{"doc":{"content":"bye"}}`, string(res.Data))
+	})
+
+	t.Run("envelope", func(t *testing.T) {
+		env, res := decode.Envelope(task)
+		require.Nil(t, res)
+		env.Document.Instance().(*note.Message).Title = ""
+		res = patch.TaskOK(task, env)
+		assert.Equal(t, gateway.MIMEApplicationMergePatchJSON, res.ContentType)
+		assert.JSONEq(t, `{"doc":{"title":null}}`, string(res.Data))
+	})
+
+	t.Run("null values", func(t *testing.T) {
+		var env map[string]any
+		require.NoError(t, json.Unmarshal(data, &env))
+		env["doc"].(map[string]any)["content"] = nil
+		modified, err := json.Marshal(env)
+		require.NoError(t, err)
+		res := patch.TaskOK(task, modified)
+		assert.Equal(t, gateway.TaskStatus_OK, res.Status)
+		assert.Equal(t, gateway.MIMEApplicationJSONPatch, res.ContentType)
+		assert.JSONEq(t, `[{"op":"replace","path":"/doc/content","value":null}]`, string(res.Data))
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		res := patch.TaskOK(task, data)
+		assert.Equal(t, gateway.TaskStatus_OK, res.Status)
+		assert.Empty(t, res.Data)
+		assert.Empty(t, res.ContentType)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		res := patch.TaskOK(new(gateway.Task), &note.Message{})
+		assert.Equal(t, gateway.TaskStatus_ERR, res.Status)
+		assert.Contains(t, res.Message, "preparing patch")
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := patch.Envelope(task, data, gateway.MIMEApplicationJSON)
+		assert.ErrorContains(t, err, "unsupported patch content type")
+	})
+}
@@ -13,7 +13,7 @@ func (gw *Client) Poke(ctx context.Context, req *TaskPoke) error {
 	if err != nil {
 		return err
 	}
-	out, err := gw.nc.RequestWithContext(ctx, SubjectTasksPoke, in)
+	out, err := gw.request(ctx, SubjectTasksPoke, in)
 	if err != nil {
 		return err
 	}
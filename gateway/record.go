@@ -0,0 +1,180 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// recordFileExt is used for the names of task records.
+	recordFileExt = ".pb"
+	// recordPutTimeout is how long to wait for the store to save a record,
+	// as the task's own context may already be done.
+	recordPutTimeout = 5 * time.Second
+)
+
+// RecordStore is used to keep the task records written by the recorder
+// middleware, such as in a local directory or an object store bucket.
+type RecordStore interface {
+	// Put saves the data using the name, which is unique for each record.
+	Put(ctx context.Context, name string, data []byte) error
+}
+
+// DirRecordStore keeps task records as files in a local directory.
+type DirRecordStore struct {
+	dir string
+}
+
+// NewDirRecordStore instantiates a new record store that writes files to the
+// directory, which will be created if needed.
+func NewDirRecordStore(dir string) *DirRecordStore {
+	return &DirRecordStore{dir: dir}
+}
+
+// Put writes the data to a file in the directory, using a temporary file so
+// that incomplete records are never read.
+func (s *DirRecordStore) Put(_ context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".record-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // nolint:errcheck
+	if _, err := f.Write(data); err != nil {
+		f.Close() // nolint:errcheck
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(s.dir, name))
+}
+
+// RecordOption is used to configure the recorder middleware.
+type RecordOption func(r *recorder)
+
+// WithRecordFilter sets a function that determines which tasks should be
+// recorded according to their result, such as RecordFailures.
+func WithRecordFilter(fn func(t *Task, res *TaskResult) bool) RecordOption {
+	return func(r *recorder) {
+		r.filter = fn
+	}
+}
+
+// WithRecordSanitizer adds a function that will be called with a copy of
+// each task before it is recorded, so that sensitive data such as
+// credentials in the config can be removed. The task's token is always
+// removed.
+func WithRecordSanitizer(fn func(t *Task)) RecordOption {
+	return func(r *recorder) {
+		r.sanitizers = append(r.sanitizers, fn)
+	}
+}
+
+// RecordFailures is a record filter that will only record tasks that did not
+// complete successfully, including panics.
+func RecordFailures(_ *Task, res *TaskResult) bool {
+	return res.Status != TaskStatus_OK
+}
+
+type recorder struct {
+	store      RecordStore
+	filter     func(t *Task, res *TaskResult) bool
+	sanitizers []func(t *Task)
+}
+
+// RecordTasks provides middleware that writes each task alongside its result
+// as a TaskRecord protobuf to the store, so that problems can be reproduced
+// later using Replay. Tasks whose handler panics are recorded with a KO
+// result before the panic continues. Problems writing records are logged
+// and never affect the result.
+//
+// Usage example:
+//
+//	gw := gateway.New(
+//		gateway.WithConfig(conf),
+//		gateway.WithTaskHandler(handler),
+//		gateway.WithTaskMiddleware(gateway.RecordTasks(
+//			gateway.NewDirRecordStore("/var/lib/records"),
+//			gateway.WithRecordFilter(gateway.RecordFailures),
+//		)),
+//	)
+func RecordTasks(store RecordStore, opts ...RecordOption) TaskMiddleware {
+	r := &recorder{store: store}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return func(next TaskHandler) TaskHandler {
+		return func(ctx context.Context, t *Task) *TaskResult {
+			tn := time.Now()
+			// copy before the handler has a chance to modify the task
+			rt := r.sanitize(t)
+			defer func() {
+				if p := recover(); p != nil {
+					r.record(ctx, rt, TaskKO(fmt.Errorf("panic: %v", p)), tn)
+					panic(p)
+				}
+			}()
+			res := next(ctx, t)
+			if res == nil {
+				r.record(ctx, rt, TaskOK(), tn)
+			} else {
+				r.record(ctx, rt, res, tn)
+			}
+			return res
+		}
+	}
+}
+
+func (r *recorder) sanitize(t *Task) *Task {
+	rt := proto.Clone(t).(*Task)
+	rt.Token = ""
+	for _, fn := range r.sanitizers {
+		fn(rt)
+	}
+	return rt
+}
+
+func (r *recorder) record(ctx context.Context, t *Task, res *TaskResult, tn time.Time) {
+	if r.filter != nil && !r.filter(t, res) {
+		return
+	}
+	rec := &TaskRecord{
+		Task:       t,
+		Result:     res,
+		RecordedTs: time.Now().Unix(),
+		DurationMs: time.Since(tn).Milliseconds(),
+	}
+	data, err := proto.Marshal(rec)
+	if err != nil {
+		log.Warn().Str("task_id", t.Id).Err(err).Msg("gateway: marshalling task record")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordPutTimeout)
+	defer cancel()
+	if err := r.store.Put(ctx, recordName(t, tn), data); err != nil {
+		log.Warn().Str("task_id", t.Id).Err(err).Msg("gateway: writing task record")
+	}
+}
+
+// recordName provides a unique name for the record that will sort by the
+// time the task was received.
+func recordName(t *Task, tn time.Time) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, t.Id)
+	return fmt.Sprintf("%d-%s%s", tn.UnixNano(), id, recordFileExt)
+}
@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+type memoryRecordStore struct {
+	mu       sync.Mutex
+	records  map[string]*TaskRecord
+	deadline bool // last record was put with a deadline
+}
+
+func (s *memoryRecordStore) Put(ctx context.Context, name string, data []byte) error {
+	rec, err := ParseTaskRecord(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, s.deadline = ctx.Deadline()
+	if s.records == nil {
+		s.records = make(map[string]*TaskRecord)
+	}
+	s.records[name] = rec
+	return nil
+}
+
+func (s *memoryRecordStore) list() []*TaskRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*TaskRecord
+	for _, rec := range s.records {
+		out = append(out, rec)
+	}
+	return out
+}
+
+func TestRecordTasks(t *testing.T) {
+	ctx := context.Background()
+	th := func(_ context.Context, task *Task) *TaskResult {
+		task.Action = "modified"
+		switch task.Id {
+		case "fail":
+			return TaskKO(errors.New("bad"))
+		case "panic":
+			panic("boom")
+		}
+		return nil
+	}
+
+	t.Run("all tasks", func(t *testing.T) {
+		s := new(memoryRecordStore)
+		h := RecordTasks(s, WithRecordSanitizer(func(t *Task) {
+			t.Config = nil
+		}))(th)
+		task := &Task{Id: "task-1", Action: "sign", Token: "secret", Config: []byte(`{"key":"x"}`)}
+		assert.Nil(t, h(ctx, task))
+		assert.Equal(t, "secret", task.Token, "original untouched")
+
+		recs := s.list()
+		require.Len(t, recs, 1)
+		assert.Equal(t, "task-1", recs[0].Task.Id)
+		assert.Equal(t, "sign", recs[0].Task.Action, "copied before handler")
+		assert.Empty(t, recs[0].Task.Token)
+		assert.Empty(t, recs[0].Task.Config)
+		assert.Equal(t, TaskStatus_OK, recs[0].Result.Status)
+		assert.NotZero(t, recs[0].RecordedTs)
+		assert.True(t, s.deadline)
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		s := new(memoryRecordStore)
+		h := RecordTasks(s, WithRecordFilter(RecordFailures))(th)
+		h(ctx, &Task{Id: "task-1"})
+		res := h(ctx, &Task{Id: "fail"})
+		assert.Equal(t, TaskStatus_KO, res.Status)
+		assert.PanicsWithValue(t, "boom", func() {
+			h(ctx, &Task{Id: "panic"})
+		})
+
+		recs := s.list()
+		require.Len(t, recs, 2)
+		msgs := []string{recs[0].Result.Message, recs[1].Result.Message}
+		assert.ElementsMatch(t, []string{"bad", "panic: boom"}, msgs)
+	})
+}
+
+func TestDirRecordStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "records")
+	h := RecordTasks(NewDirRecordStore(dir))(func(_ context.Context, task *Task) *TaskResult {
+		return &TaskResult{Status: TaskStatus_OK, Ref: task.Id}
+	})
+	ctx := context.Background()
+	h(ctx, &Task{Id: "task/1"})
+	h(ctx, &Task{Id: "task-2"})
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "no temporary files left")
+	assert.Contains(t, entries[0].Name(), "-task_1.pb")
+
+	recs, err := ReadTaskRecords(dir)
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+	assert.Equal(t, "task/1", recs[0].Task.Id)
+	assert.Equal(t, "task-2", recs[1].Result.Ref)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.pb"), []byte("x"), 0o644))
+	_, err = ReadTaskRecords(dir)
+	assert.ErrorContains(t, err, "bad.pb: parsing task record")
+}
+
+func TestReplay(t *testing.T) {
+	recs := []*TaskRecord{
+		{
+			Task:   &Task{Id: "task-1", Action: "sign"},
+			Result: &TaskResult{Status: TaskStatus_OK, Ref: "ref-1", Args: map[string]string{"a": "1"}},
+		},
+		{
+			Task:   &Task{Id: "task-2", Action: "sign"},
+			Result: &TaskResult{Status: TaskStatus_KO, Message: "bad"},
+		},
+		{
+			Task:   &Task{Id: "task-3", Action: "panic"},
+			Result: TaskOK(),
+		},
+	}
+	orig := proto.Clone(recs[0].Task)
+	th := func(_ context.Context, task *Task) *TaskResult {
+		if task.Action == "panic" {
+			panic("boom")
+		}
+		task.Action = "modified"
+		return &TaskResult{Status: TaskStatus_OK, Ref: "ref-new", Args: map[string]string{"a": "1"}}
+	}
+
+	out := Replay(context.Background(), th, recs)
+	require.Len(t, out, 3)
+	assert.True(t, proto.Equal(orig, recs[0].Task), "record untouched")
+
+	require.Len(t, out[0].Diffs, 1)
+	assert.Equal(t, "ref", out[0].Diffs[0].Field)
+	assert.Equal(t, `ref: "ref-1" != "ref-new"`, out[0].Diffs[0].String())
+
+	assert.False(t, out[1].Match())
+	diffs := make(map[string]*ResultDiff)
+	for _, d := range out[1].Diffs {
+		diffs[d.Field] = d
+	}
+	assert.Equal(t, "KO", diffs["status"].Recorded)
+	assert.Equal(t, "OK", diffs["status"].Replayed)
+	assert.Equal(t, "{a=1}", diffs["args"].Replayed)
+	assert.Contains(t, diffs, "message")
+
+	assert.Equal(t, TaskStatus_KO, out[2].Result.Status, "panic recovered")
+
+	out = Replay(context.Background(), th, recs[:1], WithReplayIgnore("ref"))
+	assert.True(t, out[0].Match())
+}
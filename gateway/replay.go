@@ -0,0 +1,200 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ParseTaskRecord unmarshals a record written by the recorder middleware.
+func ParseTaskRecord(data []byte) (*TaskRecord, error) {
+	rec := new(TaskRecord)
+	if err := proto.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("parsing task record: %w", err)
+	}
+	if rec.Task == nil {
+		return nil, fmt.Errorf("parsing task record: missing task")
+	}
+	return rec, nil
+}
+
+// ReadTaskRecords loads all the records written to the directory by a
+// DirRecordStore, in the order they were received.
+func ReadTaskRecords(dir string) ([]*TaskRecord, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+recordFileExt))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(names)
+	recs := make([]*TaskRecord, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		rec, err := ParseTaskRecord(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(name), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// ReplayOption is used to configure how records are replayed.
+type ReplayOption func(r *replayer)
+
+// WithReplayIgnore sets the names of the result fields, as defined in the
+// protobuf such as "ref" or "retry_in", that should not be compared.
+func WithReplayIgnore(fields ...string) ReplayOption {
+	return func(r *replayer) {
+		r.ignore = append(r.ignore, fields...)
+	}
+}
+
+// WithReplayTimeout sets how long each task may take, which defaults to the
+// same timeout as the gateway client.
+func WithReplayTimeout(dur time.Duration) ReplayOption {
+	return func(r *replayer) {
+		r.timeout = dur
+	}
+}
+
+type replayer struct {
+	ignore  []string
+	timeout time.Duration
+}
+
+// ReplayResult contains the outcome of replaying a task record.
+type ReplayResult struct {
+	Record *TaskRecord
+	// Result is the new result provided by the handler.
+	Result *TaskResult
+	// Diffs contains the fields whose values were different to the
+	// recorded result.
+	Diffs []*ResultDiff
+}
+
+// Match returns true if the result was the same as the recorded result.
+func (r *ReplayResult) Match() bool {
+	return len(r.Diffs) == 0
+}
+
+// ResultDiff describes a field with different values in the recorded and
+// replayed results.
+type ResultDiff struct {
+	Field    string
+	Recorded string
+	Replayed string
+}
+
+// String provides a human readable version of the difference.
+func (d *ResultDiff) String() string {
+	return fmt.Sprintf("%s: %q != %q", d.Field, d.Recorded, d.Replayed)
+}
+
+// Replay passes each of the recorded tasks through the handler in order,
+// comparing the new results with those recorded. Panics are recovered from
+// in the same way as the gateway client. This is meant to be used to
+// reproduce problems locally, usually from a test:
+//
+//	recs, err := gateway.ReadTaskRecords("testdata/records")
+//	require.NoError(t, err)
+//	for _, r := range gateway.Replay(ctx, handler, recs) {
+//		assert.True(t, r.Match(), "task %s: %v", r.Record.Task.Id, r.Diffs)
+//	}
+func Replay(ctx context.Context, th TaskHandler, recs []*TaskRecord, opts ...ReplayOption) []*ReplayResult {
+	r := &replayer{timeout: defaultTaskTimeout}
+	for _, opt := range opts {
+		opt(r)
+	}
+	th = RecoverTasks()(th)
+	out := make([]*ReplayResult, len(recs))
+	for i, rec := range recs {
+		out[i] = r.replay(ctx, th, rec)
+	}
+	return out
+}
+
+func (r *replayer) replay(ctx context.Context, th TaskHandler, rec *TaskRecord) *ReplayResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	// the handler may modify the task, which should be kept intact
+	res := th(ctx, proto.Clone(rec.Task).(*Task))
+	if res == nil {
+		res = TaskOK()
+	}
+	orig := rec.Result
+	if orig == nil {
+		orig = new(TaskResult)
+	}
+	return &ReplayResult{
+		Record: rec,
+		Result: res,
+		Diffs:  diffResults(orig, res, r.ignore),
+	}
+}
+
+// diffResults compares each of the fields in the results.
+func diffResults(a, b *TaskResult, ignore []string) []*ResultDiff {
+	var diffs []*ResultDiff
+	ma, mb := a.ProtoReflect(), b.ProtoReflect()
+	fields := ma.Descriptor().Fields()
+	for i := range fields.Len() {
+		fd := fields.Get(i)
+		if slices.Contains(ignore, string(fd.Name())) {
+			continue
+		}
+		if proto.Equal(onlyField(ma, fd), onlyField(mb, fd)) {
+			continue
+		}
+		diffs = append(diffs, &ResultDiff{
+			Field:    string(fd.Name()),
+			Recorded: formatField(ma, fd),
+			Replayed: formatField(mb, fd),
+		})
+	}
+	return diffs
+}
+
+// onlyField provides a copy of the message with only the field set.
+func onlyField(m protoreflect.Message, fd protoreflect.FieldDescriptor) proto.Message {
+	out := m.New()
+	if m.Has(fd) {
+		out.Set(fd, m.Get(fd))
+	}
+	return out.Interface()
+}
+
+func formatField(m protoreflect.Message, fd protoreflect.FieldDescriptor) string {
+	v := m.Get(fd)
+	switch {
+	case fd.IsMap():
+		var pairs []string
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			pairs = append(pairs, fmt.Sprintf("%v=%v", k.Interface(), v.Interface()))
+			return true
+		})
+		slices.Sort(pairs)
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case fd.Kind() == protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return fmt.Sprint(v.Enum())
+	case fd.Kind() == protoreflect.BytesKind:
+		if b := v.Bytes(); !utf8.Valid(b) {
+			return fmt.Sprintf("<%d bytes>", len(b))
+		}
+		return string(v.Bytes())
+	}
+	return fmt.Sprint(v.Interface())
+}
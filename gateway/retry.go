@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/invopop/client.go/internal/retry"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryMinWait     = 200 * time.Millisecond
+	defaultRetryMaxWait     = 5 * time.Second
+)
+
+// RetryPolicy defines how file transfers with the silo should be retried when
+// they fail due to network errors or server errors (429 and 5xx). Uploads are
+// only retried when the data provided implements io.Seeker, so that it can
+// be sent again, which is always the case with CreateAndUploadFile and
+// CreateAndUploadFileFromReader. Fetches are only retried before any data
+// has been received. The same policy type is used to retry the initial NATS
+// connection with WithConnectRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts to make including the
+	// first request. Defaults to 3, use 1 to disable retries.
+	MaxAttempts int
+
+	// MinWait is the initial amount of time to wait between attempts,
+	// which will be doubled after each subsequent failure. Defaults to
+	// 200ms.
+	MinWait time.Duration
+
+	// MaxWait is the maximum amount of time to wait between attempts when
+	// calculating the exponential backoff. Defaults to 5s.
+	MaxWait time.Duration
+}
+
+// WithFileRetryPolicy overrides the default policy used to retry file
+// uploads and fetches.
+func WithFileRetryPolicy(rp *RetryPolicy) Option {
+	return func(gw *Client) {
+		gw.fileRetry = rp.withDefaults()
+	}
+}
+
+func (rp *RetryPolicy) withDefaults() *RetryPolicy {
+	out := new(RetryPolicy)
+	if rp != nil {
+		*out = *rp
+	}
+	if out.MaxAttempts <= 0 {
+		out.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if out.MinWait <= 0 {
+		out.MinWait = defaultRetryMinWait
+	}
+	if out.MaxWait <= 0 {
+		out.MaxWait = defaultRetryMaxWait
+	}
+	if out.MaxWait < out.MinWait {
+		out.MaxWait = out.MinWait
+	}
+	return out
+}
+
+// backoff calculates the time to wait after the provided attempt number,
+// starting at 1, using exponential backoff with jitter.
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	return retry.Backoff(attempt, rp.MinWait, rp.MaxWait)
+}
+
+// statusError is returned when the silo responds with an unexpected status.
+type statusError struct {
+	op     string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return e.op + " error, status: " + e.status
+}
+
+// shouldRetry determines if the error from the last attempt can be
+// considered transient.
+func shouldRetry(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusTooManyRequests || se.code >= 500
+	}
+	return retry.IsTransientError(err)
+}
+
+// retry calls fn until it succeeds, the error is not transient, the
+// attempts are exhausted, or the context is done. The prepare function,
+// if provided, will be called before each retry and may prevent further
+// attempts by returning false.
+func (rp *RetryPolicy) retry(ctx context.Context, fn func() error, prepare func() bool) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= rp.MaxAttempts || !shouldRetry(err) {
+			return err
+		}
+		if !retry.Sleep(ctx, rp.backoff(attempt)) {
+			return err
+		}
+		if prepare != nil && !prepare() {
+			return err
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// StoreGet fetches an entry from the gateway's key-value store which is scoped
+// to the owner and provider. If no provider is set in the request, the
+// gateway client's name will be used without modifying the request. Use
+// IsNotFoundError to check if the entry does not exist.
+func (gw *Client) StoreGet(ctx context.Context, req *StoreGet) (*StoreEntry, error) {
+	if req.Provider == "" {
+		req = proto.Clone(req).(*StoreGet)
+		req.Provider = gw.name
+	}
+	return gw.storeRequest(ctx, SubjectStoreGet, req)
+}
+
+// StoreSet creates or updates an entry in the gateway's key-value store. A TTL
+// in seconds may be provided to automatically expire the entry. When a
+// revision is provided, the entry will only be updated if the current
+// revision matches, or in the case of a zero revision, if the entry does not
+// already exist. Use IsConflictError to check for mismatched revisions.
+func (gw *Client) StoreSet(ctx context.Context, req *StoreSet) (*StoreEntry, error) {
+	if req.Provider == "" {
+		req = proto.Clone(req).(*StoreSet)
+		req.Provider = gw.name
+	}
+	return gw.storeRequest(ctx, SubjectStoreSet, req)
+}
+
+// StoreDelete removes an entry from the gateway's key-value store, optionally
+// only if the revision matches.
+func (gw *Client) StoreDelete(ctx context.Context, req *StoreDelete) error {
+	if req.Provider == "" {
+		req = proto.Clone(req).(*StoreDelete)
+		req.Provider = gw.name
+	}
+	_, err := gw.storeRequest(ctx, SubjectStoreDelete, req)
+	return err
+}
+
+func (gw *Client) storeRequest(ctx context.Context, subj string, req proto.Message) (*StoreEntry, error) {
+	in, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	out, err := gw.request(ctx, subj, in)
+	if err != nil {
+		return nil, err
+	}
+	res := new(StoreResponse)
+	if err := proto.Unmarshal(out.Data, res); err != nil {
+		return nil, err
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return res.Entry, nil
+}
+
+// StoreGetJSON fetches an entry from the store and unmarshals the JSON value
+// into a new instance of T. The entry is also provided so that the revision
+// may be used in subsequent updates.
+func StoreGetJSON[T any](ctx context.Context, gw *Client, req *StoreGet) (*T, *StoreEntry, error) {
+	e, err := gw.StoreGet(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	v := new(T)
+	if err := json.Unmarshal(e.Value, v); err != nil {
+		return nil, nil, fmt.Errorf("parsing store value: %w", err)
+	}
+	return v, e, nil
+}
+
+// StoreSetJSON marshals the value as JSON and sets it in the store using the
+// rest of the request's details, overwriting any value already present. The
+// request itself is not modified.
+func StoreSetJSON[T any](ctx context.Context, gw *Client, req *StoreSet, v T) (*StoreEntry, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("preparing store value: %w", err)
+	}
+	req = proto.Clone(req).(*StoreSet)
+	req.Value = data
+	return gw.StoreSet(ctx, req)
+}
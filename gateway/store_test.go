@@ -0,0 +1,40 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreRequests(t *testing.T) {
+	gw, nc := startGateway(t, func(_ context.Context, _ *Task) *TaskResult {
+		return nil
+	})
+	s := runTestStore(t, nc)
+	ctx := context.Background()
+
+	set := &StoreSet{OwnerId: "owner", Key: "key", Value: []byte(`{}`)}
+	_, err := StoreSetJSON(ctx, gw, set, map[string]string{"foo": "bar"})
+	require.NoError(t, err)
+	assert.Empty(t, set.Provider, "request not modified")
+	assert.Equal(t, `{}`, string(set.Value), "request not modified")
+	e := s.entry("owner", "test", "key")
+	require.NotNil(t, e)
+	assert.JSONEq(t, `{"foo":"bar"}`, string(e.Value))
+
+	get := &StoreGet{OwnerId: "owner", Key: "key"}
+	v, _, err := StoreGetJSON[map[string]string](ctx, gw, get)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", (*v)["foo"])
+	assert.Empty(t, get.Provider, "request not modified")
+
+	del := &StoreDelete{OwnerId: "owner", Key: "key"}
+	require.NoError(t, gw.StoreDelete(ctx, del))
+	assert.Empty(t, del.Provider, "request not modified")
+	assert.Nil(t, s.entry("owner", "test", "key"))
+
+	_, err = gw.StoreGet(ctx, &StoreGet{OwnerId: "owner", Provider: "other", Key: "key"})
+	assert.True(t, IsNotFoundError(err))
+}
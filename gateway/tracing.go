@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+
+	nats "github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/invopop/client.go/gateway"
+
+// WithTracerProvider enables OpenTelemetry instrumentation so that spans will
+// be created for each incoming task, for requests made to the gateway, and
+// for files fetched from the silo. Trace context is always extracted from
+// incoming task message headers and injected into outgoing requests using
+// the propagator, see WithPropagator.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(gw *Client) {
+		gw.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// defaultPropagator handles the W3C trace context and baggage headers.
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// WithPropagator sets the propagator used to extract and inject trace
+// context, which defaults to the W3C trace context and baggage formats.
+// Use otel.GetTextMapPropagator() to use the global propagator instead.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(gw *Client) {
+		gw.propagator = p
+	}
+}
+
+// textMapPropagator provides the configured propagator, or the default.
+func (gw *Client) textMapPropagator() propagation.TextMapPropagator {
+	if gw.propagator != nil {
+		return gw.propagator
+	}
+	return defaultPropagator
+}
+
+// natsHeaderCarrier adapts NATS message headers to the OpenTelemetry
+// TextMapCarrier interface. Keys are looked up both as provided and in
+// canonical form as NATS does not normalize header keys.
+type natsHeaderCarrier nats.Header
+
+func (hc natsHeaderCarrier) Get(key string) string {
+	if v := hc[key]; len(v) > 0 {
+		return v[0]
+	}
+	if v := hc[textproto.CanonicalMIMEHeaderKey(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (hc natsHeaderCarrier) Set(key, value string) {
+	hc[key] = []string{value}
+}
+
+func (hc natsHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(hc))
+	for k := range hc {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startTaskSpan extracts any trace context from the incoming message and
+// starts a consumer span for the task, if tracing is enabled.
+func (gw *Client) startTaskSpan(ctx context.Context, m *nats.Msg, t *Task) (context.Context, trace.Span) {
+	if len(m.Header) > 0 {
+		ctx = gw.textMapPropagator().Extract(ctx, natsHeaderCarrier(m.Header))
+	}
+	if gw.tracer == nil {
+		return ctx, nil
+	}
+	return gw.tracer.Start(ctx, "task "+t.Action,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", m.Subject),
+			attribute.String("gateway.service", gw.name),
+			attribute.String("gateway.task.id", t.Id),
+			attribute.String("gateway.task.action", t.Action),
+			attribute.String("gateway.job.id", t.JobId),
+			attribute.String("gateway.owner.id", t.OwnerId),
+		),
+	)
+}
+
+// endTaskSpan records the task result in the span.
+func endTaskSpan(span trace.Span, res *TaskResult) {
+	if span == nil {
+		return
+	}
+	defer span.End()
+	span.SetAttributes(attribute.String("gateway.task.status", res.Status.String()))
+	if res.Code != "" {
+		span.SetAttributes(attribute.String("gateway.task.code", res.Code))
+	}
+	switch res.Status {
+	case TaskStatus_ERR, TaskStatus_KO:
+		span.SetStatus(codes.Error, res.Message)
+	}
+}
+
+// request sends the data to the gateway using the subject and waits for
+// a response, propagating the trace context in the message headers.
+func (gw *Client) request(ctx context.Context, subj string, data []byte) (*nats.Msg, error) {
+	var span trace.Span
+	if gw.tracer != nil {
+		ctx, span = gw.tracer.Start(ctx, subj,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("messaging.system", "nats"),
+				attribute.String("messaging.destination.name", subj),
+			),
+		)
+		defer span.End()
+	}
+
+	msg := &nats.Msg{Subject: subj, Data: data}
+	hdr := make(nats.Header)
+	gw.textMapPropagator().Inject(ctx, natsHeaderCarrier(hdr))
+	if len(hdr) > 0 {
+		msg.Header = hdr
+	}
+
+	res, err := gw.nc.RequestMsgWithContext(ctx, msg)
+	if err != nil && span != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return res, err
+}
+
+// startFileSpan starts a client span for fetching the file from the silo,
+// if tracing is enabled.
+func (gw *Client) startFileSpan(ctx context.Context, f *File) (context.Context, trace.Span) {
+	if gw.tracer == nil {
+		return ctx, nil
+	}
+	return gw.tracer.Start(ctx, "fetch file",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", http.MethodGet),
+			attribute.String("gateway.file.id", f.Id),
+			attribute.String("gateway.silo_entry.id", f.SiloEntryId),
+		),
+	)
+}
+
+// endFileSpan records the outcome of fetching the file in the span.
+func endFileSpan(span trace.Span, n int64, err error) {
+	if span == nil {
+		return
+	}
+	defer span.End()
+	span.SetAttributes(attribute.Int64("http.response.body.size", n))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
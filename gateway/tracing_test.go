@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTaskSpans(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	prop := propagation.TraceContext{}
+
+	// Prepare a parent span as if sent by the gateway
+	pctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	hdr := make(nats.Header)
+	prop.Inject(pctx, natsHeaderCarrier(hdr))
+	parent.End()
+	require.NotEmpty(t, hdr.Get("traceparent"))
+
+	gw := New(WithName("test"), WithTracerProvider(tp), WithPropagator(prop))
+	m := &nats.Msg{Subject: "gw.test.task", Header: hdr}
+	task := &Task{Id: "task-1", Action: "sign"}
+	_, span := gw.startTaskSpan(context.Background(), m, task)
+	endTaskSpan(span, TaskKO(assert.AnError))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 2)
+	ts := spans[1]
+	assert.Equal(t, "task sign", ts.Name())
+	assert.Equal(t, parent.SpanContext().TraceID(), ts.SpanContext().TraceID())
+	assert.Equal(t, parent.SpanContext().SpanID(), ts.Parent().SpanID())
+	assert.Equal(t, codes.Error, ts.Status().Code)
+}
+
+func TestFileSpans(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	traceparents := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		w.Write([]byte("hello")) // nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	gw := New(
+		WithSiloPublicBaseURL(srv.URL),
+		WithTracerProvider(tp),
+	)
+
+	f := &File{Id: "file-1", SiloEntryId: "entry-1", Name: "test.txt"}
+	data, err := gw.FetchFile(context.Background(), f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	_, err = gw.FetchFile(context.Background(), &File{Id: "file-2"}, WithMaxFileSize(1))
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "fetch file", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), attribute.String("gateway.file.id", "file-1"))
+	assert.Contains(t, spans[0].Attributes(), attribute.Int64("http.response.body.size", 5))
+	assert.Contains(t, <-traceparents, spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestNATSHeaderCarrier(t *testing.T) {
+	hc := natsHeaderCarrier(nats.Header{"Traceparent": []string{"canonical"}})
+	assert.Equal(t, "canonical", hc.Get("traceparent"))
+	hc.Set("tracestate", "foo")
+	assert.Equal(t, "foo", hc.Get("tracestate"))
+	assert.ElementsMatch(t, []string{"Traceparent", "tracestate"}, hc.Keys())
+}
@@ -0,0 +1,243 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+
+	"github.com/gabriel-vasile/mimetype"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// defaultUploadMemoryLimit is the maximum amount of data held in memory
+	// by default when streaming a file before spooling to disk.
+	defaultUploadMemoryLimit = 8 << 20 // 8 MiB
+	// mimeSniffLen is the number of bytes used to detect MIME types, the same
+	// as the mimetype package's default read limit.
+	mimeSniffLen = 3072
+)
+
+// UploadOption is used to configure streaming file uploads.
+type UploadOption func(o *uploadOptions)
+
+// UploadProgressFunc is called as data is sent to the silo with the number
+// of bytes sent so far and the total file size.
+type UploadProgressFunc func(sent, total int64)
+
+type uploadOptions struct {
+	memoryLimit int64
+	tempDir     string
+	progress    UploadProgressFunc
+}
+
+// WithMemoryThreshold sets the maximum number of bytes that will be kept in
+// memory while streaming a file before spooling to a temporary file instead.
+// The default is 8 MiB; use 0 to always spool to disk.
+func WithMemoryThreshold(size int64) UploadOption {
+	return func(o *uploadOptions) {
+		o.memoryLimit = size
+	}
+}
+
+// WithTempDir sets the directory used for temporary files when spooling
+// large uploads. The default is the system's temporary directory.
+func WithTempDir(dir string) UploadOption {
+	return func(o *uploadOptions) {
+		o.tempDir = dir
+	}
+}
+
+// WithUploadProgress sets a function that will be called with progress
+// updates as the file data is uploaded.
+func WithUploadProgress(fn UploadProgressFunc) UploadOption {
+	return func(o *uploadOptions) {
+		o.progress = fn
+	}
+}
+
+// CreateAndUploadFileFromReader is the streaming equivalent of
+// CreateAndUploadFile. The data is read once and spooled to memory, or to a
+// temporary file once the memory threshold is exceeded, while the SHA256
+// and size are calculated and the MIME type is detected from the first
+// bytes. The file is then created and uploaded from the spool, which is
+// removed afterwards.
+func (gw *Client) CreateAndUploadFileFromReader(ctx context.Context, req *CreateFile, data io.Reader, opts ...UploadOption) (*File, error) {
+	o := &uploadOptions{memoryLimit: defaultUploadMemoryLimit}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	s := newUploadSpool(o)
+	defer s.Close() // nolint:errcheck
+	if _, err := io.Copy(s, contextReader{ctx, data}); err != nil {
+		return nil, fmt.Errorf("spooling data: %w", err)
+	}
+	req = proto.Clone(req).(*CreateFile) // leave the caller's request as is
+	if err := s.prepareCreateFile(req); err != nil {
+		return nil, err
+	}
+
+	f, err := gw.CreateFile(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.reader()
+	if err != nil {
+		return nil, fmt.Errorf("reading spool: %w", err)
+	}
+	if o.progress != nil {
+		r = &progressReader{r: r, total: s.size, fn: o.progress}
+	}
+	if err := gw.UploadFile(ctx, f, &sizedReader{r, s.size}); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// uploadSpool keeps the data written to it in memory until the limit is
+// reached, after which everything is moved to a temporary file.
+type uploadSpool struct {
+	limit int64
+	dir   string
+	buf   bytes.Buffer
+	file  *os.File
+	head  []byte
+	hash  hash.Hash
+	size  int64
+}
+
+func newUploadSpool(o *uploadOptions) *uploadSpool {
+	return &uploadSpool{
+		limit: o.memoryLimit,
+		dir:   o.tempDir,
+		hash:  sha256.New(),
+	}
+}
+
+// Write implements io.Writer.
+func (s *uploadSpool) Write(p []byte) (int, error) {
+	if n := mimeSniffLen - len(s.head); n > 0 {
+		s.head = append(s.head, p[:min(n, len(p))]...)
+	}
+	s.hash.Write(p) // nolint:errcheck
+	s.size += int64(len(p))
+	if s.file == nil && int64(s.buf.Len()+len(p)) > s.limit {
+		if err := s.spool(); err != nil {
+			return 0, err
+		}
+	}
+	if s.file != nil {
+		return s.file.Write(p)
+	}
+	return s.buf.Write(p)
+}
+
+func (s *uploadSpool) spool() error {
+	f, err := os.CreateTemp(s.dir, "gateway-upload-*")
+	if err != nil {
+		return err
+	}
+	s.file = f
+	if _, err := f.Write(s.buf.Bytes()); err != nil {
+		return err
+	}
+	s.buf = bytes.Buffer{}
+	return nil
+}
+
+func (s *uploadSpool) prepareCreateFile(req *CreateFile) error {
+	if s.size > math.MaxInt32 {
+		return fmt.Errorf("file too large: %d bytes", s.size)
+	}
+	req.Size = int32(s.size)
+	if req.Mime == "" {
+		req.Mime = mimetype.Detect(s.head).String()
+	}
+	req.Sha256 = hex.EncodeToString(s.hash.Sum(nil))
+	return nil
+}
+
+func (s *uploadSpool) reader() (io.Reader, error) {
+	if s.file == nil {
+		return bytes.NewReader(s.buf.Bytes()), nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return s.file, nil
+}
+
+// Close removes any temporary file used by the spool.
+func (s *uploadSpool) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return errors.Join(s.file.Close(), os.Remove(s.file.Name()))
+}
+
+// contextReader stops reading once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// sizedReader is used to provide the length of streamed uploads.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+// Seek allows uploads to be retried.
+func (sr *sizedReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := sr.Reader.(io.Seeker)
+	if !ok {
+		return 0, errors.New("seek not supported")
+	}
+	return s.Seek(offset, whence)
+}
+
+type progressReader struct {
+	r     io.Reader
+	sent  int64
+	total int64
+	fn    UploadProgressFunc
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.sent += int64(n)
+		pr.fn(pr.sent, pr.total)
+	}
+	return n, err
+}
+
+// Seek allows uploads to be retried, resetting the progress.
+func (pr *progressReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := pr.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("seek not supported")
+	}
+	n, err := s.Seek(offset, whence)
+	if err == nil {
+		pr.sent = n
+	}
+	return n, err
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUploadSpool(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>` + strings.Repeat("<a>test</a>", 1000))
+	sum := sha256.Sum256(data)
+
+	tests := []struct {
+		name  string
+		limit int64
+		spool bool
+	}{
+		{name: "in memory", limit: int64(len(data)), spool: false},
+		{name: "spooled", limit: 100, spool: true},
+		{name: "always spooled", limit: 0, spool: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := newUploadSpool(&uploadOptions{memoryLimit: tt.limit, tempDir: dir})
+			// small copy buffer to ensure multiple writes
+			_, err := io.CopyBuffer(s, io.LimitReader(bytes.NewReader(data), int64(len(data))), make([]byte, 512))
+			require.NoError(t, err)
+
+			req := new(CreateFile)
+			require.NoError(t, s.prepareCreateFile(req))
+			assert.EqualValues(t, len(data), req.Size)
+			assert.Equal(t, hex.EncodeToString(sum[:]), req.Sha256)
+			assert.Equal(t, "text/xml; charset=utf-8", req.Mime)
+
+			r, err := s.reader()
+			require.NoError(t, err)
+			out, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, out)
+
+			files, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			if tt.spool {
+				assert.Len(t, files, 1)
+				assert.Zero(t, s.buf.Cap(), "memory released")
+			} else {
+				assert.Empty(t, files)
+			}
+
+			require.NoError(t, s.Close())
+			files, err = os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, files, "temporary file removed")
+		})
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	var calls [][2]int64
+	pr := &progressReader{
+		r:     strings.NewReader("hello world"),
+		total: 11,
+		fn: func(sent, total int64) {
+			calls = append(calls, [2]int64{sent, total})
+		},
+	}
+	buf := make([]byte, 5)
+	for {
+		if _, err := pr.Read(buf); err == io.EOF {
+			break
+		}
+	}
+	assert.Equal(t, [][2]int64{{5, 11}, {10, 11}, {11, 11}}, calls)
+}
+
+func TestCreateAndUploadFileFromReader(t *testing.T) {
+	uploads := make(chan []byte, 1)
+	silo := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		uploads <- data
+	}))
+	t.Cleanup(silo.Close)
+	gw, nc := startGateway(t, func(_ context.Context, _ *Task) *TaskResult {
+		return nil
+	}, WithSiloPublicBaseURL(silo.URL))
+
+	created := make(chan *CreateFile, 1)
+	sub, err := nc.Subscribe(SubjectFilesCreate, func(m *nats.Msg) {
+		req := new(CreateFile)
+		if !assert.NoError(t, proto.Unmarshal(m.Data, req)) {
+			return
+		}
+		created <- req
+		data, err := proto.Marshal(&FileResponse{File: &File{
+			Id:          "file-1",
+			SiloEntryId: req.SiloEntryId,
+			Name:        req.Name,
+			Mime:        req.Mime,
+			Hash:        req.Sha256,
+		}})
+		if assert.NoError(t, err) {
+			assert.NoError(t, m.Respond(data))
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { sub.Unsubscribe() }) // nolint:errcheck
+
+	data := bytes.Repeat([]byte("%PDF-1.4 test data "), 1000)
+	sum := sha256.Sum256(data)
+	req := &CreateFile{SiloEntryId: "entry-1", Name: "test.pdf"}
+	var sent, total int64
+	f, err := gw.CreateAndUploadFileFromReader(context.Background(), req, bytes.NewReader(data),
+		WithMemoryThreshold(1024),
+		WithTempDir(t.TempDir()),
+		WithUploadProgress(func(s, t int64) {
+			sent, total = s, t
+		}),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "file-1", f.Id)
+	assert.EqualValues(t, len(data), sent)
+	assert.EqualValues(t, len(data), total)
+
+	cf := <-created
+	assert.Equal(t, "application/pdf", cf.Mime)
+	assert.EqualValues(t, len(data), cf.Size)
+	assert.Equal(t, hex.EncodeToString(sum[:]), cf.Sha256)
+	assert.Equal(t, data, <-uploads)
+
+	assert.True(t, proto.Equal(&CreateFile{SiloEntryId: "entry-1", Name: "test.pdf"}, req), "request not modified")
+}
@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/invopop/client.go/internal/retry"
+	nats "github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultWebhookMaxBodySize = 1 << 20 // 1 MiB
+	// webhookPokeTimeout is how long to wait for the gateway to respond to
+	// each poke attempt.
+	webhookPokeTimeout = 5 * time.Second
+)
+
+// ErrWebhookUnauthorized is returned by verifiers when the webhook request
+// cannot be authenticated.
+var ErrWebhookUnauthorized = errors.New("webhook unauthorized")
+
+// WebhookPokeFunc extracts the details of the task to poke from an incoming
+// webhook request, usually by mapping the external system's reference to
+// the task's Ref. The request's body may be read again after verification.
+// A nil poke implies the webhook does not concern any task and should be
+// acknowledged without further action.
+type WebhookPokeFunc func(r *http.Request) (*TaskPoke, error)
+
+// WebhookVerifier checks the authenticity of an incoming webhook request
+// using the complete body, returning an error wrapping
+// ErrWebhookUnauthorized if the request should be rejected.
+type WebhookVerifier func(r *http.Request, body []byte) error
+
+// WebhookOption is used to configure the webhook handler.
+type WebhookOption func(h *webhookHandler)
+
+// WithWebhookVerifier adds verifiers that must all succeed before the poke
+// is sent.
+func WithWebhookVerifier(v ...WebhookVerifier) WebhookOption {
+	return func(h *webhookHandler) {
+		h.verifiers = append(h.verifiers, v...)
+	}
+}
+
+// WithWebhookRetryPolicy overrides the policy used to retry pokes that fail
+// due to transient NATS errors.
+func WithWebhookRetryPolicy(rp *RetryPolicy) WebhookOption {
+	return func(h *webhookHandler) {
+		h.retry = rp.withDefaults()
+	}
+}
+
+// WithWebhookMaxBodySize sets the maximum size of request bodies, which
+// defaults to 1 MiB.
+func WithWebhookMaxBodySize(n int64) WebhookOption {
+	return func(h *webhookHandler) {
+		h.maxBodySize = n
+	}
+}
+
+type webhookHandler struct {
+	gw          *Client
+	fn          WebhookPokeFunc
+	verifiers   []WebhookVerifier
+	retry       *RetryPolicy
+	maxBodySize int64
+}
+
+// WebhookHandler provides an http.Handler that converts incoming webhook
+// requests into pokes, so that async tasks waiting on the external system are
+// resent immediately. Responses use the following status codes:
+//
+//   - 202 once the gateway has accepted the poke,
+//   - 204 if the webhook does not concern any task,
+//   - 400 if the request or poke is invalid,
+//   - 401 if any of the verifiers fail,
+//   - 404 if the gateway could not find the task,
+//   - 413 if the body is too large, and
+//   - 503 if the poke failed after retrying, so the caller should try again.
+func (gw *Client) WebhookHandler(fn WebhookPokeFunc, opts ...WebhookOption) http.Handler {
+	h := &webhookHandler{
+		gw:          gw,
+		fn:          fn,
+		retry:       new(RetryPolicy).withDefaults(),
+		maxBodySize: defaultWebhookMaxBodySize,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "reading body", http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	for _, v := range h.verifiers {
+		if err := v(r, body); err != nil {
+			log.Warn().Err(err).Str("remote_addr", r.RemoteAddr).Msg("gateway: webhook verification failed")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	req, err := h.fn(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := h.poke(r.Context(), req); err != nil {
+		switch {
+		case IsNotFoundError(err):
+			http.Error(w, "task not found", http.StatusNotFound)
+		case IsValidationError(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			log.Error().Err(err).Str("ref", req.Ref).Msg("gateway: poking task from webhook")
+			http.Error(w, "poke failed", http.StatusServiceUnavailable)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// poke sends the request to the gateway, retrying on transient errors.
+func (h *webhookHandler) poke(ctx context.Context, req *TaskPoke) error {
+	for attempt := 1; ; attempt++ {
+		actx, cancel := context.WithTimeout(ctx, webhookPokeTimeout)
+		err := h.gw.Poke(actx, req)
+		cancel()
+		if err == nil || attempt >= h.retry.MaxAttempts || !isTransientNATSError(ctx, err) {
+			return err
+		}
+		wait := h.retry.backoff(attempt)
+		log.Warn().Err(err).Int("attempt", attempt).Dur("wait", wait).Msg("gateway: webhook poke failed, retrying")
+		if !retry.Sleep(ctx, wait) {
+			return err
+		}
+	}
+}
+
+// isTransientNATSError returns true if the request may succeed if tried
+// again, including when the attempt timed out but the parent context is
+// still active.
+func isTransientNATSError(ctx context.Context, err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ctx.Err() == nil
+	}
+	return errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, nats.ErrConnectionReconnecting) ||
+		errors.Is(err, nats.ErrDisconnected)
+}
+
+// WebhookHMAC verifies that the request's body was signed using HMAC-SHA256
+// with the secret, and the hex encoded signature provided in the header,
+// optionally prefixed with "sha256=" as used by several webhook providers.
+func WebhookHMAC(header string, secret []byte) WebhookVerifier {
+	return func(r *http.Request, body []byte) error {
+		sig := strings.TrimPrefix(r.Header.Get(header), "sha256=")
+		if sig == "" {
+			return fmt.Errorf("%w: missing signature", ErrWebhookUnauthorized)
+		}
+		got, err := hex.DecodeString(sig)
+		if err != nil {
+			return fmt.Errorf("%w: invalid signature encoding", ErrWebhookUnauthorized)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body) // nolint:errcheck
+		if !hmac.Equal(got, mac.Sum(nil)) {
+			return fmt.Errorf("%w: signature mismatch", ErrWebhookUnauthorized)
+		}
+		return nil
+	}
+}
+
+// WebhookSharedSecret verifies that the header contains the secret, ignoring
+// any "Bearer " prefix so that it may be used with the Authorization header.
+func WebhookSharedSecret(header, secret string) WebhookVerifier {
+	return func(r *http.Request, _ []byte) error {
+		v := strings.TrimPrefix(r.Header.Get(header), "Bearer ")
+		if v == "" || subtle.ConstantTimeCompare([]byte(v), []byte(secret)) != 1 {
+			return fmt.Errorf("%w: invalid secret", ErrWebhookUnauthorized)
+		}
+		return nil
+	}
+}
+
+// WebhookClientCert verifies that the header, set by a TLS terminating proxy
+// after validating the client's certificate, contains one of the allowed
+// values such as the certificate's subject or fingerprint. The proxy must
+// be configured to remove the header from any incoming requests.
+func WebhookClientCert(header string, allowed ...string) WebhookVerifier {
+	return func(r *http.Request, _ []byte) error {
+		v := r.Header.Get(header)
+		if v == "" {
+			return fmt.Errorf("%w: missing client certificate", ErrWebhookUnauthorized)
+		}
+		if !slices.Contains(allowed, v) {
+			return fmt.Errorf("%w: client certificate not allowed", ErrWebhookUnauthorized)
+		}
+		return nil
+	}
+}
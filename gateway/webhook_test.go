@@ -0,0 +1,199 @@
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestWebhookVerifiers(t *testing.T) {
+	body := []byte(`{"ref":"ext-1"}`)
+	request := func(header, value string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		if value != "" {
+			r.Header.Set(header, value)
+		}
+		return r
+	}
+
+	t.Run("hmac", func(t *testing.T) {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		sig := hex.EncodeToString(mac.Sum(nil))
+		v := WebhookHMAC("X-Signature", []byte("secret"))
+
+		assert.NoError(t, v(request("X-Signature", sig), body))
+		assert.NoError(t, v(request("X-Signature", "sha256="+sig), body))
+		assert.ErrorIs(t, v(request("X-Signature", sig), []byte("{}")), ErrWebhookUnauthorized)
+		assert.ErrorContains(t, v(request("X-Signature", "xyz"), body), "invalid signature encoding")
+		assert.ErrorContains(t, v(request("X-Signature", ""), body), "missing signature")
+	})
+
+	t.Run("shared secret", func(t *testing.T) {
+		v := WebhookSharedSecret("Authorization", "secret")
+		assert.NoError(t, v(request("Authorization", "Bearer secret"), body))
+		assert.NoError(t, v(request("Authorization", "secret"), body))
+		assert.ErrorIs(t, v(request("Authorization", "Bearer other"), body), ErrWebhookUnauthorized)
+		assert.ErrorIs(t, v(request("Authorization", ""), body), ErrWebhookUnauthorized)
+	})
+
+	t.Run("client cert", func(t *testing.T) {
+		v := WebhookClientCert("X-Client-Cert-Subject", "CN=partner", "CN=other")
+		assert.NoError(t, v(request("X-Client-Cert-Subject", "CN=other"), body))
+		assert.ErrorContains(t, v(request("X-Client-Cert-Subject", "CN=unknown"), body), "not allowed")
+		assert.ErrorContains(t, v(request("X-Client-Cert-Subject", ""), body), "missing client certificate")
+	})
+}
+
+func TestWebhookHandler(t *testing.T) {
+	port := freePort(t)
+	startNATSServer(t, port)
+	gw, err := NewWithError(WithConfig(testNATSConfig(port)))
+	require.NoError(t, err)
+	require.NoError(t, gw.Connect(context.Background()))
+	t.Cleanup(gw.NATS().Close)
+
+	extract := func(r *http.Request) (*TaskPoke, error) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if string(data) == "ignore" {
+			return nil, nil
+		}
+		return &TaskPoke{Ref: string(data)}, nil
+	}
+	serve := func(h http.Handler, body string, hdr ...string) int {
+		r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		if len(hdr) == 2 {
+			r.Header.Set(hdr[0], hdr[1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		var pokes atomic.Int32
+		h := gw.WebhookHandler(extract, WithWebhookRetryPolicy(&RetryPolicy{
+			MaxAttempts: 10,
+			MinWait:     20 * time.Millisecond,
+			MaxWait:     50 * time.Millisecond,
+		}))
+		// nobody is listening for pokes until after the first attempt
+		time.AfterFunc(30*time.Millisecond, func() {
+			sub, err := gw.NATS().Subscribe(SubjectTasksPoke, func(m *nats.Msg) {
+				pokes.Add(1)
+				data, _ := proto.Marshal(new(TaskPokeResponse))
+				m.Respond(data) // nolint:errcheck
+			})
+			assert.NoError(t, err)
+			t.Cleanup(func() { sub.Unsubscribe() }) // nolint:errcheck
+			assert.NoError(t, gw.NATS().Flush())
+		})
+		assert.Equal(t, http.StatusAccepted, serve(h, "ext-1"))
+		assert.EqualValues(t, 1, pokes.Load())
+		assert.Equal(t, http.StatusNoContent, serve(h, "ignore"))
+		assert.EqualValues(t, 1, pokes.Load())
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		h := gw.WebhookHandler(extract, WithWebhookRetryPolicy(&RetryPolicy{
+			MaxAttempts: 2,
+			MinWait:     time.Millisecond,
+		}))
+		assert.Equal(t, http.StatusServiceUnavailable, serve(h, "ext-1"))
+	})
+
+	t.Run("verification", func(t *testing.T) {
+		h := gw.WebhookHandler(extract, WithWebhookVerifier(
+			WebhookSharedSecret("Authorization", "secret"),
+			func(_ *http.Request, body []byte) error {
+				if string(body) == "" {
+					return errors.New("empty body")
+				}
+				return nil
+			},
+		))
+		assert.Equal(t, http.StatusUnauthorized, serve(h, "ext-1"))
+		assert.Equal(t, http.StatusUnauthorized, serve(h, "", "Authorization", "Bearer secret"))
+		assert.Equal(t, http.StatusNoContent, serve(h, "ignore", "Authorization", "Bearer secret"))
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		h := gw.WebhookHandler(extract, WithWebhookMaxBodySize(4))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, serve(h, "ext-12345"))
+	})
+
+	t.Run("poke responses", func(t *testing.T) {
+		pokes := make(chan *TaskPoke, 3)
+		sub, err := gw.NATS().Subscribe(SubjectTasksPoke, func(m *nats.Msg) {
+			req := new(TaskPoke)
+			if !assert.NoError(t, proto.Unmarshal(m.Data, req)) {
+				return
+			}
+			pokes <- req
+			res := new(TaskPokeResponse)
+			if req.Ref == "missing" {
+				res.Err = &Error{Code: ErrorCode_NOT_FOUND, Message: "task not found"}
+			}
+			data, _ := proto.Marshal(res)
+			m.Respond(data) // nolint:errcheck
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { sub.Unsubscribe() }) // nolint:errcheck
+		require.NoError(t, gw.NATS().Flush())
+
+		h := gw.WebhookHandler(func(r *http.Request) (*TaskPoke, error) {
+			ref := r.URL.Query().Get("ref")
+			if ref == "" {
+				return nil, errors.New("missing ref")
+			}
+			return &TaskPoke{Ref: ref, Message: "webhook"}, nil
+		})
+		tests := []struct {
+			query  string
+			status int
+		}{
+			{"?ref=ext-1", http.StatusAccepted},
+			{"?ref=missing", http.StatusNotFound},
+			{"", http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhook"+tt.query, nil))
+			assert.Equal(t, tt.status, rec.Code, tt.query)
+		}
+		require.Len(t, pokes, 2)
+		p := <-pokes
+		assert.Equal(t, "ext-1", p.Ref)
+		assert.Equal(t, "webhook", p.Message)
+	})
+}
+
+func TestIsTransientNATSError(t *testing.T) {
+	ctx := context.Background()
+	assert.True(t, isTransientNATSError(ctx, nats.ErrNoResponders))
+	assert.True(t, isTransientNATSError(ctx, nats.ErrTimeout))
+	assert.True(t, isTransientNATSError(ctx, context.DeadlineExceeded))
+	assert.False(t, isTransientNATSError(ctx, &Error{Code: ErrorCode_NOT_FOUND}))
+	assert.False(t, isTransientNATSError(ctx, nats.ErrConnectionClosed))
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.False(t, isTransientNATSError(ctx, context.DeadlineExceeded))
+}
@@ -0,0 +1,271 @@
+package gateway
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAdaptiveInterval          = 10 * time.Second
+	defaultAdaptiveTargetUtilisation = 0.75
+	defaultAdaptiveTargetQueueWait   = time.Second
+	// defaultAdaptiveMaxFactor is multiplied by the worker count to
+	// determine the default maximum number of workers.
+	defaultAdaptiveMaxFactor = 4
+)
+
+// AdaptiveWorkers defines how the number of workers should be adjusted
+// according to the load. At each interval, the number of workers needed to
+// keep utilisation at the target is estimated using the time spent by
+// handlers processing tasks. The number of workers will also grow if tasks
+// had to wait longer than the target for a free worker. Workers are added
+// immediately, but removed gradually to avoid thrashing.
+type AdaptiveWorkers struct {
+	// Min and Max define the range of workers. Min defaults to 1, and Max
+	// to four times the worker count.
+	Min int
+	Max int
+
+	// Interval is how often to adjust the number of workers. Defaults to 10
+	// seconds.
+	Interval time.Duration
+
+	// TargetUtilisation is the desired fraction of busy workers, between 0
+	// and 1. Defaults to 0.75.
+	TargetUtilisation float64
+
+	// TargetQueueWait is the maximum average time tasks should wait for a
+	// free worker. Defaults to 1 second.
+	TargetQueueWait time.Duration
+}
+
+// WithAdaptiveWorkers enables dynamic sizing of the worker pool, starting
+// with the worker count.
+func WithAdaptiveWorkers(aw *AdaptiveWorkers) Option {
+	return func(gw *Client) {
+		gw.adaptive = aw
+	}
+}
+
+func (aw *AdaptiveWorkers) withDefaults(workers int) *AdaptiveWorkers {
+	out := *aw
+	if out.Min <= 0 {
+		out.Min = 1
+	}
+	if out.Max <= 0 {
+		out.Max = workers * defaultAdaptiveMaxFactor
+	}
+	if out.Max < out.Min {
+		out.Max = out.Min
+	}
+	if out.Interval <= 0 {
+		out.Interval = defaultAdaptiveInterval
+	}
+	if out.TargetUtilisation <= 0 || out.TargetUtilisation > 1 {
+		out.TargetUtilisation = defaultAdaptiveTargetUtilisation
+	}
+	if out.TargetQueueWait <= 0 {
+		out.TargetQueueWait = defaultAdaptiveTargetQueueWait
+	}
+	return &out
+}
+
+// desired estimates how many workers are needed according to the stats
+// collected during the last interval.
+func (aw *AdaptiveWorkers) desired(cur int, st *workerSample) int {
+	load := st.busy.Seconds() / aw.Interval.Seconds()
+	n := int(math.Ceil(load / aw.TargetUtilisation))
+	if st.waits > 0 && st.wait/time.Duration(st.waits) > aw.TargetQueueWait {
+		n = max(n, cur+max(1, cur/2))
+	}
+	if n < cur {
+		n = cur - max(1, (cur-n)/2)
+	}
+	return min(max(n, aw.Min), aw.Max)
+}
+
+// SetWorkerCount changes the number of workers used to process tasks, which
+// may be called at any time before stopping without affecting the
+// subscription. When reducing the count, busy workers will stop once their
+// current task is complete. With adaptive workers, the count will continue
+// to be adjusted within the range.
+func (gw *Client) SetWorkerCount(n int) {
+	gw.pool.resize(max(n, 1))
+}
+
+// WorkerCount provides the current number of workers.
+func (gw *Client) WorkerCount() int {
+	return gw.pool.count()
+}
+
+// workerPool manages the goroutines that process tasks, each of which is
+// given its own channel to be told to stop.
+type workerPool struct {
+	mu     sync.Mutex
+	size   int
+	stops  []chan struct{}
+	run    func(stop <-chan struct{}) // nil until started
+	wg     *sync.WaitGroup
+	closed bool
+	done   chan struct{}
+}
+
+func (p *workerPool) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// start begins running the workers using the function, which must return
+// once the stop channel is closed.
+func (p *workerPool) start(wg *sync.WaitGroup, run func(stop <-chan struct{})) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wg = wg
+	p.run = run
+	p.done = make(chan struct{})
+	p.apply()
+}
+
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.size = n
+	if p.run != nil {
+		p.apply()
+	}
+}
+
+// apply starts or stops workers to match the size.
+func (p *workerPool) apply() {
+	for len(p.stops) < p.size {
+		stop := make(chan struct{})
+		p.stops = append(p.stops, stop)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run(stop)
+		}()
+	}
+	for len(p.stops) > p.size {
+		last := len(p.stops) - 1
+		close(p.stops[last])
+		p.stops = p.stops[:last]
+	}
+}
+
+// close prevents further changes so that the workers can be waited for.
+func (p *workerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	if p.done != nil {
+		close(p.done)
+	}
+}
+
+// stopped checks if the worker has been asked to stop without blocking.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// workerStats keeps track of the busy workers, integrating the time spent
+// by handlers, and of the time tasks waited for a free worker.
+type workerStats struct {
+	mu      sync.Mutex
+	active  int
+	changed time.Time
+	sample  workerSample
+	now     func() time.Time // defaults to time.Now
+}
+
+type workerSample struct {
+	busy  time.Duration // total time spent processing tasks
+	wait  time.Duration // total time tasks waited for a worker
+	waits int
+}
+
+func (s *workerStats) taskStarted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(s.currentTime())
+	s.active++
+}
+
+func (s *workerStats) taskCompleted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(s.currentTime())
+	s.active--
+}
+
+func (s *workerStats) taskWaited(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sample.wait += d
+	s.sample.waits++
+}
+
+func (s *workerStats) busy() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// reset provides the sample collected since the last reset.
+func (s *workerStats) reset() *workerSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(s.currentTime())
+	out := s.sample
+	s.sample = workerSample{}
+	return &out
+}
+
+func (s *workerStats) currentTime() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *workerStats) update(tn time.Time) {
+	if !s.changed.IsZero() {
+		s.sample.busy += time.Duration(s.active) * tn.Sub(s.changed)
+	}
+	s.changed = tn
+}
+
+// scaleWorkers adjusts the number of workers at each interval until the
+// pool is closed.
+func (gw *Client) scaleWorkers(aw *AdaptiveWorkers) {
+	ticker := time.NewTicker(aw.Interval)
+	defer ticker.Stop()
+	gw.stats.reset()
+	for {
+		select {
+		case <-gw.pool.done:
+			return
+		case <-ticker.C:
+		}
+		cur := gw.pool.count()
+		if n := aw.desired(cur, gw.stats.reset()); n != cur {
+			log.Debug().Int("from", cur).Int("to", n).Msg("gateway: resizing workers")
+			gw.pool.resize(n)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveWorkersDesired(t *testing.T) {
+	aw := (&AdaptiveWorkers{Min: 2, Max: 20, Interval: 10 * time.Second}).withDefaults(8)
+	tests := []struct {
+		name   string
+		cur    int
+		sample workerSample
+		want   int
+	}{
+		{"idle shrinks gradually", 10, workerSample{}, 5},
+		{"idle minimum", 3, workerSample{}, 2},
+		{"steady", 8, workerSample{busy: 60 * time.Second}, 8},
+		{"busy grows", 8, workerSample{busy: 80 * time.Second}, 11},
+		{"queue wait grows", 8, workerSample{busy: 60 * time.Second, wait: 4 * time.Second, waits: 2}, 12},
+		{"short queue wait", 8, workerSample{busy: 60 * time.Second, wait: time.Second, waits: 2}, 8},
+		{"maximum", 18, workerSample{busy: 1000 * time.Second}, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, aw.desired(tt.cur, &tt.sample))
+		})
+	}
+}
+
+func TestAdaptiveWorkersDefaults(t *testing.T) {
+	aw := new(AdaptiveWorkers).withDefaults(8)
+	assert.Equal(t, 1, aw.Min)
+	assert.Equal(t, 32, aw.Max)
+	assert.Equal(t, defaultAdaptiveInterval, aw.Interval)
+	assert.Equal(t, defaultAdaptiveTargetUtilisation, aw.TargetUtilisation)
+
+	gw := New(WithWorkerCount(50), WithAdaptiveWorkers(&AdaptiveWorkers{Max: 10}))
+	assert.Equal(t, 10, gw.WorkerCount())
+}
+
+func TestWorkerPool(t *testing.T) {
+	var wg sync.WaitGroup
+	running := make(chan struct{}, 10)
+	stopped := make(chan struct{}, 10)
+	p := &workerPool{size: 2}
+	p.start(&wg, func(stop <-chan struct{}) {
+		running <- struct{}{}
+		<-stop
+		stopped <- struct{}{}
+	})
+	wait := func(ch chan struct{}, n int) {
+		for range n {
+			select {
+			case <-ch:
+			case <-time.After(time.Second):
+				t.Fatal("worker not started or stopped")
+			}
+		}
+	}
+	wait(running, 2)
+
+	p.resize(4)
+	wait(running, 2)
+	p.resize(1)
+	wait(stopped, 3)
+	assert.Equal(t, 1, p.count())
+
+	p.close()
+	p.resize(5)
+	assert.Equal(t, 1, p.count(), "no changes once closed")
+	p.mu.Lock()
+	close(p.stops[0])
+	p.mu.Unlock()
+	wg.Wait()
+}
+
+func TestWorkerStats(t *testing.T) {
+	tn := time.Now()
+	s := &workerStats{now: func() time.Time { return tn }}
+	s.taskStarted()
+	s.taskStarted()
+	assert.Equal(t, 2, s.busy())
+	tn = tn.Add(20 * time.Millisecond)
+	s.taskCompleted()
+	s.taskWaited(time.Second)
+	tn = tn.Add(10 * time.Millisecond)
+	sample := s.reset()
+	assert.Equal(t, 50*time.Millisecond, sample.busy)
+	assert.Equal(t, time.Second, sample.wait)
+	assert.Equal(t, 1, sample.waits)
+	assert.Equal(t, 1, s.busy())
+	assert.Zero(t, s.reset().waits)
+}
+
+func TestSetWorkerCount(t *testing.T) {
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	gw, nc := startGateway(t, func(_ context.Context, task *Task) *TaskResult {
+		if task.Action == "block" {
+			started <- struct{}{}
+			<-release
+		}
+		return nil
+	}, WithWorkerCount(1))
+
+	gw.SetWorkerCount(3)
+	assert.Equal(t, 3, gw.WorkerCount())
+	var wg sync.WaitGroup
+	for i := range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := requestTask(nc, &Task{Id: fmt.Sprintf("task-%d", i), Action: "block"})
+			if assert.NoError(t, err) {
+				assert.Equal(t, TaskStatus_OK, res.Status)
+			}
+		}()
+	}
+	for range 3 {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("task not started")
+		}
+	}
+	assert.Equal(t, 3, gw.Health().Busy)
+
+	gw.SetWorkerCount(0)
+	assert.Equal(t, 1, gw.WorkerCount())
+	close(release)
+	wg.Wait()
+	res := sendTask(t, nc, &Task{Id: "task-4"})
+	assert.Equal(t, TaskStatus_OK, res.Status)
+}
+
+func TestAdaptiveWorkers(t *testing.T) {
+	th, wait, release := blockingHandler(t)
+	gw, nc := startGateway(t, th,
+		WithWorkerCount(1),
+		WithAdaptiveWorkers(&AdaptiveWorkers{Max: 4, Interval: 50 * time.Millisecond}),
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := requestTask(nc, &Task{Id: "task-1"})
+		assert.NoError(t, err)
+	}()
+	wait(1)
+	require.Eventually(t, func() bool {
+		return gw.WorkerCount() >= 2
+	}, 2*time.Second, 10*time.Millisecond, "workers added while busy")
+
+	release()
+	<-done
+	require.Eventually(t, func() bool {
+		return gw.WorkerCount() == 1
+	}, 2*time.Second, 10*time.Millisecond, "workers removed while idle")
+}
@@ -0,0 +1,87 @@
+// Package retry provides the backoff calculations and error checks shared by
+// the retry policies of the API and gateway clients.
+package retry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Backoff calculates the time to wait after the provided attempt number,
+// starting at 1, using exponential backoff with jitter between the minimum
+// and maximum waits.
+func Backoff(attempt int, minWait, maxWait time.Duration) time.Duration {
+	d := maxWait
+	if attempt < 32 {
+		if w := minWait << (attempt - 1); w > 0 && w < d {
+			d = w
+		}
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// IsTransientError determines if the error from an HTTP request can be
+// considered transient, such as network problems, as opposed to cancelled
+// contexts, certificate problems, or issues preparing the request.
+func IsTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var ue *url.Error
+	if !errors.As(err, &ue) {
+		// request preparation issues, like marshalling the body
+		return false
+	}
+	var cve *tls.CertificateVerificationError
+	if errors.As(err, &cve) {
+		return false
+	}
+	var uae x509.UnknownAuthorityError
+	return !errors.As(err, &uae)
+}
+
+// Sleep waits for the provided duration, or returns false if the context
+// would be done before the duration is over.
+func Sleep(ctx context.Context, d time.Duration) bool {
+	if dl, ok := ctx.Deadline(); ok && time.Until(dl) < d {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// ParseRetryAfter parses a Retry-After header value in either the delay
+// seconds or HTTP date formats.
+func ParseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := time.Until(t); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
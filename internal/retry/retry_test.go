@@ -0,0 +1,54 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	for attempt, max := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		10: time.Second,
+		40: time.Second,
+	} {
+		d := Backoff(attempt, 100*time.Millisecond, time.Second)
+		assert.GreaterOrEqual(t, d, max/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, d, max, "attempt %d", attempt)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	assert.True(t, IsTransientError(&url.Error{Op: "Get", Err: errors.New("connection reset")}))
+	assert.False(t, IsTransientError(&url.Error{Op: "Get", Err: context.Canceled}))
+	assert.False(t, IsTransientError(errors.New("marshalling body")))
+}
+
+func TestSleep(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	assert.False(t, Sleep(ctx, time.Hour), "beyond deadline")
+	assert.True(t, Sleep(ctx, time.Millisecond))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	d, ok := ParseRetryAfter("120")
+	assert.True(t, ok)
+	assert.Equal(t, 120*time.Second, d)
+
+	d, ok = ParseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.InDelta(t, float64(time.Minute), float64(d), float64(2*time.Second))
+
+	_, ok = ParseRetryAfter("invalid")
+	assert.False(t, ok)
+	_, ok = ParseRetryAfter("")
+	assert.False(t, ok)
+}
@@ -18,6 +18,7 @@ var (
 // error messages.
 type ResponseError struct {
 	response *resty.Response
+	attempts int
 
 	// Code is the error code which may have been provided by the server.
 	Code string `json:"code"`
@@ -53,6 +54,13 @@ func (r *ResponseError) Error() string {
 	return fmt.Sprintf("%d: %v", r.response.StatusCode(), r.Message)
 }
 
+// Attempts provides the number of requests that were made before the error
+// response was accepted, which may be greater than one when a retry policy
+// has been configured.
+func (r *ResponseError) Attempts() int {
+	return r.attempts
+}
+
 // Response provides underlying response, in case it might be useful for
 // debugging.
 func (r *ResponseError) Response() *resty.Response {
@@ -5,6 +5,9 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/invopop/client.go/internal/retry"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"resty.dev/v3"
 )
 
@@ -17,6 +20,14 @@ type Client struct {
 	clientID     string
 	clientSecret string
 
+	// retry policy to apply to requests, if any
+	retry *RetryPolicy
+
+	// tracer used to instrument requests, if enabled, and the propagator
+	// used to send the trace context
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+
 	svc *service
 }
 
@@ -151,69 +162,57 @@ func GetClient(ctx context.Context) *Client {
 }
 
 func (c *Client) get(ctx context.Context, path string, body interface{}) error {
-	re := new(ResponseError)
-	res, err := c.conn.R().
-		SetContext(ctx).
-		SetResult(body).
-		SetError(re).
-		Get(path)
-	if err != nil {
-		return err
-	}
-	return re.handle(res)
+	return c.do(ctx, resty.MethodGet, path, nil, body)
 }
 
 func (c *Client) post(ctx context.Context, path string, in, out any) error {
-	re := new(ResponseError)
-	res, err := c.conn.R().
-		SetContext(ctx).
-		SetBody(in).
-		SetError(re).
-		SetResult(out).
-		Post(path)
-	if err != nil {
-		return err
-	}
-	return re.handle(res)
+	return c.do(ctx, resty.MethodPost, path, in, out)
 }
 
 func (c *Client) put(ctx context.Context, path string, in, out any) error {
-	re := new(ResponseError)
-	res, err := c.conn.R().
-		SetContext(ctx).
-		SetBody(in).
-		SetError(re).
-		SetResult(out).
-		Put(path)
-	if err != nil {
-		return err
-	}
-	return re.handle(res)
+	return c.do(ctx, resty.MethodPut, path, in, out)
 }
 
 func (c *Client) patch(ctx context.Context, path string, in, out any) error {
-	re := new(ResponseError)
-	res, err := c.conn.R().
-		SetContext(ctx).
-		SetBody(in).
-		SetError(re).
-		SetResult(out).
-		Patch(path)
-	if err != nil {
-		return err
-	}
-	return re.handle(res)
+	return c.do(ctx, resty.MethodPatch, path, in, out)
 }
 
 func (c *Client) delete(ctx context.Context, path string, result interface{}) error {
-	re := new(ResponseError)
-	res, err := c.conn.R().
-		SetContext(ctx).
-		SetError(re).
-		SetResult(result).
-		Delete(path)
-	if err != nil {
-		return err
+	return c.do(ctx, resty.MethodDelete, path, nil, result)
+}
+
+// do executes the request, retrying according to the client's retry policy
+// if the method allows it.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) (err error) {
+	attempts := c.retry.attempts(method, path)
+	if attempts > 1 && c.retry.MaxElapsed > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.retry.MaxElapsed)
+		defer cancel()
+	}
+	ctx, span := c.startSpan(ctx, method, path)
+	var res *resty.Response
+	attempt := 1
+	defer func() {
+		endSpan(span, res, attempt, err)
+	}()
+	for ; ; attempt++ {
+		re := &ResponseError{attempts: attempt}
+		req := c.conn.R().
+			SetContext(ctx).
+			SetError(re).
+			SetResult(out)
+		if in != nil {
+			req.SetBody(in)
+		}
+		c.injectTrace(ctx, req)
+		res, err = req.Execute(method, path)
+		if attempt < attempts && shouldRetry(res, err) && retry.Sleep(ctx, c.retry.wait(res, attempt)) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return re.handle(res)
 	}
-	return re.handle(res)
 }
@@ -0,0 +1,182 @@
+package invopoptest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/invopop/client.go/invopop"
+	"github.com/invopop/gobl/uuid"
+)
+
+const enrollmentTokenTTL = time.Hour
+
+type authorizeEnrollment struct {
+	ID           string `json:"id"`
+	OwnerID      string `json:"owner_id"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+type createEnrollment struct {
+	OwnerID      string          `json:"owner_id"`
+	Data         json.RawMessage `json:"data"`
+	ClientID     string          `json:"client_id"`
+	ClientSecret string          `json:"client_secret"`
+}
+
+func (s *Server) routeAccess(mux *http.ServeMux) {
+	mux.HandleFunc("GET /access/v1/enrollment", s.fetchEnrollment)
+	mux.HandleFunc("POST /access/v1/enrollment", s.updateEnrollment)
+	mux.HandleFunc("POST /access/v1/enrollment/authorize", s.authorizeEnrollment)
+	mux.HandleFunc("PUT /access/v1/enrollment/{id}", s.createEnrollment)
+}
+
+// AddEnrollment stores a copy of the provided enrollment so that it can be
+// authorized by clients using the owner ID, enrollment ID, or token. The
+// copy is provided with the ID and timestamps set if they were missing.
+func (s *Server) AddEnrollment(e *invopop.Enrollment) *invopop.Enrollment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e = clone(e)
+	if e.ID == "" {
+		e.ID = uuid.V7().String()
+	}
+	if e.CreatedAt == "" {
+		e.CreatedAt = s.now()
+		e.UpdatedAt = e.CreatedAt
+	}
+	s.enrollments = append(s.enrollments, e)
+	return clone(e)
+}
+
+// findEnrollmentByToken looks for the enrollment associated with the request's
+// bearer token.
+func (s *Server) findEnrollmentByToken(r *http.Request) *invopop.Enrollment {
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || tok == "" {
+		return nil
+	}
+	for _, e := range s.enrollments {
+		if e.Token == tok {
+			return e
+		}
+	}
+	return nil
+}
+
+func validClientCredentials(id, secret string) bool {
+	return id == ClientID && secret == ClientSecret
+}
+
+func (s *Server) fetchEnrollment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.findEnrollmentByToken(r)
+	if e == nil {
+		writeNotFound(w, "enrollment")
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *Server) updateEnrollment(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.UpdateEnrollment)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.findEnrollmentByToken(r)
+	if e == nil {
+		writeNotFound(w, "enrollment")
+		return
+	}
+	e.Data = req.Data
+	e.UpdatedAt = s.now()
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *Server) authorizeEnrollment(w http.ResponseWriter, r *http.Request) {
+	req := new(authorizeEnrollment)
+	if !decode(w, r, req) {
+		return
+	}
+	if !validClientCredentials(req.ClientID, req.ClientSecret) {
+		writeError(w, http.StatusForbidden, "invalid client credentials")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var e *invopop.Enrollment
+	switch {
+	case req.ID != "":
+		for _, en := range s.enrollments {
+			if en.ID == req.ID {
+				e = en
+			}
+		}
+	case req.OwnerID != "":
+		for _, en := range s.enrollments {
+			if en.OwnerID == req.OwnerID {
+				e = en
+			}
+		}
+	default:
+		e = s.findEnrollmentByToken(r)
+	}
+	if e == nil {
+		writeNotFound(w, "enrollment")
+		return
+	}
+	if e.Disabled {
+		writeError(w, http.StatusForbidden, "enrollment disabled")
+		return
+	}
+	e.Token = uuid.V4().String()
+	e.TokenExpires = time.Now().Add(enrollmentTokenTTL).Unix()
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *Server) createEnrollment(w http.ResponseWriter, r *http.Request) {
+	req := new(createEnrollment)
+	if !decode(w, r, req) {
+		return
+	}
+	if !validClientCredentials(req.ClientID, req.ClientSecret) {
+		writeError(w, http.StatusForbidden, "invalid client credentials")
+		return
+	}
+	if req.OwnerID == "" {
+		writeError(w, http.StatusUnprocessableEntity, "owner_id: cannot be blank")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	for _, en := range s.enrollments {
+		if en.ID == id {
+			writeJSON(w, http.StatusOK, en)
+			return
+		}
+		if en.OwnerID == req.OwnerID {
+			writeConflict(w, "enrollment for owner")
+			return
+		}
+	}
+	e := &invopop.Enrollment{
+		ID:           id,
+		CreatedAt:    s.now(),
+		OwnerID:      req.OwnerID,
+		AppID:        ClientID,
+		Data:         req.Data,
+		Token:        uuid.V4().String(),
+		TokenExpires: time.Now().Add(enrollmentTokenTTL).Unix(),
+	}
+	e.UpdatedAt = e.CreatedAt
+	s.enrollments = append(s.enrollments, e)
+	writeJSON(w, http.StatusCreated, e)
+}
@@ -0,0 +1,208 @@
+// Package invopoptest provides an in-process fake of the Invopop API that
+// can be used to test code depending on the invopop package without
+// requiring network access or real credentials.
+//
+// Usage example:
+//
+//	srv := invopoptest.NewServer()
+//	defer srv.Close()
+//	ic := srv.Client()
+//	e, err := ic.Silo().Entries().Create(ctx, req)
+//
+// Resources are stored in memory and validated in a similar way to the
+// real API, so that errors like not found or conflicts can be checked using
+// helpers such as invopop.IsNotFound and invopop.IsConflict.
+package invopoptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/invopop/client.go/invopop"
+)
+
+// Default credentials used by clients prepared by the server.
+const (
+	ClientID     = "test-client-id"
+	ClientSecret = "test-client-secret"
+	AuthToken    = "test-token"
+)
+
+const (
+	defaultPageLimit = 20
+	timestampFormat  = "2006-01-02T15:04:05.000Z"
+)
+
+// JobHandler is called synchronously when a new job is created so that
+// tests may simulate the results of processing the job's workflow, such as
+// adding intents, completing it, or updating the silo entry.
+type JobHandler func(job *invopop.Job) error
+
+// Server is an in-memory fake implementation of the Invopop API served
+// over HTTP.
+type Server struct {
+	srv *httptest.Server
+	jh  JobHandler
+
+	mu    sync.Mutex
+	clock time.Time
+
+	// silo
+	entries  []*invopop.SiloEntry
+	files    map[string][]byte // file ID to data
+	spool    map[string]*spoolObject
+	sequence map[string]*series
+
+	// transform
+	workflows []*invopop.Workflow
+	jobs      []*invopop.Job
+	refs      map[string]string // intent refs to IDs
+
+	// access
+	enrollments []*invopop.Enrollment
+}
+
+// Option is used to configure the server.
+type Option func(s *Server)
+
+// WithJobHandler sets the handler that will be called after jobs are
+// created.
+func WithJobHandler(jh JobHandler) Option {
+	return func(s *Server) {
+		s.jh = jh
+	}
+}
+
+// NewServer starts a new fake Invopop API server. Be sure to call Close
+// once finished.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		files:    make(map[string][]byte),
+		spool:    make(map[string]*spoolObject),
+		sequence: make(map[string]*series),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /utils/v1/ping", s.ping)
+	s.routeSilo(mux)
+	s.routeSequence(mux)
+	s.routeTransform(mux)
+	s.routeAccess(mux)
+	s.srv = httptest.NewServer(mux)
+
+	return s
+}
+
+// URL provides the base URL of the server.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Close shuts down the server and blocks until all requests have completed.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client provides a new Invopop client prepared to talk to the server with
+// a default set of credentials. Additional options may be provided to
+// further configure the client.
+func (s *Server) Client(opts ...invopop.ClientOption) *invopop.Client {
+	opts = append([]invopop.ClientOption{
+		invopop.WithConfig(&invopop.Config{
+			BaseURL:      s.srv.URL,
+			ClientID:     ClientID,
+			ClientSecret: ClientSecret,
+		}),
+		invopop.WithAuthToken(AuthToken),
+	}, opts...)
+	return invopop.New(opts...)
+}
+
+// now provides a strictly increasing timestamp so that resources can
+// be ordered and paginated reliably by their creation date.
+func (s *Server) now() string {
+	tn := time.Now().UTC().Truncate(time.Millisecond)
+	if !tn.After(s.clock) {
+		tn = s.clock.Add(time.Millisecond)
+	}
+	s.clock = tn
+	return tn.Format(timestampFormat)
+}
+
+func (s *Server) ping(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, &invopop.Ping{Ping: "pong"})
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body) // nolint:errcheck
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, &errorResponse{Message: msg})
+}
+
+func writeNotFound(w http.ResponseWriter, what string) {
+	writeError(w, http.StatusNotFound, what+" not found")
+}
+
+func writeConflict(w http.ResponseWriter, what string) {
+	writeError(w, http.StatusConflict, what+" already exists")
+}
+
+// clone provides a deep copy of the resource using the same JSON
+// representation sent to clients, so that resources passed in or out of
+// the server do not share any state with those it keeps.
+func clone[T any](v *T) *T {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("invopoptest: copying %T: %v", v, err))
+	}
+	out := new(T)
+	if err := json.Unmarshal(data, out); err != nil {
+		panic(fmt.Sprintf("invopoptest: copying %T: %v", v, err))
+	}
+	return out
+}
+
+// decode will try to parse the request body, or write a bad request
+// response if not possible.
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// page determines the start and end positions of a page of results using
+// an offset cursor.
+func page(r *http.Request, total int) (int, int, string) {
+	limit := defaultPageLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+	if start < 0 || start > total {
+		start = total
+	}
+	end := min(start+limit, total)
+	next := ""
+	if end < total {
+		next = strconv.Itoa(end)
+	}
+	return start, end, next
+}
@@ -0,0 +1,284 @@
+package invopoptest_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/invopop/client.go/invopop"
+	"github.com/invopop/client.go/invopop/invopoptest"
+	"github.com/invopop/gobl/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiloEntries(t *testing.T) {
+	srv := invopoptest.NewServer()
+	defer srv.Close()
+	ic := srv.Client()
+	ctx := context.Background()
+	data := json.RawMessage(`{"$schema":"https://gobl.org/draft-0/note/message","content":"hello"}`)
+
+	e, err := ic.Silo().Entries().Create(ctx, &invopop.CreateSiloEntry{
+		ID:   uuid.V7().String(),
+		Key:  "msg-1",
+		Data: data,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://gobl.org/draft-0/note/message", e.DocSchema)
+
+	_, err = ic.Silo().Entries().Create(ctx, &invopop.CreateSiloEntry{Key: "msg-1", Data: data})
+	assert.True(t, invopop.IsConflict(err))
+
+	e2, err := ic.Silo().Entries().FetchByKey(ctx, "msg-1")
+	require.NoError(t, err)
+	assert.Equal(t, e.ID, e2.ID)
+
+	_, err = ic.Silo().Entries().Fetch(ctx, uuid.V7().String())
+	assert.True(t, invopop.IsNotFound(err))
+
+	e, err = ic.Silo().Entries().Update(ctx, &invopop.UpdateSiloEntry{
+		ID:          e.ID,
+		ContentType: invopop.MIMEApplicationMergePatchJSON,
+		Data:        json.RawMessage(`{"content":"bye"}`),
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"$schema":"https://gobl.org/draft-0/note/message","content":"bye"}`, string(e.Data))
+
+	for i := 0; i < 4; i++ {
+		_, err := ic.Silo().Entries().Create(ctx, &invopop.CreateSiloEntry{Data: data})
+		require.NoError(t, err)
+	}
+	count := 0
+	for _, err := range ic.Silo().Entries().All(ctx, &invopop.FindSiloEntries{Limit: 2}) {
+		require.NoError(t, err)
+		count++
+	}
+	assert.Equal(t, 5, count)
+}
+
+func TestSiloFilesAndMeta(t *testing.T) {
+	srv := invopoptest.NewServer()
+	defer srv.Close()
+	ic := srv.Client()
+	ctx := context.Background()
+
+	e, err := ic.Silo().Entries().Create(ctx, &invopop.CreateSiloEntry{
+		Data: json.RawMessage(`{"$schema":"https://gobl.org/draft-0/note/message","content":"hello"}`),
+	})
+	require.NoError(t, err)
+
+	f, err := ic.Silo().Files().Create(ctx, &invopop.CreateSiloFile{
+		EntryID: e.ID,
+		Name:    "test.txt",
+		Key:     "test",
+		Data:    []byte("testing"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), f.Size)
+
+	_, err = ic.Silo().Files().Create(ctx, &invopop.CreateSiloFile{
+		EntryID: e.ID,
+		Name:    "other.txt",
+		Key:     "test",
+		Data:    []byte("testing"),
+	})
+	assert.True(t, invopop.IsConflict(err))
+
+	rd, err := ic.Silo().Files().Download(ctx, e.ID, f.ID)
+	require.NoError(t, err)
+	defer rd.Close() // nolint:errcheck
+	out, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	assert.Equal(t, "testing", string(out))
+
+	_, err = ic.Silo().Meta().Upsert(ctx, &invopop.UpsertSiloMeta{
+		EntryID: e.ID,
+		Key:     "provider",
+		Ref:     "ref-1",
+		Value:   json.RawMessage(`{"foo":"bar"}`),
+	})
+	require.NoError(t, err)
+	m, err := ic.Silo().Meta().FetchByRef(ctx, "provider", "ref-1")
+	require.NoError(t, err)
+	assert.Equal(t, e.ID, m.EntryID)
+
+	se := srv.SiloEntry(e.ID)
+	require.Len(t, se.Meta, 1)
+	se.Meta[0].Ref = "changed"
+	se.Data = nil
+	assert.Equal(t, "ref-1", srv.SiloEntry(e.ID).Meta[0].Ref)
+	assert.NotEmpty(t, srv.SiloEntry(e.ID).Data)
+	_, err = ic.Silo().Meta().Delete(ctx, e.ID, "provider")
+	require.NoError(t, err)
+	_, err = ic.Silo().Meta().Fetch(ctx, e.ID, "provider")
+	assert.True(t, invopop.IsNotFound(err))
+
+	key, err := ic.Silo().Spool().Upload(ctx, "test.txt", "text/plain", []byte("spooled"))
+	require.NoError(t, err)
+	d, err := ic.Download(ctx, "spool:"+key)
+	require.NoError(t, err)
+	defer d.Close() // nolint:errcheck
+	assert.Equal(t, "test.txt", d.Name)
+	require.NoError(t, ic.Silo().Spool().Delete(ctx, key))
+	assert.True(t, invopop.IsNotFound(ic.Silo().Spool().Delete(ctx, key)))
+}
+
+func TestSequence(t *testing.T) {
+	srv := invopoptest.NewServer()
+	defer srv.Close()
+	ic := srv.Client()
+	ctx := context.Background()
+
+	s, err := ic.Sequence().Create(ctx, &invopop.CreateSeries{
+		ID:      uuid.V7().String(),
+		Name:    "Sales",
+		Code:    "SALES",
+		Prefix:  "INV-",
+		Padding: 3,
+		Start:   10,
+	})
+	require.NoError(t, err)
+	_, err = ic.Sequence().Create(ctx, &invopop.CreateSeries{ID: uuid.V7().String(), Name: "Dup", Code: "SALES"})
+	assert.True(t, invopop.IsConflict(err))
+
+	se, err := ic.Sequence().CreateEntry(ctx, s.ID, &invopop.CreateSeriesEntry{ID: uuid.V7().String()})
+	require.NoError(t, err)
+	assert.Equal(t, "INV-010", se.Code)
+	se, err = ic.Sequence().CreateEntry(ctx, s.ID, &invopop.CreateSeriesEntry{ID: uuid.V7().String()})
+	require.NoError(t, err)
+	assert.Equal(t, "INV-011", se.Code)
+
+	_, err = ic.Sequence().FetchEntry(ctx, s.ID, uuid.V7().String())
+	assert.True(t, invopop.IsNotFound(err))
+}
+
+func TestTransform(t *testing.T) {
+	srv := invopoptest.NewServer(
+		invopoptest.WithJobHandler(func(job *invopop.Job) error {
+			job.CompletedAt = job.CreatedAt
+			return nil
+		}),
+	)
+	defer srv.Close()
+	ic := srv.Client()
+	ctx := context.Background()
+
+	wf, err := ic.Transform().Workflows().Create(ctx, &invopop.CreateWorkflow{Name: "Test"})
+	require.NoError(t, err)
+
+	_, err = ic.Transform().Jobs().Create(ctx, &invopop.CreateJob{WorkflowID: uuid.V7().String()})
+	assert.True(t, invopop.IsNotFound(err))
+
+	job, err := ic.Transform().Jobs().Create(ctx, &invopop.CreateJob{
+		WorkflowID: wf.ID,
+		Key:        "job-1",
+		Data:       json.RawMessage(`{"$schema":"https://gobl.org/draft-0/note/message","content":"hello"}`),
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, job.SiloEntryID)
+	assert.NotEmpty(t, job.CompletedAt)
+
+	_, err = ic.Transform().Jobs().Create(ctx, &invopop.CreateJob{WorkflowID: wf.ID, Key: "job-1"})
+	assert.True(t, invopop.IsConflict(err))
+
+	job2, err := ic.Transform().Jobs().FetchByKey(ctx, "job-1")
+	require.NoError(t, err)
+	assert.Equal(t, job.ID, job2.ID)
+}
+
+func TestServerCopies(t *testing.T) {
+	srv := invopoptest.NewServer()
+	defer srv.Close()
+	ic := srv.Client()
+	ctx := context.Background()
+
+	in := &invopop.SiloEntry{Key: "msg-1", Data: json.RawMessage(`{"content":"hello"}`)}
+	e := srv.AddSiloEntry(in)
+	assert.NotEmpty(t, e.ID)
+	assert.Empty(t, in.ID, "caller's entry not modified")
+	in.Key = "changed"
+	e.Key = "changed"
+	assert.Equal(t, "msg-1", srv.SiloEntry(e.ID).Key)
+
+	wf := srv.AddWorkflow(&invopop.Workflow{Name: "Test"})
+	assert.NotEmpty(t, wf.ID)
+	wf.Name = "changed"
+	wf2, err := ic.Transform().Workflows().Fetch(ctx, wf.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Test", wf2.Name)
+
+	job, err := ic.Transform().Jobs().Create(ctx, &invopop.CreateJob{WorkflowID: wf.ID, Key: "job-1"})
+	require.NoError(t, err)
+	j := srv.Job(job.ID)
+	require.NotNil(t, j)
+	j.Key = "changed"
+	assert.Equal(t, "job-1", srv.Job(job.ID).Key)
+	assert.Nil(t, srv.Job("missing"))
+
+	en := srv.AddEnrollment(&invopop.Enrollment{OwnerID: uuid.V7().String()})
+	assert.NotEmpty(t, en.ID)
+}
+
+func TestSiloMetaOwned(t *testing.T) {
+	srv := invopoptest.NewServer()
+	defer srv.Close()
+	ic := srv.Client()
+	ctx := context.Background()
+
+	ownerID := uuid.V7().String()
+	srv.AddEnrollment(&invopop.Enrollment{OwnerID: ownerID})
+	sess := ic.Access().NewSessionWithOwnerID(ownerID)
+	require.NoError(t, sess.Authorize(ctx))
+	oc := sess.Client()
+
+	e, err := oc.Silo().Entries().Create(ctx, &invopop.CreateSiloEntry{
+		Data: json.RawMessage(`{"$schema":"https://gobl.org/draft-0/note/message","content":"hello"}`),
+	})
+	require.NoError(t, err)
+	for _, req := range []*invopop.UpsertSiloMeta{
+		{EntryID: e.ID, Key: "owned", Ref: "ref-1", Owned: true},
+		{EntryID: e.ID, Key: "shared", Ref: "ref-1"},
+	} {
+		_, err = oc.Silo().Meta().Upsert(ctx, req)
+		require.NoError(t, err)
+	}
+
+	m, err := oc.Silo().Meta().FetchByOwnerAndRef(ctx, "owned", "ref-1")
+	require.NoError(t, err)
+	assert.Equal(t, ownerID, m.OwnerID)
+	_, err = oc.Silo().Meta().FetchByOwnerAndRef(ctx, "shared", "ref-1")
+	assert.True(t, invopop.IsNotFound(err))
+
+	other := uuid.V7().String()
+	srv.AddEnrollment(&invopop.Enrollment{OwnerID: other})
+	sess = ic.Access().NewSessionWithOwnerID(other)
+	require.NoError(t, sess.Authorize(ctx))
+	_, err = sess.Client().Silo().Meta().FetchByOwnerAndRef(ctx, "owned", "ref-1")
+	assert.True(t, invopop.IsNotFound(err))
+
+	_, err = ic.Silo().Meta().FetchByOwnerAndRef(ctx, "owned", "ref-1")
+	assert.Error(t, err)
+}
+
+func TestAccessEnrollment(t *testing.T) {
+	srv := invopoptest.NewServer()
+	defer srv.Close()
+	ic := srv.Client()
+	ctx := context.Background()
+
+	ownerID := uuid.V7().String()
+	srv.AddEnrollment(&invopop.Enrollment{OwnerID: ownerID})
+
+	sess := ic.Access().NewSessionWithOwnerID(ownerID)
+	require.NoError(t, sess.Authorize(ctx))
+	assert.NotEmpty(t, sess.Token)
+
+	en, err := sess.Client().Access().Enrollment().Fetch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ownerID, en.OwnerID)
+
+	sess = ic.Access().NewSessionWithOwnerID(uuid.V7().String())
+	assert.ErrorIs(t, sess.Authorize(ctx), invopop.ErrAccessDenied)
+}
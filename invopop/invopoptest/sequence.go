@@ -0,0 +1,140 @@
+package invopoptest
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/invopop/client.go/invopop"
+)
+
+type series struct {
+	*invopop.Series
+	code    string
+	entries map[string]*invopop.SeriesEntry
+}
+
+func (s *Server) routeSequence(mux *http.ServeMux) {
+	mux.HandleFunc("GET /sequence/v1/series", s.listSeries)
+	mux.HandleFunc("GET /sequence/v1/series/{id}", s.fetchSeries)
+	mux.HandleFunc("PUT /sequence/v1/series/{id}", s.createSeries)
+	mux.HandleFunc("GET /sequence/v1/series/{id}/entries/{eid}", s.fetchSeriesEntry)
+	mux.HandleFunc("PUT /sequence/v1/series/{id}/entries/{eid}", s.createSeriesEntry)
+}
+
+func (s *Server) listSeries(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	col := &invopop.SeriesCollection{
+		List: make([]*invopop.Series, 0, len(s.sequence)),
+	}
+	for _, ss := range s.sequence {
+		col.List = append(col.List, ss.Series)
+	}
+	slices.SortFunc(col.List, func(a, b *invopop.Series) int {
+		return strings.Compare(a.CreatedAt, b.CreatedAt)
+	})
+	writeJSON(w, http.StatusOK, col)
+}
+
+func (s *Server) fetchSeries(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sequence[r.PathValue("id")]
+	if !ok {
+		writeNotFound(w, "series")
+		return
+	}
+	writeJSON(w, http.StatusOK, ss.Series)
+}
+
+func (s *Server) createSeries(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.CreateSeries)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	if ss, ok := s.sequence[id]; ok {
+		writeJSON(w, http.StatusOK, ss.Series)
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "name: cannot be blank")
+		return
+	}
+	if req.Code != "" {
+		for _, ss := range s.sequence {
+			if ss.code == req.Code {
+				writeConflict(w, "series code")
+				return
+			}
+		}
+	}
+	ss := &series{
+		Series: &invopop.Series{
+			ID:        id,
+			Name:      req.Name,
+			Prefix:    req.Prefix,
+			Padding:   req.Padding,
+			Suffix:    req.Suffix,
+			Start:     req.Start,
+			CreatedAt: s.now(),
+		},
+		code:    req.Code,
+		entries: make(map[string]*invopop.SeriesEntry),
+	}
+	s.sequence[id] = ss
+	writeJSON(w, http.StatusCreated, ss.Series)
+}
+
+func (s *Server) fetchSeriesEntry(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sequence[r.PathValue("id")]
+	if !ok {
+		writeNotFound(w, "series")
+		return
+	}
+	e, ok := ss.entries[r.PathValue("eid")]
+	if !ok {
+		writeNotFound(w, "series entry")
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *Server) createSeriesEntry(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.CreateSeriesEntry)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sequence[r.PathValue("id")]
+	if !ok {
+		writeNotFound(w, "series")
+		return
+	}
+	id := r.PathValue("eid")
+	if e, ok := ss.entries[id]; ok {
+		writeJSON(w, http.StatusOK, e)
+		return
+	}
+	idx := ss.LastIndex + 1
+	if ss.LastEntryID == "" {
+		idx = max(int64(ss.Start), 1)
+	}
+	e := &invopop.SeriesEntry{
+		ID:   id,
+		Code: fmt.Sprintf("%s%0*d%s", ss.Prefix, ss.Padding, idx, ss.Suffix),
+	}
+	ss.entries[id] = e
+	ss.LastIndex = idx
+	ss.LastEntryID = id
+	writeJSON(w, http.StatusCreated, e)
+}
@@ -0,0 +1,524 @@
+package invopoptest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/invopop/client.go/invopop"
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/uuid"
+)
+
+type spoolObject struct {
+	name string
+	typ  string
+	data []byte
+}
+
+func (s *Server) routeSilo(mux *http.ServeMux) {
+	mux.HandleFunc("GET /silo/v1/entries", s.listEntries)
+	mux.HandleFunc("POST /silo/v1/entries", s.createEntry)
+	mux.HandleFunc("GET /silo/v1/entries/{id}", s.fetchEntry)
+	mux.HandleFunc("PUT /silo/v1/entries/{id}", s.createEntry)
+	mux.HandleFunc("PATCH /silo/v1/entries/{id}", s.updateEntry)
+	mux.HandleFunc("GET /silo/v1/entries/key/{key}", s.fetchEntryByKey)
+
+	// Meta lookups by ref share the same shape as entry sub-resources
+	mux.HandleFunc("GET /silo/v1/entries/{id}/{res}/{key}", s.fetchEntryResource)
+	mux.HandleFunc("PUT /silo/v1/entries/{id}/files/{fid}", s.createFile)
+	mux.HandleFunc("PUT /silo/v1/entries/{id}/meta/{key}", s.upsertMeta)
+	mux.HandleFunc("DELETE /silo/v1/entries/{id}/meta/{key}", s.deleteMeta)
+
+	mux.HandleFunc("POST /silo/v1/spool", s.uploadSpool)
+	mux.HandleFunc("GET /silo/v1/spool/{key}", s.downloadSpool)
+	mux.HandleFunc("DELETE /silo/v1/spool/{key}", s.deleteSpool)
+}
+
+// AddSiloEntry stores a copy of the provided silo entry directly, without
+// any validation, so that tests may start with a known state. The copy is
+// provided with the ID and timestamps set if they were missing.
+func (s *Server) AddSiloEntry(e *invopop.SiloEntry) *invopop.SiloEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e = clone(e)
+	if e.ID == "" {
+		e.ID = uuid.V7().String()
+	}
+	if e.CreatedAt == "" {
+		e.CreatedAt = s.now()
+		e.UpdatedAt = e.CreatedAt
+	}
+	s.entries = append(s.entries, e)
+	return clone(e)
+}
+
+// SiloEntry provides a copy of the stored silo entry with the matching ID,
+// or nil. Changes to the copy will not affect the server's state.
+func (s *Server) SiloEntry(id string) *invopop.SiloEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.findEntry(id)
+	if e == nil {
+		return nil
+	}
+	return clone(e)
+}
+
+func (s *Server) findEntry(id string) *invopop.SiloEntry {
+	for _, e := range s.entries {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+func (s *Server) findEntryByKey(key string) *invopop.SiloEntry {
+	for _, e := range s.entries {
+		if e.Key != "" && e.Key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+func (s *Server) listEntries(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	col := &invopop.SiloEntryCollection{
+		Folder:    q.Get("folder"),
+		CreatedAt: q.Get("created_at"),
+		Cursor:    q.Get("cursor"),
+	}
+	list := make([]*invopop.SiloEntry, 0)
+	for _, e := range s.entries {
+		if col.Folder != "" && e.Folder != col.Folder {
+			continue
+		}
+		if col.CreatedAt != "" && e.CreatedAt < col.CreatedAt {
+			continue
+		}
+		list = append(list, e)
+	}
+	start, end, next := page(r, len(list))
+	col.List = list[start:end]
+	col.Limit = int32(end - start)
+	col.NextCursor = next
+	writeJSON(w, http.StatusOK, col)
+}
+
+func (s *Server) fetchEntry(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.findEntry(r.PathValue("id"))
+	if e == nil {
+		writeNotFound(w, "silo entry")
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *Server) fetchEntryByKey(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.findEntryByKey(r.PathValue("key"))
+	if e == nil {
+		writeNotFound(w, "silo entry")
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *Server) createEntry(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.CreateSiloEntry)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	if id == "" {
+		id = uuid.V7().String()
+	} else if e := s.findEntry(id); e != nil {
+		// idempotent creation
+		writeJSON(w, http.StatusOK, e)
+		return
+	}
+	if req.Key != "" && s.findEntryByKey(req.Key) != nil {
+		writeConflict(w, "silo entry key")
+		return
+	}
+	data := req.Data
+	if req.PreviousID != "" {
+		prev := s.findEntry(req.PreviousID)
+		if prev == nil {
+			writeNotFound(w, "previous silo entry")
+			return
+		}
+		if len(data) == 0 {
+			data = prev.Data
+		}
+	}
+	if len(data) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "data: cannot be blank")
+		return
+	}
+	e := &invopop.SiloEntry{
+		ID:     id,
+		Key:    req.Key,
+		Folder: req.Folder,
+	}
+	if err := setEntryData(e, data); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	e.CreatedAt = s.now()
+	e.UpdatedAt = e.CreatedAt
+	s.entries = append(s.entries, e)
+	writeJSON(w, http.StatusCreated, e)
+}
+
+func (s *Server) updateEntry(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.UpdateSiloEntry)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.findEntry(r.PathValue("id"))
+	if e == nil {
+		writeNotFound(w, "silo entry")
+		return
+	}
+	if e.Signed && len(req.Data) > 0 {
+		writeError(w, http.StatusConflict, "silo entry is signed")
+		return
+	}
+	if len(req.Data) > 0 {
+		data := req.Data
+		switch req.ContentType {
+		case "", invopop.MIMEApplicationJSON:
+			// complete replacement
+		case invopop.MIMEApplicationMergePatchJSON:
+			var err error
+			if data, err = jsonpatch.MergePatch(e.Data, req.Data); err != nil {
+				writeError(w, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+		default:
+			writeError(w, http.StatusUnsupportedMediaType, "unsupported content type: "+req.ContentType)
+			return
+		}
+		if err := setEntryData(e, data); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	if req.Folder != "" {
+		e.Folder = req.Folder
+	}
+	e.UpdatedAt = s.now()
+	writeJSON(w, http.StatusOK, e)
+}
+
+// fetchEntryResource deals with the ambiguous GET paths that may either
+// be a meta lookup by reference, or an entry's meta or file.
+func (s *Server) fetchEntryResource(w http.ResponseWriter, r *http.Request) {
+	id, res, key := r.PathValue("id"), r.PathValue("res"), r.PathValue("key")
+	switch {
+	case id == "meta":
+		s.fetchMetaByRef(w, r, res, key)
+	case res == "meta":
+		s.fetchMeta(w, id, key)
+	case res == "files":
+		s.downloadFile(w, id, key)
+	default:
+		writeNotFound(w, "resource")
+	}
+}
+
+func (s *Server) createFile(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.CreateSiloFile)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.findEntry(r.PathValue("id"))
+	if e == nil {
+		writeNotFound(w, "silo entry")
+		return
+	}
+	id := r.PathValue("fid")
+	for _, f := range e.Files {
+		if f.ID == id {
+			writeJSON(w, http.StatusOK, f)
+			return
+		}
+		if req.Key != "" && f.Key == req.Key {
+			writeConflict(w, "file key")
+			return
+		}
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "name: cannot be blank")
+		return
+	}
+	if len(req.Data) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "data: cannot be blank")
+		return
+	}
+	sum := sha256.Sum256(req.Data)
+	f := &invopop.SiloFile{
+		ID:         id,
+		CreatedAt:  s.now(),
+		Name:       req.Name,
+		Desc:       req.Desc,
+		Key:        req.Key,
+		Category:   req.Category,
+		Hash:       hex.EncodeToString(sum[:]),
+		MIME:       req.MIME,
+		Size:       int32(len(req.Data)),
+		Stored:     true,
+		Embeddable: req.Embeddable,
+		Private:    req.Private,
+		Meta:       req.Meta,
+		URL:        fmt.Sprintf("%s/silo/v1/entries/%s/files/%s", s.srv.URL, e.ID, id),
+	}
+	if f.MIME == "" {
+		f.MIME = http.DetectContentType(req.Data)
+	}
+	s.files[id] = req.Data
+	e.Files = append(e.Files, f)
+	writeJSON(w, http.StatusCreated, f)
+}
+
+func (s *Server) downloadFile(w http.ResponseWriter, entryID, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.findEntry(entryID)
+	if e == nil {
+		writeNotFound(w, "silo entry")
+		return
+	}
+	for _, f := range e.Files {
+		if f.ID == id {
+			w.Header().Set("Content-Type", f.MIME)
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.Name}))
+			w.WriteHeader(http.StatusOK)
+			w.Write(s.files[id]) // nolint:errcheck
+			return
+		}
+	}
+	writeNotFound(w, "file")
+}
+
+func (s *Server) fetchMeta(w http.ResponseWriter, entryID, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.findEntry(entryID)
+	if e == nil {
+		writeNotFound(w, "silo entry")
+		return
+	}
+	for _, m := range e.Meta {
+		if m.Key == key {
+			writeJSON(w, http.StatusOK, m)
+			return
+		}
+	}
+	writeNotFound(w, "meta")
+}
+
+// fetchMetaByRef looks for the meta row with the ref. Lookups with the
+// "owned" query parameter are limited to owned rows belonging to the owner
+// of the enrollment token used to make the request.
+func (s *Server) fetchMetaByRef(w http.ResponseWriter, r *http.Request, key, ref string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owned := r.URL.Query().Get("owned") == "true"
+	var owner string
+	if owned {
+		en := s.findEnrollmentByToken(r)
+		if en == nil {
+			writeError(w, http.StatusUnauthorized, "enrollment token required for owned lookups")
+			return
+		}
+		owner = en.OwnerID
+	}
+	for _, e := range s.entries {
+		for _, m := range e.Meta {
+			if owned && (!m.Owned || m.OwnerID != owner) {
+				continue
+			}
+			if m.Key == key && m.Ref == ref {
+				writeJSON(w, http.StatusOK, m)
+				return
+			}
+		}
+	}
+	writeNotFound(w, "meta")
+}
+
+func (s *Server) upsertMeta(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.UpsertSiloMeta)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.findEntry(r.PathValue("id"))
+	if e == nil {
+		writeNotFound(w, "silo entry")
+		return
+	}
+	key := r.PathValue("key")
+	var m *invopop.SiloMeta
+	for _, em := range e.Meta {
+		if em.Key == key {
+			m = em
+			break
+		}
+	}
+	if req.Ref != "" {
+		// refs must be unique per key across entries
+		for _, oe := range s.entries {
+			for _, om := range oe.Meta {
+				if om != m && om.Key == key && om.Ref == req.Ref {
+					writeConflict(w, "meta ref")
+					return
+				}
+			}
+		}
+	}
+	tn := s.now()
+	status := http.StatusOK
+	if m == nil {
+		m = &invopop.SiloMeta{
+			ID:        fmt.Sprintf("%s:%s", e.ID, key),
+			CreatedAt: tn,
+			EntryID:   e.ID,
+			Key:       key,
+		}
+		e.Meta = append(e.Meta, m)
+		status = http.StatusCreated
+	}
+	m.UpdatedAt = tn
+	m.Ref = req.Ref
+	m.Value = req.Value
+	m.LinkURL = req.LinkURL
+	m.LinkScope = req.LinkScope
+	m.Indexed = req.Indexed
+	m.Owned = req.Owned
+	if en := s.findEnrollmentByToken(r); en != nil {
+		m.OwnerID = en.OwnerID
+	}
+	m.Secure = req.Secure
+	m.Shared = req.Shared
+	writeJSON(w, status, m)
+}
+
+func (s *Server) deleteMeta(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.findEntry(r.PathValue("id"))
+	if e == nil {
+		writeNotFound(w, "silo entry")
+		return
+	}
+	key := r.PathValue("key")
+	for i, m := range e.Meta {
+		if m.Key == key {
+			e.Meta = slices.Delete(e.Meta, i, i+1)
+			writeJSON(w, http.StatusOK, m)
+			return
+		}
+	}
+	writeNotFound(w, "meta")
+}
+
+type spoolUpload struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+func (s *Server) uploadSpool(w http.ResponseWriter, r *http.Request) {
+	req := new(spoolUpload)
+	if !decode(w, r, req) {
+		return
+	}
+	if req.Name == "" || req.Type == "" || len(req.Data) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "name, type, and data are required")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := uuid.V7().String()
+	s.spool[key] = &spoolObject{
+		name: req.Name,
+		typ:  req.Type,
+		data: req.Data,
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"key": key})
+}
+
+func (s *Server) downloadSpool(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.spool[r.PathValue("key")]
+	if !ok {
+		writeNotFound(w, "spool object")
+		return
+	}
+	w.Header().Set("Content-Type", obj.typ)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": obj.name}))
+	w.WriteHeader(http.StatusOK)
+	w.Write(obj.data) // nolint:errcheck
+}
+
+func (s *Server) deleteSpool(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := r.PathValue("key")
+	if _, ok := s.spool[key]; !ok {
+		writeNotFound(w, "spool object")
+		return
+	}
+	delete(s.spool, key)
+	writeJSON(w, http.StatusOK, map[string]string{"key": key})
+}
+
+// setEntryData will update the entry's data and extract the schemas.
+func setEntryData(e *invopop.SiloEntry, data json.RawMessage) error {
+	doc := struct {
+		Schema string `json:"$schema"`
+		Doc    *struct {
+			Schema string `json:"$schema"`
+		} `json:"doc"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+	if doc.Schema == gobl.EnvelopeSchema.String() && doc.Doc != nil {
+		e.EnvSchema = doc.Schema
+		e.DocSchema = doc.Doc.Schema
+	} else {
+		e.EnvSchema = gobl.EnvelopeSchema.String()
+		e.DocSchema = doc.Schema
+	}
+	e.Data = data
+	return nil
+}
+
@@ -0,0 +1,331 @@
+package invopoptest
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/invopop/client.go/invopop"
+	"github.com/invopop/gobl/uuid"
+)
+
+func (s *Server) routeTransform(mux *http.ServeMux) {
+	mux.HandleFunc("GET /transform/v1/workflows", s.listWorkflows)
+	mux.HandleFunc("GET /transform/v1/workflows/{id}", s.fetchWorkflow)
+	mux.HandleFunc("PUT /transform/v1/workflows/{id}", s.createWorkflow)
+	mux.HandleFunc("PATCH /transform/v1/workflows/{id}", s.updateWorkflow)
+
+	mux.HandleFunc("POST /transform/v1/jobs", s.createJob)
+	mux.HandleFunc("PUT /transform/v1/jobs/{id}", s.createJob)
+	mux.HandleFunc("GET /transform/v1/jobs/{id}", s.fetchJob)
+	mux.HandleFunc("GET /transform/v1/jobs/key/{key}", s.fetchJobByKey)
+	mux.HandleFunc("POST /transform/v1/jobs/intents", s.updateIntent)
+}
+
+// AddWorkflow stores a copy of the provided workflow directly so that jobs
+// can be created with it. The copy is provided with the ID and timestamps
+// set if they were missing.
+func (s *Server) AddWorkflow(wf *invopop.Workflow) *invopop.Workflow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wf = clone(wf)
+	if wf.ID == "" {
+		wf.ID = uuid.V7().String()
+	}
+	if wf.CreatedAt == "" {
+		wf.CreatedAt = s.now()
+		wf.UpdatedAt = wf.CreatedAt
+	}
+	s.workflows = append(s.workflows, wf)
+	return clone(wf)
+}
+
+// Job provides a copy of the stored job with the matching ID, or nil.
+// Changes to the copy will not affect the server's state.
+func (s *Server) Job(id string) *invopop.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.findJob(id)
+	if j == nil {
+		return nil
+	}
+	return clone(j)
+}
+
+// SetIntentRef associates a reference with a job's intent so that it can
+// later be updated using the ref instead of the intent or job IDs, like
+// a provider would do when returning a ref in a task result.
+func (s *Server) SetIntentRef(intentID, ref string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs == nil {
+		s.refs = make(map[string]string)
+	}
+	s.refs[ref] = intentID
+}
+
+func (s *Server) findWorkflow(id string) *invopop.Workflow {
+	for _, wf := range s.workflows {
+		if wf.ID == id {
+			return wf
+		}
+	}
+	return nil
+}
+
+func (s *Server) findJob(id string) *invopop.Job {
+	for _, j := range s.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (s *Server) listWorkflows(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	col := &invopop.WorkflowCollection{
+		List:      make([]*invopop.Workflow, 0),
+		CreatedAt: q.Get("created_at"),
+		Schema:    q.Get("schema"),
+		Limit:     defaultPageLimit,
+	}
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 {
+		col.Limit = int32(l)
+	}
+	for _, wf := range s.workflows {
+		if col.Schema != "" && wf.Schema != col.Schema {
+			continue
+		}
+		if col.CreatedAt != "" && wf.CreatedAt < col.CreatedAt {
+			continue
+		}
+		if len(col.List) == int(col.Limit) {
+			col.NextCreatedAt = wf.CreatedAt
+			break
+		}
+		col.List = append(col.List, wf)
+	}
+	writeJSON(w, http.StatusOK, col)
+}
+
+func (s *Server) fetchWorkflow(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wf := s.findWorkflow(r.PathValue("id"))
+	if wf == nil {
+		writeNotFound(w, "workflow")
+		return
+	}
+	writeJSON(w, http.StatusOK, wf)
+}
+
+func (s *Server) createWorkflow(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.CreateWorkflow)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	if wf := s.findWorkflow(id); wf != nil {
+		writeJSON(w, http.StatusOK, wf)
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "name: cannot be blank")
+		return
+	}
+	wf := &invopop.Workflow{
+		ID:          id,
+		CreatedAt:   s.now(),
+		Name:        req.Name,
+		Description: req.Description,
+		Schema:      req.Schema,
+		Country:     req.Country,
+		Steps:       req.Steps,
+		Rescue:      req.Rescue,
+	}
+	wf.UpdatedAt = wf.CreatedAt
+	s.workflows = append(s.workflows, wf)
+	writeJSON(w, http.StatusCreated, wf)
+}
+
+func (s *Server) updateWorkflow(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.UpdateWorkflow)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wf := s.findWorkflow(r.PathValue("id"))
+	if wf == nil {
+		writeNotFound(w, "workflow")
+		return
+	}
+	if req.Name != "" {
+		wf.Name = req.Name
+	}
+	if req.Description != "" {
+		wf.Description = req.Description
+	}
+	if req.Steps != nil {
+		wf.Steps = req.Steps
+	}
+	if req.Rescue != nil {
+		wf.Rescue = req.Rescue
+	}
+	wf.UpdatedAt = s.now()
+	writeJSON(w, http.StatusOK, wf)
+}
+
+func (s *Server) fetchJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.findJob(r.PathValue("id"))
+	if j == nil {
+		writeNotFound(w, "job")
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (s *Server) fetchJobByKey(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := r.PathValue("key")
+	for _, j := range s.jobs {
+		if j.Key != "" && j.Key == key {
+			writeJSON(w, http.StatusOK, j)
+			return
+		}
+	}
+	writeNotFound(w, "job")
+}
+
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.CreateJob)
+	if !decode(w, r, req) {
+		return
+	}
+	j, status, msg := s.prepareJob(r.PathValue("id"), req)
+	if msg != "" {
+		writeError(w, status, msg)
+		return
+	}
+	if status == http.StatusCreated && s.jh != nil {
+		// The handler may make requests to the server, so work on a copy
+		// to avoid holding the lock.
+		s.mu.Lock()
+		jc := clone(j)
+		s.mu.Unlock()
+		if err := s.jh(jc); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.mu.Lock()
+		*j = *jc
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, status, j)
+}
+
+// prepareJob validates and stores the new job, or provides the existing
+// one, alongside the status code and error message.
+func (s *Server) prepareJob(id string, req *invopop.CreateJob) (*invopop.Job, int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id == "" {
+		id = uuid.V7().String()
+	} else if j := s.findJob(id); j != nil {
+		return j, http.StatusOK, ""
+	}
+	if req.Key != "" {
+		for _, j := range s.jobs {
+			if j.Key == req.Key {
+				return nil, http.StatusConflict, "job key already exists"
+			}
+		}
+	}
+	if s.findWorkflow(req.WorkflowID) == nil {
+		return nil, http.StatusNotFound, "workflow not found"
+	}
+	tn := s.now()
+	j := &invopop.Job{
+		ID:          id,
+		CreatedAt:   tn,
+		UpdatedAt:   tn,
+		WorkflowID:  req.WorkflowID,
+		SiloEntryID: req.SiloEntryID,
+		Key:         req.Key,
+		Args:        req.Args,
+		Tags:        req.Tags,
+	}
+	switch {
+	case req.SiloEntryID != "":
+		if s.findEntry(req.SiloEntryID) == nil {
+			return nil, http.StatusNotFound, "silo entry not found"
+		}
+	case len(req.Data) > 0:
+		e := &invopop.SiloEntry{
+			ID:        uuid.V7().String(),
+			CreatedAt: tn,
+			UpdatedAt: tn,
+		}
+		if err := setEntryData(e, req.Data); err != nil {
+			return nil, http.StatusUnprocessableEntity, err.Error()
+		}
+		s.entries = append(s.entries, e)
+		j.SiloEntryID = e.ID
+	}
+	s.jobs = append(s.jobs, j)
+	return j, http.StatusCreated, ""
+}
+
+func (s *Server) updateIntent(w http.ResponseWriter, r *http.Request) {
+	req := new(invopop.UpdateIntent)
+	if !decode(w, r, req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Status == "" {
+		writeError(w, http.StatusUnprocessableEntity, "status: cannot be blank")
+		return
+	}
+	id := req.ID
+	if id == "" && req.Ref != "" {
+		id = s.refs[req.Ref]
+	}
+	var intent *invopop.JobIntent
+	for _, j := range s.jobs {
+		if req.JobID != "" && j.ID != req.JobID {
+			continue
+		}
+		for _, in := range j.Intents {
+			if id == "" || in.ID == id {
+				intent = in // last intent if not specific
+			}
+		}
+	}
+	if intent == nil || (id == "" && req.JobID == "") {
+		writeNotFound(w, "intent")
+		return
+	}
+	intent.Events = append(intent.Events, &invopop.JobIntentEvent{
+		Index:   int32(len(intent.Events)),
+		Status:  req.Status,
+		At:      s.now(),
+		Code:    req.Code,
+		Message: req.Message,
+	})
+	intent.UpdatedAt = s.now()
+	writeJSON(w, http.StatusOK, intent)
+}
@@ -0,0 +1,52 @@
+package invopop
+
+import (
+	"context"
+	"iter"
+)
+
+// pageFunc is used to fetch the next page of results from a collection
+// that supports pagination. The "more" flag indicates if there may be
+// more pages available after this one.
+type pageFunc[T any] func(ctx context.Context) (list []T, more bool, err error)
+
+// paginate provides an iterator that will call the page function until no more
+// results are available, the limit of items has been reached, or the
+// context is cancelled. A limit of zero implies no limit. Errors are yielded
+// alongside a nil item after which the iteration will stop. The pages
+// function is called each time the iterator is used so that every iteration
+// starts again from the first page.
+func paginate[T any](ctx context.Context, limit int, pages func() pageFunc[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		next := pages()
+		count := 0
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			list, more, err := next(ctx)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, item := range list {
+				if err := ctx.Err(); err != nil {
+					yield(zero, err)
+					return
+				}
+				if !yield(item, nil) {
+					return
+				}
+				count++
+				if limit > 0 && count >= limit {
+					return
+				}
+			}
+			if !more || len(list) == 0 {
+				return
+			}
+		}
+	}
+}
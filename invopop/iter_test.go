@@ -0,0 +1,117 @@
+package invopop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/flimzy/testy"
+	"resty.dev/v3"
+)
+
+func newPagesTestClient(pages map[string]string) *Client {
+	return New(func(c *Client) {
+		c.conn = resty.NewWithClient(testy.HTTPClient(func(r *http.Request) (*http.Response, error) {
+			q := r.URL.Query()
+			body, ok := pages[q.Get("cursor")+q.Get("created_at")]
+			if !ok {
+				return nil, fmt.Errorf("unexpected query: %s", r.URL.RawQuery)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		}))
+	})
+}
+
+func TestSiloEntriesAll(t *testing.T) {
+	c := newPagesTestClient(map[string]string{
+		"":   `{"list":[{"id":"1"},{"id":"2"}],"next_cursor":"c1"}`,
+		"c1": `{"list":[{"id":"3"}],"next_cursor":"c2"}`,
+		"c2": `{"list":[{"id":"4"}]}`,
+	})
+	ctx := context.Background()
+
+	t.Run("follows cursors", func(t *testing.T) {
+		req := &FindSiloEntries{Folder: "sales"}
+		var ids []string
+		for e, err := range c.Silo().Entries().All(ctx, req) {
+			require.NoError(t, err)
+			ids = append(ids, e.ID)
+		}
+		assert.Equal(t, []string{"1", "2", "3", "4"}, ids)
+		assert.Empty(t, req.Cursor, "request should not be modified")
+	})
+
+	t.Run("reused", func(t *testing.T) {
+		seq := c.Silo().Entries().All(ctx, &FindSiloEntries{Folder: "sales"})
+		for range 2 {
+			var ids []string
+			for e, err := range seq {
+				require.NoError(t, err)
+				ids = append(ids, e.ID)
+			}
+			assert.Equal(t, []string{"1", "2", "3", "4"}, ids)
+		}
+	})
+
+	t.Run("max items", func(t *testing.T) {
+		var ids []string
+		for e, err := range c.Silo().Entries().All(ctx, &FindSiloEntries{MaxItems: 3}) {
+			require.NoError(t, err)
+			ids = append(ids, e.ID)
+		}
+		assert.Equal(t, []string{"1", "2", "3"}, ids)
+	})
+
+	t.Run("stops on break", func(t *testing.T) {
+		var ids []string
+		for e := range c.Silo().Entries().All(ctx, nil) {
+			ids = append(ids, e.ID)
+			break
+		}
+		assert.Equal(t, []string{"1"}, ids)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var err error
+		for e, ierr := range c.Silo().Entries().All(ctx, nil) {
+			if ierr != nil {
+				err = ierr
+				break
+			}
+			if e.ID == "2" {
+				cancel()
+			}
+		}
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+}
+
+func TestWorkflowsAll(t *testing.T) {
+	c := newPagesTestClient(map[string]string{
+		"t0": `{"list":[{"id":"1"},{"id":"2"}],"next_created_at":"t1"}`,
+		"t1": `{"list":[{"id":"3"}]}`,
+	})
+	req := &FindWorkflows{CreatedAt: "t0"}
+	seq := c.Transform().Workflows().All(context.Background(), req)
+	for range 2 {
+		var ids []string
+		for wf, err := range seq {
+			require.NoError(t, err)
+			ids = append(ids, wf.ID)
+		}
+		assert.Equal(t, []string{"1", "2", "3"}, ids)
+	}
+	assert.Equal(t, "t0", req.CreatedAt, "request should not be modified")
+}
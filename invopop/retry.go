@@ -0,0 +1,125 @@
+package invopop
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/invopop/client.go/internal/retry"
+	"resty.dev/v3"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryMinWait     = 200 * time.Millisecond
+	defaultRetryMaxWait     = 10 * time.Second
+)
+
+// RetryPolicy defines how requests to the API should be retried when they
+// fail due to network errors or transient server responses (429, 502, 503,
+// and 504). Only idempotent methods (GET, HEAD, OPTIONS and DELETE) and PUTs
+// to paths ending with a client-supplied ID or key are retried. POST and
+// PATCH requests, and PUTs without an ID, are never retried as they may not
+// be safe to repeat.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts to make including the
+	// first request. Defaults to 3.
+	MaxAttempts int
+
+	// MinWait is the initial amount of time to wait between attempts,
+	// which will be doubled after each subsequent failure. Defaults to
+	// 200ms.
+	MinWait time.Duration
+
+	// MaxWait is the maximum amount of time to wait between attempts, both
+	// when calculating the exponential backoff and when following
+	// Retry-After headers. Defaults to 10s.
+	MaxWait time.Duration
+
+	// MaxElapsed, when set, caps the total amount of time spent on a request
+	// including all retries and waits by adding a deadline to the request's
+	// context.
+	MaxElapsed time.Duration
+}
+
+// WithRetryPolicy configures the client to automatically retry requests
+// according to the provided policy. Retry-After headers in responses will
+// take priority over the calculated backoff, up to the policy's MaxWait, and
+// no retries will be attempted if the wait would go beyond the context's
+// deadline.
+func WithRetryPolicy(rp *RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retry = rp.withDefaults()
+	}
+}
+
+func (rp *RetryPolicy) withDefaults() *RetryPolicy {
+	if rp == nil {
+		return nil
+	}
+	out := *rp
+	if out.MaxAttempts <= 0 {
+		out.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if out.MinWait <= 0 {
+		out.MinWait = defaultRetryMinWait
+	}
+	if out.MaxWait <= 0 {
+		out.MaxWait = defaultRetryMaxWait
+	}
+	if out.MaxWait < out.MinWait {
+		out.MaxWait = out.MinWait
+	}
+	return &out
+}
+
+// attempts provides the maximum number of attempts allowed for the method
+// and path.
+func (rp *RetryPolicy) attempts(method, p string) int {
+	if rp == nil {
+		return 1
+	}
+	switch method {
+	case resty.MethodGet, resty.MethodHead, resty.MethodOptions, resty.MethodDelete:
+		return rp.MaxAttempts
+	case resty.MethodPut:
+		// without an ID, the API may create a new resource each time
+		if strings.HasSuffix(pathTemplate(p), "/"+pathParam) {
+			return rp.MaxAttempts
+		}
+	}
+	return 1
+}
+
+// backoff calculates the time to wait after the provided attempt number,
+// starting at 1, using exponential backoff with jitter.
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	return retry.Backoff(attempt, rp.MinWait, rp.MaxWait)
+}
+
+// wait determines how long to wait before the next attempt, giving priority
+// to any Retry-After header provided in the response, limited to MaxWait.
+func (rp *RetryPolicy) wait(res *resty.Response, attempt int) time.Duration {
+	if res != nil {
+		if d, ok := retry.ParseRetryAfter(res.Header().Get("Retry-After")); ok {
+			return min(d, rp.MaxWait)
+		}
+	}
+	return rp.backoff(attempt)
+}
+
+// shouldRetry determines if the response or error from the last attempt
+// can be considered transient.
+func shouldRetry(res *resty.Response, err error) bool {
+	if err != nil {
+		return retry.IsTransientError(err)
+	}
+	switch res.StatusCode() {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
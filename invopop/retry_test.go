@@ -0,0 +1,166 @@
+package invopop
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/flimzy/testy"
+	"resty.dev/v3"
+)
+
+func newRetryTestClient(rp *RetryPolicy, fn testy.HTTPResponder) *Client {
+	c := &Client{
+		conn: resty.NewWithClient(testy.HTTPClient(fn)),
+	}
+	WithRetryPolicy(rp)(c)
+	return c
+}
+
+func statusResponse(status int, hdr http.Header) *http.Response {
+	if hdr == nil {
+		hdr = make(http.Header)
+	}
+	hdr.Set("Content-Type", "application/json")
+	return &http.Response{
+		StatusCode: status,
+		Header:     hdr,
+		Body:       io.NopCloser(strings.NewReader(`{"message":"status"}`)),
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	rp := &RetryPolicy{
+		MaxAttempts: 3,
+		MinWait:     time.Millisecond,
+		MaxWait:     5 * time.Millisecond,
+	}
+	ctx := context.Background()
+
+	t.Run("retries transient responses", func(t *testing.T) {
+		var count atomic.Int32
+		c := newRetryTestClient(rp, func(*http.Request) (*http.Response, error) {
+			if count.Add(1) < 3 {
+				return statusResponse(http.StatusServiceUnavailable, nil), nil
+			}
+			return statusResponse(http.StatusOK, nil), nil
+		})
+		err := c.get(ctx, "/test", new(Ping))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), count.Load())
+	})
+
+	t.Run("provides attempts in response error", func(t *testing.T) {
+		var count atomic.Int32
+		c := newRetryTestClient(rp, func(*http.Request) (*http.Response, error) {
+			count.Add(1)
+			return statusResponse(http.StatusTooManyRequests, nil), nil
+		})
+		err := c.put(ctx, "/test", map[string]string{"foo": "bar"}, new(Ping))
+		re := AsResponseError(err)
+		require.NotNil(t, re)
+		assert.Equal(t, http.StatusTooManyRequests, re.StatusCode())
+		assert.Equal(t, 3, re.Attempts())
+		assert.Equal(t, int32(3), count.Load())
+	})
+
+	t.Run("retries network errors", func(t *testing.T) {
+		var count atomic.Int32
+		c := newRetryTestClient(rp, func(*http.Request) (*http.Response, error) {
+			if count.Add(1) < 2 {
+				return nil, errors.New("network error")
+			}
+			return statusResponse(http.StatusOK, nil), nil
+		})
+		err := c.delete(ctx, "/test", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), count.Load())
+	})
+
+	t.Run("does not retry non-transient responses", func(t *testing.T) {
+		var count atomic.Int32
+		c := newRetryTestClient(rp, func(*http.Request) (*http.Response, error) {
+			count.Add(1)
+			return statusResponse(http.StatusInternalServerError, nil), nil
+		})
+		err := c.get(ctx, "/test", new(Ping))
+		assert.Equal(t, http.StatusInternalServerError, AsResponseError(err).StatusCode())
+		assert.Equal(t, int32(1), count.Load())
+	})
+
+	t.Run("does not retry post or patch", func(t *testing.T) {
+		var count atomic.Int32
+		c := newRetryTestClient(rp, func(*http.Request) (*http.Response, error) {
+			count.Add(1)
+			return statusResponse(http.StatusServiceUnavailable, nil), nil
+		})
+		err := c.post(ctx, "/test", map[string]string{}, new(Ping))
+		assert.Equal(t, 1, AsResponseError(err).Attempts())
+		err = c.patch(ctx, "/test", map[string]string{}, new(Ping))
+		assert.Equal(t, 1, AsResponseError(err).Attempts())
+		assert.Equal(t, int32(2), count.Load())
+	})
+
+	t.Run("only retries put with id", func(t *testing.T) {
+		var count atomic.Int32
+		c := newRetryTestClient(rp, func(*http.Request) (*http.Response, error) {
+			count.Add(1)
+			return statusResponse(http.StatusServiceUnavailable, nil), nil
+		})
+		err := c.put(ctx, path.Join(sequenceBasePath, seriesPath, ""), map[string]string{}, new(Ping))
+		assert.Equal(t, 1, AsResponseError(err).Attempts())
+		err = c.put(ctx, path.Join(sequenceBasePath, seriesPath, "series-1"), map[string]string{}, new(Ping))
+		assert.Equal(t, 3, AsResponseError(err).Attempts())
+		assert.Equal(t, int32(4), count.Load())
+	})
+
+	t.Run("limits retry-after to max wait", func(t *testing.T) {
+		var count atomic.Int32
+		c := newRetryTestClient(rp, func(*http.Request) (*http.Response, error) {
+			count.Add(1)
+			hdr := make(http.Header)
+			hdr.Set("Retry-After", "3600")
+			return statusResponse(http.StatusTooManyRequests, hdr), nil
+		})
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		err := c.get(ctx, "/test", new(Ping))
+		assert.Equal(t, http.StatusTooManyRequests, AsResponseError(err).StatusCode())
+		assert.Equal(t, int32(3), count.Load())
+	})
+
+	t.Run("honours retry-after within context deadline", func(t *testing.T) {
+		var count atomic.Int32
+		rp := &RetryPolicy{MaxAttempts: 3, MaxWait: time.Minute}
+		c := newRetryTestClient(rp, func(*http.Request) (*http.Response, error) {
+			count.Add(1)
+			hdr := make(http.Header)
+			hdr.Set("Retry-After", "60")
+			return statusResponse(http.StatusTooManyRequests, hdr), nil
+		})
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		err := c.get(ctx, "/test", new(Ping))
+		assert.Equal(t, http.StatusTooManyRequests, AsResponseError(err).StatusCode())
+		assert.Equal(t, int32(1), count.Load())
+	})
+
+	t.Run("no policy", func(t *testing.T) {
+		var count atomic.Int32
+		c := newRetryTestClient(nil, func(*http.Request) (*http.Response, error) {
+			count.Add(1)
+			return statusResponse(http.StatusServiceUnavailable, nil), nil
+		})
+		err := c.get(ctx, "/test", new(Ping))
+		assert.Equal(t, 1, AsResponseError(err).Attempts())
+		assert.Equal(t, int32(1), count.Load())
+	})
+}
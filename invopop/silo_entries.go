@@ -3,6 +3,7 @@ package invopop
 import (
 	"context"
 	"encoding/json"
+	"iter"
 	"net/url"
 	"path"
 	"strconv"
@@ -93,6 +94,10 @@ type FindSiloEntries struct {
 	CreatedAt string `query:"created_at" title:"Created At" description:"Date from which results are provided." example:"2023-08-02T00:00:00.000Z"`
 	Cursor    string `query:"cursor" title:"Cursor" description:"Position provided by the previous result's next_cursor property."`
 	Limit     int32  `query:"limit" title:"Limit" description:"Maximum number of entries to show in a page of results." example:"20"`
+
+	// MaxItems is only used by the All method to limit the total number of
+	// entries provided by the iterator. Zero implies no limit.
+	MaxItems int `query:"-"`
 }
 
 // List provides a list of the silo entries that belong to the user. Pagination is supported
@@ -119,6 +124,40 @@ func (svc *SiloEntriesService) List(ctx context.Context, req *FindSiloEntries) (
 	return col, svc.client.get(ctx, p, col)
 }
 
+// All provides an iterator over all the silo entries that match the request,
+// automatically following the next cursor of each page of results until there
+// are no more entries, the request's MaxItems is reached, or the context is
+// cancelled. Any error will be provided with a nil entry and end the iteration.
+// Usage example:
+//
+//	for e, err := range ic.Silo().Entries().All(ctx, req) {
+//		if err != nil {
+//			return err
+//		}
+//		// do something with the entry
+//	}
+func (svc *SiloEntriesService) All(ctx context.Context, req *FindSiloEntries) iter.Seq2[*SiloEntry, error] {
+	var limit int
+	if req != nil {
+		limit = req.MaxItems
+	}
+	return paginate(ctx, limit, func() pageFunc[*SiloEntry] {
+		// each iteration needs its own copy to follow the cursors
+		find := new(FindSiloEntries)
+		if req != nil {
+			*find = *req
+		}
+		return func(ctx context.Context) ([]*SiloEntry, bool, error) {
+			col, err := svc.List(ctx, find)
+			if err != nil {
+				return nil, false, err
+			}
+			find.Cursor = col.NextCursor
+			return col.List, col.NextCursor != "", nil
+		}
+	})
+}
+
 // Fetch loads the requested silo entry by its ID.
 func (svc *SiloEntriesService) Fetch(ctx context.Context, id string) (*SiloEntry, error) {
 	e := new(SiloEntry)
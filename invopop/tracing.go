@@ -0,0 +1,131 @@
+package invopop
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"resty.dev/v3"
+)
+
+const tracerName = "github.com/invopop/client.go/invopop"
+
+// pathParam replaces dynamic path segments like IDs and keys in span names
+// so that they can be grouped together.
+const pathParam = "{id}"
+
+// staticPathSegments contains all the known fixed parts of API paths, any other
+// segments will be considered parameters when preparing path templates.
+var staticPathSegments = pathSegments(
+	utilsBaseURL, pingPath,
+	sequenceBasePath, seriesPath, seriesEntriesPath,
+	transformBasePath, jobsPath, intentsPath, jobsKeyPath, workflowsPath,
+	siloBasePath, entriesPath, entriesKeyPath, metaPath, siloFilesPath, spoolPath,
+	goblPath, goblBuildPath, goblSignPath,
+	accessBasePath, enrollmentPath, authorizePath, workspacePath, orgsPath,
+)
+
+// defaultPropagator handles the W3C trace context and baggage headers.
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// WithTracerProvider enables OpenTelemetry instrumentation so that a span
+// will be created for each API call using the provided tracer provider. The
+// trace context will be injected into outgoing requests using the
+// propagator, see WithPropagator.
+func WithTracerProvider(tp trace.TracerProvider) ClientOption {
+	return func(c *Client) {
+		c.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithPropagator sets the propagator used to inject the trace context into
+// outgoing requests, which defaults to the W3C trace context and baggage
+// formats. Use otel.GetTextMapPropagator() to use the global propagator
+// instead.
+func WithPropagator(p propagation.TextMapPropagator) ClientOption {
+	return func(c *Client) {
+		c.propagator = p
+	}
+}
+
+// startSpan will start a new client span for the request, if tracing is enabled,
+// and inject the trace context into the request headers.
+func (c *Client) startSpan(ctx context.Context, method, p string) (context.Context, trace.Span) {
+	if c.tracer == nil {
+		return ctx, nil
+	}
+	tmpl := pathTemplate(p)
+	ctx, span := c.tracer.Start(ctx, method+" "+tmpl,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.template", tmpl),
+		),
+	)
+	return ctx, span
+}
+
+// injectTrace adds the trace context headers to the request using the
+// configured propagator, or the default.
+func (c *Client) injectTrace(ctx context.Context, req *resty.Request) {
+	p := c.propagator
+	if p == nil {
+		p = defaultPropagator
+	}
+	p.Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// endSpan records the results of the request in the span.
+func endSpan(span trace.Span, res *resty.Response, attempts int, err error) {
+	if span == nil {
+		return
+	}
+	defer span.End()
+	if attempts > 1 {
+		span.SetAttributes(attribute.Int("http.request.resend_count", attempts-1))
+	}
+	if res != nil && res.StatusCode() != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode()))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// pathTemplate replaces any dynamic segments of the path with a placeholder
+// and removes the query.
+func pathTemplate(p string) string {
+	if u, err := url.Parse(p); err == nil {
+		p = u.Path
+	}
+	parts := strings.Split(p, "/")
+	for i, s := range parts {
+		if s == "" {
+			continue
+		}
+		if _, ok := staticPathSegments[s]; !ok {
+			parts[i] = pathParam
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func pathSegments(paths ...string) map[string]struct{} {
+	m := make(map[string]struct{})
+	for _, p := range paths {
+		for _, s := range strings.Split(p, "/") {
+			if s != "" {
+				m[s] = struct{}{}
+			}
+		}
+	}
+	return m
+}
@@ -0,0 +1,57 @@
+package invopop
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/flimzy/testy"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"resty.dev/v3"
+)
+
+func TestPathTemplate(t *testing.T) {
+	tests := map[string]string{
+		"/silo/v1/entries/0190a63b-4c1e-7c7f-8d3c-2b7e2ab1e0a1":           "/silo/v1/entries/{id}",
+		"/silo/v1/entries/key/invoice-101":                                "/silo/v1/entries/key/{id}",
+		"/silo/v1/entries/abc/meta/provider":                              "/silo/v1/entries/{id}/meta/{id}",
+		"/silo/v1/entries?limit=10&folder=sales":                          "/silo/v1/entries",
+		"/transform/v1/jobs/0190a63b-4c1e-7c7f-8d3c-2b7e2ab1e0a1?wait=10": "/transform/v1/jobs/{id}",
+		"/utils/v1/ping": "/utils/v1/ping",
+	}
+	for in, out := range tests {
+		assert.Equal(t, out, pathTemplate(in), in)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	var traceparent string
+	c := New(WithTracerProvider(tp), func(c *Client) {
+		c.conn = resty.NewWithClient(testy.HTTPClient(func(r *http.Request) (*http.Response, error) {
+			traceparent = r.Header.Get("traceparent")
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"message":"not found"}`)),
+			}, nil
+		}))
+	})
+
+	_, err := c.Silo().Entries().Fetch(context.Background(), "0190a63b-4c1e-7c7f-8d3c-2b7e2ab1e0a1")
+	assert.True(t, IsNotFound(err))
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /silo/v1/entries/{id}", span.Name())
+	assert.Contains(t, span.Attributes(), attribute.Int("http.response.status_code", http.StatusNotFound))
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+}
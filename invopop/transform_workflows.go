@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"iter"
 	"net/url"
 	"path"
 	"strconv"
@@ -90,6 +91,10 @@ type FindWorkflows struct {
 	Limit     int32
 	CreatedAt string
 	Schema    string
+
+	// MaxItems is only used by the All method to limit the total number of
+	// workflows provided by the iterator. Zero implies no limit.
+	MaxItems int
 }
 
 // Fetch makes a request for the workflow by its ID.
@@ -141,3 +146,30 @@ func (svc *WorkflowsService) List(ctx context.Context, req *FindWorkflows) (*Wor
 	m := new(WorkflowCollection)
 	return m, svc.client.get(ctx, p, m)
 }
+
+// All provides an iterator over all the workflows that match the request,
+// automatically following the "next_created_at" property of each page of
+// results until there are no more workflows, the request's MaxItems is reached,
+// or the context is cancelled. Any error will be provided with a nil workflow
+// and end the iteration.
+func (svc *WorkflowsService) All(ctx context.Context, req *FindWorkflows) iter.Seq2[*Workflow, error] {
+	var limit int
+	if req != nil {
+		limit = req.MaxItems
+	}
+	return paginate(ctx, limit, func() pageFunc[*Workflow] {
+		// each iteration needs its own copy to follow the pages
+		find := new(FindWorkflows)
+		if req != nil {
+			*find = *req
+		}
+		return func(ctx context.Context) ([]*Workflow, bool, error) {
+			col, err := svc.List(ctx, find)
+			if err != nil {
+				return nil, false, err
+			}
+			find.CreatedAt = col.NextCreatedAt
+			return col.List, col.NextCreatedAt != "", nil
+		}
+	})
+}
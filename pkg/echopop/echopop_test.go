@@ -1,11 +1,17 @@
 package echopop_test
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/invopop/client.go/gateway"
+	"github.com/invopop/client.go/gateway/gatewaytest"
 	"github.com/invopop/client.go/pkg/echopop"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetEnrollment(t *testing.T) {
@@ -27,3 +33,28 @@ func TestGetClient(t *testing.T) {
 		})
 	})
 }
+
+func TestWebhook(t *testing.T) {
+	srv := gatewaytest.NewServer()
+	t.Cleanup(srv.Close)
+	gw := srv.Client("test")
+
+	e := echo.New()
+	e.POST("/webhook", echopop.Webhook(gw, func(r *http.Request) (*gateway.TaskPoke, error) {
+		return &gateway.TaskPoke{Ref: r.URL.Query().Get("ref")}, nil
+	}, gateway.WithWebhookVerifier(gateway.WebhookSharedSecret("Authorization", "secret"))))
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook?ref=ext-1", strings.NewReader("{}"))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/webhook?ref=ext-1", strings.NewReader("{}"))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	pokes := srv.Pokes()
+	require.Len(t, pokes, 1)
+	assert.Equal(t, "ext-1", pokes[0].Ref)
+}
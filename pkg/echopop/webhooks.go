@@ -0,0 +1,19 @@
+package echopop
+
+import (
+	"github.com/invopop/client.go/gateway"
+	"github.com/labstack/echo/v4"
+)
+
+// Webhook provides an Echo handler that converts incoming webhook requests
+// into gateway pokes, with the same verification, retries, and response
+// codes as gateway.Client.WebhookHandler.
+//
+// Usage example:
+//
+//	e.POST("/webhook", echopop.Webhook(gw, extractRef,
+//		gateway.WithWebhookVerifier(gateway.WebhookHMAC("X-Signature", secret)),
+//	))
+func Webhook(gw *gateway.Client, fn gateway.WebhookPokeFunc, opts ...gateway.WebhookOption) echo.HandlerFunc {
+	return echo.WrapHandler(gw.WebhookHandler(fn, opts...))
+}
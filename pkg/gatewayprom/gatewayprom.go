@@ -0,0 +1,189 @@
+// Package gatewayprom provides a Prometheus implementation of the gateway
+// client's Metrics interface.
+//
+// Usage example:
+//
+//	m := gatewayprom.New()
+//	prometheus.MustRegister(m)
+//	gw := gateway.New(
+//		gateway.WithConfig(conf),
+//		gateway.WithTaskHandler(handler),
+//		gateway.WithMetrics(m),
+//	)
+//
+// All metrics are labelled with the gateway service name, and where
+// relevant, the task action.
+package gatewayprom
+
+import (
+	"strings"
+	"time"
+
+	"github.com/invopop/client.go/gateway"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultNamespace = "invopop"
+	subsystem        = "gateway"
+)
+
+const (
+	labelService = "service"
+	labelAction  = "action"
+	labelStatus  = "status"
+	labelResult  = "result"
+)
+
+// Upload results used in labels.
+const (
+	resultOK    = "ok"
+	resultError = "error"
+)
+
+// Metrics implements the gateway.Metrics interface using Prometheus
+// collectors. It must be registered with a Prometheus registry in order for
+// the metrics to be exported.
+type Metrics struct {
+	queueDepth     *prometheus.GaugeVec
+	tasksInFlight  *prometheus.GaugeVec
+	tasksTotal     *prometheus.CounterVec
+	taskDuration   *prometheus.HistogramVec
+	panicsTotal    *prometheus.CounterVec
+	uploadDuration *prometheus.HistogramVec
+}
+
+type options struct {
+	namespace string
+	buckets   []float64
+}
+
+// Option is used to configure the metrics.
+type Option func(o *options)
+
+// WithNamespace replaces the default "invopop" namespace used as a prefix
+// for all metric names.
+func WithNamespace(ns string) Option {
+	return func(o *options) {
+		o.namespace = ns
+	}
+}
+
+// WithBuckets sets the histogram buckets, in seconds, used for task and
+// upload durations. Prometheus' default buckets are used otherwise.
+func WithBuckets(buckets ...float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+var _ gateway.Metrics = (*Metrics)(nil)
+var _ prometheus.Collector = (*Metrics)(nil)
+
+// New prepares a new set of gateway metrics.
+func New(opts ...Option) *Metrics {
+	o := &options{
+		namespace: defaultNamespace,
+		buckets:   prometheus.DefBuckets,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Metrics{
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: o.namespace,
+			Subsystem: subsystem,
+			Name:      "queue_depth",
+			Help:      "Number of incoming tasks waiting for a worker.",
+		}, []string{labelService}),
+		tasksInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: o.namespace,
+			Subsystem: subsystem,
+			Name:      "tasks_in_flight",
+			Help:      "Number of tasks currently being processed.",
+		}, []string{labelService, labelAction}),
+		tasksTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Subsystem: subsystem,
+			Name:      "tasks_total",
+			Help:      "Number of tasks processed by result status.",
+		}, []string{labelService, labelAction, labelStatus}),
+		taskDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Subsystem: subsystem,
+			Name:      "task_duration_seconds",
+			Help:      "Time taken to process tasks.",
+			Buckets:   o.buckets,
+		}, []string{labelService, labelAction}),
+		panicsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Subsystem: subsystem,
+			Name:      "task_panics_total",
+			Help:      "Number of panics recovered from task handlers.",
+		}, []string{labelService, labelAction}),
+		uploadDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Subsystem: subsystem,
+			Name:      "file_upload_duration_seconds",
+			Help:      "Time taken to upload files to the silo.",
+			Buckets:   o.buckets,
+		}, []string{labelService, labelResult}),
+	}
+}
+
+// QueueDepth sets the current number of tasks waiting for a worker.
+func (m *Metrics) QueueDepth(service string, depth int) {
+	m.queueDepth.WithLabelValues(service).Set(float64(depth))
+}
+
+// TaskStarted increments the number of tasks in flight.
+func (m *Metrics) TaskStarted(service, action string) {
+	m.tasksInFlight.WithLabelValues(service, action).Inc()
+}
+
+// TaskCompleted decrements the number of tasks in flight and records the
+// result status and duration.
+func (m *Metrics) TaskCompleted(service, action string, status gateway.TaskStatus, dur time.Duration) {
+	m.tasksInFlight.WithLabelValues(service, action).Dec()
+	m.tasksTotal.WithLabelValues(service, action, strings.ToLower(status.String())).Inc()
+	m.taskDuration.WithLabelValues(service, action).Observe(dur.Seconds())
+}
+
+// TaskPanicked increments the number of recovered panics.
+func (m *Metrics) TaskPanicked(service, action string) {
+	m.panicsTotal.WithLabelValues(service, action).Inc()
+}
+
+// FileUploaded records the total duration of the upload, including retries.
+func (m *Metrics) FileUploaded(service string, dur time.Duration, err error) {
+	result := resultOK
+	if err != nil {
+		result = resultError
+	}
+	m.uploadDuration.WithLabelValues(service, result).Observe(dur.Seconds())
+}
+
+// Describe implements the prometheus.Collector interface.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements the prometheus.Collector interface.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.queueDepth,
+		m.tasksInFlight,
+		m.tasksTotal,
+		m.taskDuration,
+		m.panicsTotal,
+		m.uploadDuration,
+	}
+}
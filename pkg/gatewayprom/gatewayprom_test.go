@@ -0,0 +1,65 @@
+package gatewayprom_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/invopop/client.go/gateway"
+	"github.com/invopop/client.go/pkg/gatewayprom"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := gatewayprom.New(gatewayprom.WithBuckets(0.1, 1))
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(m))
+
+	m.QueueDepth("test", 3)
+	m.TaskStarted("test", "sign")
+	m.TaskStarted("test", "sign")
+	m.TaskCompleted("test", "sign", gateway.TaskStatus_OK, 50*time.Millisecond)
+	m.TaskPanicked("test", "sign")
+	m.FileUploaded("test", 2*time.Second, errors.New("failed"))
+
+	expected := `
+# HELP invopop_gateway_queue_depth Number of incoming tasks waiting for a worker.
+# TYPE invopop_gateway_queue_depth gauge
+invopop_gateway_queue_depth{service="test"} 3
+# HELP invopop_gateway_tasks_in_flight Number of tasks currently being processed.
+# TYPE invopop_gateway_tasks_in_flight gauge
+invopop_gateway_tasks_in_flight{action="sign",service="test"} 1
+# HELP invopop_gateway_tasks_total Number of tasks processed by result status.
+# TYPE invopop_gateway_tasks_total counter
+invopop_gateway_tasks_total{action="sign",service="test",status="ok"} 1
+# HELP invopop_gateway_task_panics_total Number of panics recovered from task handlers.
+# TYPE invopop_gateway_task_panics_total counter
+invopop_gateway_task_panics_total{action="sign",service="test"} 1
+# HELP invopop_gateway_file_upload_duration_seconds Time taken to upload files to the silo.
+# TYPE invopop_gateway_file_upload_duration_seconds histogram
+invopop_gateway_file_upload_duration_seconds_bucket{result="error",service="test",le="0.1"} 0
+invopop_gateway_file_upload_duration_seconds_bucket{result="error",service="test",le="1"} 0
+invopop_gateway_file_upload_duration_seconds_bucket{result="error",service="test",le="+Inf"} 1
+invopop_gateway_file_upload_duration_seconds_sum{result="error",service="test"} 2
+invopop_gateway_file_upload_duration_seconds_count{result="error",service="test"} 1
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"invopop_gateway_queue_depth",
+		"invopop_gateway_tasks_in_flight",
+		"invopop_gateway_tasks_total",
+		"invopop_gateway_task_panics_total",
+		"invopop_gateway_file_upload_duration_seconds",
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(m, "invopop_gateway_task_duration_seconds"))
+}
+
+func TestWithNamespace(t *testing.T) {
+	m := gatewayprom.New(gatewayprom.WithNamespace("acme"))
+	m.TaskPanicked("test", "sign")
+	assert.Equal(t, 1, testutil.CollectAndCount(m, "acme_gateway_task_panics_total"))
+}